@@ -11,19 +11,49 @@ import (
 	"github.com/aanujj/fluent-forward-go/fluent/protocol"
 )
 
-func Benchmark_Fluent_Forward_Go_SendOnly(b *testing.B) {
-	tagVar := "bar"
+// timestamp is fixed so that every run encodes identical messages.
+var timestamp = protocol.EventTime{Time: time.Unix(1640995200, 123456789)}
+
+func newClient(b *testing.B, requireAck bool) *client.Client {
+	b.Helper()
 
 	c := client.New(client.ConnectionOptions{
+		Factory: &client.ConnFactory{
+			Address: bm.Address(b),
+		},
+		RequireAck:        requireAck,
 		ConnectionTimeout: 3 * time.Second,
 	})
 
-	err := c.Connect()
-	if err != nil {
+	if err := c.Connect(); err != nil {
 		b.Fatal(err)
 	}
 
-	defer c.Disconnect()
+	b.Cleanup(func() {
+		_ = c.Disconnect()
+	})
+
+	return c
+}
+
+func makeEntries(n int) protocol.EntryList {
+	record := bm.MakeRecord(12)
+	entries := make(protocol.EntryList, n)
+
+	for i := range entries {
+		entries[i] = protocol.EntryExt{
+			Timestamp: timestamp,
+			Record:    record,
+		}
+	}
+
+	return entries
+}
+
+func Benchmark_Fluent_Forward_Go_SendOnly(b *testing.B) {
+	tagVar := "bar"
+
+	c := newClient(b, false)
 
 	record := bm.MakeRecord(12)
 	mne := protocol.NewMessage(tagVar, record)
@@ -32,7 +62,7 @@ func Benchmark_Fluent_Forward_Go_SendOnly(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		err = c.Send(mne)
+		err := c.Send(mne)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -42,16 +72,7 @@ func Benchmark_Fluent_Forward_Go_SendOnly(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_SingleMessage(b *testing.B) {
 	tagVar := "bar"
 
-	c := client.New(client.ConnectionOptions{
-		ConnectionTimeout: 3 * time.Second,
-	})
-
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	defer c.Disconnect()
+	c := newClient(b, false)
 
 	record := bm.MakeRecord(12)
 
@@ -60,7 +81,7 @@ func Benchmark_Fluent_Forward_Go_SingleMessage(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		mne := protocol.NewMessage(tagVar, record)
-		err = c.Send(mne)
+		err := c.Send(mne)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -70,26 +91,16 @@ func Benchmark_Fluent_Forward_Go_SingleMessage(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_SingleMessageAck(b *testing.B) {
 	tagVar := "foo"
 
-	c := client.New(client.ConnectionOptions{
-		RequireAck:        true,
-		ConnectionTimeout: 3 * time.Second,
-	})
-
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
+	c := newClient(b, true)
 
 	record := bm.MakeRecord(12)
 
-	defer c.Disconnect()
-
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
 		mne := protocol.NewMessage(tagVar, record)
-		err = c.Send(mne)
+		err := c.Send(mne)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -99,16 +110,7 @@ func Benchmark_Fluent_Forward_Go_SingleMessageAck(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_Bytes(b *testing.B) {
 	tagVar := "foo"
 
-	c := client.New(client.ConnectionOptions{
-		ConnectionTimeout: 3 * time.Second,
-	})
-
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	defer c.Disconnect()
+	c := newClient(b, false)
 
 	record := bm.MakeRecord(12)
 	mne := protocol.NewMessage(tagVar, record)
@@ -119,7 +121,7 @@ func Benchmark_Fluent_Forward_Go_Bytes(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		err = c.SendRaw(bits)
+		err := c.SendRaw(bits)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -129,17 +131,7 @@ func Benchmark_Fluent_Forward_Go_Bytes(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_BytesAck(b *testing.B) {
 	tagVar := "foo"
 
-	c := client.New(client.ConnectionOptions{
-		RequireAck:        true,
-		ConnectionTimeout: 3 * time.Second,
-	})
-
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	defer c.Disconnect()
+	c := newClient(b, true)
 
 	record := bm.MakeRecord(12)
 	mne := protocol.NewMessage(tagVar, record)
@@ -151,7 +143,7 @@ func Benchmark_Fluent_Forward_Go_BytesAck(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		err = c.SendRaw(bits)
+		err := c.SendRaw(bits)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -161,16 +153,7 @@ func Benchmark_Fluent_Forward_Go_BytesAck(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_RawMessage(b *testing.B) {
 	tagVar := "foo"
 
-	c := client.New(client.ConnectionOptions{
-		ConnectionTimeout: 3 * time.Second,
-	})
-
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	defer c.Disconnect()
+	c := newClient(b, false)
 
 	record := bm.MakeRecord(12)
 	mne := protocol.NewMessage(tagVar, record)
@@ -182,7 +165,7 @@ func Benchmark_Fluent_Forward_Go_RawMessage(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		rbits := protocol.RawMessage(bits)
-		err = c.Send(rbits)
+		err := c.Send(rbits)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -192,17 +175,7 @@ func Benchmark_Fluent_Forward_Go_RawMessage(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_RawMessageAck(b *testing.B) {
 	tagVar := "foo"
 
-	c := client.New(client.ConnectionOptions{
-		RequireAck:        true,
-		ConnectionTimeout: 3 * time.Second,
-	})
-
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	defer c.Disconnect()
+	c := newClient(b, true)
 
 	record := bm.MakeRecord(12)
 	mne := protocol.NewMessage(tagVar, record)
@@ -215,7 +188,7 @@ func Benchmark_Fluent_Forward_Go_RawMessageAck(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		rbits := protocol.RawMessage(bits)
-		err = c.Send(rbits)
+		err := c.Send(rbits)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -225,108 +198,177 @@ func Benchmark_Fluent_Forward_Go_RawMessageAck(b *testing.B) {
 func Benchmark_Fluent_Forward_Go_CompressedMessage(b *testing.B) {
 	tagVar := "foo"
 
-	c := client.New(client.ConnectionOptions{
-		ConnectionTimeout: 3 * time.Second,
-	})
+	c := newClient(b, false)
 
-	err := c.Connect()
-	if err != nil {
-		b.Fatal(err)
-	}
+	entries := makeEntries(6)
 
-	defer c.Disconnect()
+	b.ReportAllocs()
+	b.ResetTimer()
 
-	record := bm.MakeRecord(12)
-	entries := []protocol.EntryExt{
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
+	for i := 0; i < b.N; i++ {
+		mne, _ := protocol.NewCompressedPackedForwardMessage(tagVar, entries)
+		err := c.Send(mne)
+		if err != nil {
+			b.Fatal(err)
+		}
 	}
+}
+
+func Benchmark_Fluent_Forward_Go_CompressedMessageAck(b *testing.B) {
+	tagVar := "foo"
+
+	c := newClient(b, true)
+
+	entries := makeEntries(6)
 
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
 		mne, _ := protocol.NewCompressedPackedForwardMessage(tagVar, entries)
-		err = c.Send(mne)
+		err := c.Send(mne)
 		if err != nil {
 			b.Fatal(err)
 		}
 	}
 }
 
-func Benchmark_Fluent_Forward_Go_CompressedMessageAck(b *testing.B) {
-	tagVar := "foo"
+// Benchmark_Fluent_Forward_Go_Send covers every Send* helper, with and
+// without acks.
+func Benchmark_Fluent_Forward_Go_Send(b *testing.B) {
+	const tagVar = "foo"
 
-	c := client.New(client.ConnectionOptions{
-		RequireAck:        true,
-		ConnectionTimeout: 3 * time.Second,
-	})
+	record := bm.MakeRecord(12)
+	entries := makeEntries(6)
 
-	err := c.Connect()
+	packed, err := protocol.NewPackedForwardMessage(tagVar, entries)
 	if err != nil {
 		b.Fatal(err)
 	}
 
-	defer c.Disconnect()
+	compressed, err := protocol.NewCompressedPackedForwardMessage(tagVar, entries)
+	if err != nil {
+		b.Fatal(err)
+	}
 
-	record := bm.MakeRecord(12)
-	entries := []protocol.EntryExt{
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
-		{
-			Timestamp: protocol.EventTimeNow(),
-			Record:    record,
-		},
+	modes := []struct {
+		name string
+		send func(c *client.Client) error
+	}{
+		{"Message", func(c *client.Client) error { return c.SendMessage(tagVar, record) }},
+		{"MessageExt", func(c *client.Client) error { return c.SendMessageExt(tagVar, record) }},
+		{"Forward", func(c *client.Client) error { return c.SendForward(tagVar, entries) }},
+		{"Packed", func(c *client.Client) error { return c.SendPacked(tagVar, entries) }},
+		{"PackedFromBytes", func(c *client.Client) error { return c.SendPackedFromBytes(tagVar, packed.EventStream) }},
+		{"Compressed", func(c *client.Client) error { return c.SendCompressed(tagVar, entries) }},
+		{"CompressedFromBytes", func(c *client.Client) error {
+			return c.SendCompressedFromBytes(tagVar, compressed.EventStream)
+		}},
 	}
 
+	for _, m := range modes {
+		for _, ack := range []bool{false, true} {
+			name := m.name
+			if ack {
+				name += "Ack"
+			}
+
+			send := m.send
+
+			b.Run(name, func(b *testing.B) {
+				c := newClient(b, ack)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					if err := send(c); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Benchmark_Fluent_Forward_Go_PipelinedAck keeps several acks in flight.
+// A Client waits for each ack before its next send, so every goroutine
+// sends through its own connection; compare with SingleMessageAck, where
+// each send waits for the previous ack.
+func Benchmark_Fluent_Forward_Go_PipelinedAck(b *testing.B) {
+	tagVar := "foo"
+
+	addr := bm.Address(b)
+	record := bm.MakeRecord(12)
+
 	b.ReportAllocs()
 	b.ResetTimer()
 
-	for i := 0; i < b.N; i++ {
-		mne, _ := protocol.NewCompressedPackedForwardMessage(tagVar, entries)
-		err = c.Send(mne)
-		if err != nil {
-			b.Fatal(err)
+	b.RunParallel(func(pb *testing.PB) {
+		c := client.New(client.ConnectionOptions{
+			Factory: &client.ConnFactory{
+				Address: addr,
+			},
+			RequireAck:        true,
+			ConnectionTimeout: 3 * time.Second,
+		})
+
+		if err := c.Connect(); err != nil {
+			b.Error(err)
+			return
 		}
+
+		defer c.Disconnect()
+
+		for pb.Next() {
+			mne := protocol.NewMessage(tagVar, record)
+			if err := c.Send(mne); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func Benchmark_Fluent_Forward_Go_WSClient(b *testing.B) {
+	tagVar := "foo"
+
+	r := bm.StartReceiver(b)
+
+	c := client.NewWS(client.WSConnectionOptions{
+		Factory: &client.DefaultWSConnectionFactory{
+			URL: r.URL,
+		},
+	})
+
+	if err := c.Connect(); err != nil {
+		b.Fatal(err)
 	}
+
+	defer c.Disconnect()
+
+	record := bm.MakeRecord(12)
+	entries := makeEntries(6)
+
+	b.Run("Message", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			mne := protocol.NewMessage(tagVar, record)
+			if err := c.Send(mne); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Compressed", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			mne, _ := protocol.NewCompressedPackedForwardMessage(tagVar, entries)
+			if err := c.Send(mne); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
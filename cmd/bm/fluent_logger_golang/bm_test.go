@@ -3,6 +3,8 @@ package main
 //go test -benchmem -run=^$ -bench ^Benchmark.*$ github.com/aanujj/fluent-forward-go/cmd/bm/fluent_logger_golang
 
 import (
+	"net"
+	"strconv"
 	"testing"
 	"time"
 
@@ -10,10 +12,27 @@ import (
 	"github.com/fluent/fluent-logger-golang/fluent"
 )
 
+// config points the logger at the forward server from bm.Address.
+func config(b *testing.B, c fluent.Config) fluent.Config {
+	b.Helper()
+
+	host, port, err := net.SplitHostPort(bm.Address(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c.FluentHost = host
+	if c.FluentPort, err = strconv.Atoi(port); err != nil {
+		b.Fatal(err)
+	}
+
+	return c
+}
+
 func Benchmark_Fluent_Logger_Golang_SingleMessage(b *testing.B) {
-	logger, err := fluent.New(fluent.Config{
+	logger, err := fluent.New(config(b, fluent.Config{
 		SubSecondPrecision: false,
-	})
+	}))
 
 	if err != nil {
 		b.Fatal(err)
@@ -35,11 +54,11 @@ func Benchmark_Fluent_Logger_Golang_SingleMessage(b *testing.B) {
 }
 
 func Benchmark_Fluent_Logger_Golang_SingleMessageAck(b *testing.B) {
-	logger, err := fluent.New(fluent.Config{
+	logger, err := fluent.New(config(b, fluent.Config{
 		Timeout:            3 * time.Second,
 		RequestAck:         true,
 		SubSecondPrecision: false,
-	})
+	}))
 
 	if err != nil {
 		b.Fatal(err)
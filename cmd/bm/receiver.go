@@ -0,0 +1,237 @@
+package bm
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// AddressEnv names the environment variable that points the benchmarks at
+// an external forward server, such as Fluent Bit, instead of the built-in
+// receiver.
+const AddressEnv = "BM_FORWARD_ADDRESS"
+
+// Receiver is a minimal forward receiver for benchmarks. It reads each
+// message just far enough to find its chunk option and acks it, so that
+// the client dominates the measurements.
+//
+// Receiver deliberately avoids the protocol package: fluent-logger-golang
+// registers the same msgp extension, and both cannot be linked into the
+// same test binary.
+type Receiver struct {
+	// Addr is the TCP address, in the form "127.0.0.1:port".
+	Addr string
+	// URL is the websocket URL, in the form "ws://127.0.0.1:port".
+	URL string
+
+	listener net.Listener
+	httpSvr  *httptest.Server
+	upgrader websocket.Upgrader
+	conns    sync.WaitGroup
+}
+
+// StartReceiver starts a Receiver on loopback ports and stops it when the
+// benchmark finishes.
+func StartReceiver(tb testing.TB) *Receiver {
+	tb.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	r := &Receiver{
+		Addr:     l.Addr().String(),
+		listener: l,
+	}
+
+	r.httpSvr = httptest.NewServer(http.HandlerFunc(r.serveWS))
+	r.URL = "ws" + strings.TrimPrefix(r.httpSvr.URL, "http")
+
+	go r.serve()
+
+	tb.Cleanup(r.close)
+
+	return r
+}
+
+// Address returns the value of AddressEnv if it is set. Otherwise it
+// starts a Receiver and returns its TCP address.
+func Address(tb testing.TB) string {
+	tb.Helper()
+
+	if addr := os.Getenv(AddressEnv); len(addr) > 0 {
+		return addr
+	}
+
+	return StartReceiver(tb).Addr
+}
+
+func (r *Receiver) close() {
+	_ = r.listener.Close()
+	r.httpSvr.CloseClientConnections()
+	r.httpSvr.Close()
+	r.conns.Wait()
+}
+
+func (r *Receiver) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		r.conns.Add(1)
+
+		go func() {
+			defer r.conns.Done()
+			defer conn.Close()
+
+			_ = serveConn(conn)
+		}()
+	}
+}
+
+func serveConn(conn net.Conn) error {
+	var (
+		mr = msgp.NewReader(conn)
+		mw = msgp.NewWriter(conn)
+	)
+
+	for {
+		chunk, err := readChunk(mr)
+		if err != nil {
+			return err
+		}
+
+		if len(chunk) == 0 {
+			continue
+		}
+
+		if err = mw.WriteMapHeader(1); err != nil {
+			return err
+		}
+
+		if err = mw.WriteString("ack"); err != nil {
+			return err
+		}
+
+		if err = mw.WriteString(chunk); err != nil {
+			return err
+		}
+
+		if err = mw.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// serveWS reads and discards websocket frames. WSClient does not wait
+// for acks, so none are sent.
+func (r *Receiver) serveWS(w http.ResponseWriter, req *http.Request) {
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+var errBadMessage = errors.New("bm: unexpected message layout")
+
+// readChunk consumes one message of any mode and returns its chunk
+// option, if any.
+func readChunk(r *msgp.Reader) (string, error) {
+	sz, err := r.ReadArrayHeader()
+	if err != nil {
+		return "", err
+	}
+
+	if sz < 2 || sz > 4 {
+		return "", errBadMessage
+	}
+
+	// tag
+	if err = r.Skip(); err != nil {
+		return "", err
+	}
+
+	// A Message is [tag, time, record] or [tag, time, record, option];
+	// the other modes are [tag, entries] or [tag, entries, option].
+	hasOptions := sz == 4
+
+	if sz == 3 {
+		t, err := r.NextType()
+		if err != nil {
+			return "", err
+		}
+
+		hasOptions = t == msgp.ArrayType || t == msgp.BinType || t == msgp.StrType
+	}
+
+	for i := uint32(2); i < sz; i++ {
+		if err = r.Skip(); err != nil {
+			return "", err
+		}
+	}
+
+	if !hasOptions {
+		return "", r.Skip()
+	}
+
+	return readChunkOption(r)
+}
+
+func readChunkOption(r *msgp.Reader) (string, error) {
+	// A Message always has four elements, with nil for missing options.
+	t, err := r.NextType()
+	if err != nil {
+		return "", err
+	}
+
+	if t == msgp.NilType {
+		return "", r.ReadNil()
+	}
+
+	sz, err := r.ReadMapHeader()
+	if err != nil {
+		return "", err
+	}
+
+	var chunk string
+
+	for i := uint32(0); i < sz; i++ {
+		key, err := r.ReadMapKeyPtr()
+		if err != nil {
+			return "", err
+		}
+
+		if string(key) != "chunk" {
+			if err = r.Skip(); err != nil {
+				return "", err
+			}
+
+			continue
+		}
+
+		if chunk, err = r.ReadString(); err != nil {
+			return "", err
+		}
+	}
+
+	return chunk, nil
+}
@@ -0,0 +1,58 @@
+package bm_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/cmd/bm"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestReceiverAcceptsMessageWithoutOptions(t *testing.T) {
+	r := bm.StartReceiver(t)
+
+	conn, err := net.Dial("tcp", r.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	w := msgp.NewWriter(conn)
+
+	// Options is nil, so the message is encoded as [tag, time, record, nil].
+	msg := protocol.NewMessage("foo", bm.MakeRecord(2))
+	if err = msg.EncodeMsg(w); err != nil {
+		t.Fatal(err)
+	}
+
+	acked := protocol.NewMessage("foo", bm.MakeRecord(2))
+
+	chunk, err := acked.Chunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = acked.EncodeMsg(w); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ack protocol.AckMessage
+	if err = ack.DecodeMsg(msgp.NewReader(conn)); err != nil {
+		t.Fatalf("reading ack: %v", err)
+	}
+
+	if ack.Ack != chunk {
+		t.Fatalf("got ack %q, want %q", ack.Ack, chunk)
+	}
+}
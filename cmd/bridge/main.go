@@ -0,0 +1,110 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/bridge"
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+)
+
+var (
+	listenAddr   string
+	certFile     string
+	keyFile      string
+	upstreamAddr string
+	upstreamNet  string
+	upstreamTLS  bool
+	insecure     bool
+	sharedKey    string
+	timeout      time.Duration
+	verbose      bool
+)
+
+func init() {
+	flag.StringVar(&listenAddr, "listen", ":8083", "-listen <address> to accept websocket connections on")
+	flag.StringVar(&certFile, "cert", "", "-cert <file> to serve wss:// (requires -key)")
+	flag.StringVar(&keyFile, "key", "", "-key <file> to serve wss:// (requires -cert)")
+	flag.StringVar(&upstreamAddr, "upstream", "localhost:24224", "-upstream <address> of the forward server")
+	flag.StringVar(&upstreamNet, "network", "tcp", "-network <tcp|unix> of the forward server")
+	flag.BoolVar(&upstreamTLS, "tls", false, "specify to connect to the forward server with tls")
+	flag.BoolVar(&insecure, "insecure", false, "specify to skip upstream certificate verification")
+	flag.StringVar(&sharedKey, "shared-key", "", "-shared-key <key> for the upstream handshake")
+	flag.DurationVar(&timeout, "timeout", client.DefaultConnectionTimeout, "-timeout <duration> to wait for upstream acks")
+	flag.BoolVar(&verbose, "v", false, "specify to log connection details")
+}
+
+func main() {
+	flag.Parse()
+
+	factory := &client.ConnFactory{
+		Network: upstreamNet,
+		Address: upstreamAddr,
+	}
+
+	if upstreamTLS {
+		factory.TLSConfig = &tls.Config{InsecureSkipVerify: insecure} //#nosec
+	}
+
+	opts := bridge.Options{
+		Upstream: client.ConnectionOptions{
+			Factory:           factory,
+			ConnectionTimeout: timeout,
+		},
+	}
+
+	if len(sharedKey) > 0 {
+		opts.Upstream.AuthInfo = client.AuthInfo{SharedKey: []byte(sharedKey)}
+	}
+
+	if verbose {
+		opts.Logger = log.New(os.Stderr, "bridge> ", log.LstdFlags|log.Lmicroseconds)
+	}
+
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           bridge.New(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("relaying websocket connections on %s to %s://%s", listenAddr, upstreamNet, upstreamAddr)
+
+	var err error
+	if len(certFile) > 0 {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
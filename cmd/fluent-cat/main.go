@@ -0,0 +1,358 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+// exit codes
+const (
+	exitOK = iota
+	exitSendError
+	exitUsage
+	exitConnectError
+	exitInputError
+)
+
+const (
+	modeMessage    = "message"
+	modeForward    = "forward"
+	modePacked     = "packed"
+	modeCompressed = "compressed"
+
+	transportTCP  = "tcp"
+	transportTLS  = "tls"
+	transportUnix = "unix"
+	transportWS   = "ws"
+)
+
+var (
+	tagVar       string
+	addressVar   string
+	transportVar string
+	modeVar      string
+	formatVar    string
+	batchVar     int
+	sharedKeyVar string
+	usernameVar  string
+	passwordVar  string
+	hostnameVar  string
+	ackVar       bool
+	timeoutVar   time.Duration
+	insecureVar  bool
+)
+
+func init() {
+	flag.StringVar(&tagVar, "tag", "test.message", "-tag <dot-delimited tag>")
+	flag.StringVar(&tagVar, "t", "test.message", "-t <dot-delimited tag> (shorthand for -tag)")
+	flag.StringVar(&addressVar, "address", "", "-address <host:port|socket path|ws url> (defaults depend on -transport)")
+	flag.StringVar(&transportVar, "transport", transportTCP, "-transport <tcp|tls|unix|ws>")
+	flag.StringVar(&modeVar, "mode", modeMessage, "-mode <message|forward|packed|compressed>")
+	flag.StringVar(&formatVar, "format", formatJSON, "-format <json|msgpack> of the input")
+	flag.IntVar(&batchVar, "batch", 100, "-batch <n> events per message in forward, packed and compressed modes")
+	flag.StringVar(&sharedKeyVar, "shared-key", "", "-shared-key <key> for the handshake")
+	flag.StringVar(&usernameVar, "username", "", "-username <name> for the handshake")
+	flag.StringVar(&passwordVar, "password", "", "-password <password> for the handshake")
+	flag.StringVar(&hostnameVar, "hostname", "", "-hostname <name> sent during the handshake (defaults to os.Hostname)")
+	flag.BoolVar(&ackVar, "ack", false, "specify to require an ack for every message")
+	flag.DurationVar(&timeoutVar, "timeout", client.DefaultConnectionTimeout, "-timeout <duration> to wait for acks")
+	flag.BoolVar(&insecureVar, "insecure", false, "specify to skip certificate verification")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] [file ...]\n\nReads JSON lines or msgpack records from the files, "+
+				"or stdin if none are given, and sends them to a Fluent forward endpoint.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// sender is the subset of methods shared by client.Client and client.WSClient.
+type sender interface {
+	Connect() error
+	Disconnect() error
+	Send(e protocol.ChunkEncoder) error
+}
+
+func usageError(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	flag.Usage()
+	os.Exit(exitUsage)
+}
+
+func validateFlags() {
+	switch modeVar {
+	case modeMessage, modeForward, modePacked, modeCompressed:
+	default:
+		usageError("unknown mode %q", modeVar)
+	}
+
+	switch formatVar {
+	case formatJSON, formatMsgpack:
+	default:
+		usageError("unknown format %q", formatVar)
+	}
+
+	switch transportVar {
+	case transportTCP, transportTLS, transportUnix:
+	case transportWS:
+		if ackVar || len(sharedKeyVar) > 0 || len(usernameVar) > 0 {
+			usageError("-ack, -shared-key and -username are not supported by the ws transport")
+		}
+	default:
+		usageError("unknown transport %q", transportVar)
+	}
+
+	if batchVar < 1 {
+		usageError("-batch must be greater than zero")
+	}
+
+	if len(usernameVar) > 0 && len(sharedKeyVar) == 0 {
+		usageError("-username requires -shared-key")
+	}
+}
+
+func newSender() sender {
+	if transportVar == transportWS {
+		url := addressVar
+		if len(url) == 0 {
+			url = "ws://127.0.0.1:8083"
+		}
+
+		factory := &client.DefaultWSConnectionFactory{URL: url}
+		if insecureVar {
+			factory.TLSConfig = &tls.Config{InsecureSkipVerify: true} //#nosec
+		}
+
+		return client.NewWS(client.WSConnectionOptions{Factory: factory})
+	}
+
+	factory := &client.ConnFactory{
+		Network: "tcp",
+		Address: addressVar,
+		Timeout: timeoutVar,
+	}
+
+	switch transportVar {
+	case transportUnix:
+		factory.Network = "unix"
+	case transportTLS:
+		factory.TLSConfig = &tls.Config{InsecureSkipVerify: insecureVar} //#nosec
+	}
+
+	if len(factory.Address) == 0 {
+		factory.Address = "localhost:24224"
+	}
+
+	c := client.New(client.ConnectionOptions{
+		Factory:           factory,
+		RequireAck:        ackVar,
+		ConnectionTimeout: timeoutVar,
+		AuthInfo: client.AuthInfo{
+			Username: usernameVar,
+			Password: passwordVar,
+		},
+	})
+
+	if len(sharedKeyVar) > 0 {
+		c.AuthInfo.SharedKey = []byte(sharedKeyVar)
+	}
+
+	c.Hostname = hostnameVar
+	if len(c.Hostname) == 0 {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	return c
+}
+
+func connect(s sender) error {
+	if err := s.Connect(); err != nil {
+		return err
+	}
+
+	if c, ok := s.(*client.Client); ok && !c.TransportPhase() {
+		return c.Handshake()
+	}
+
+	return nil
+}
+
+func newChunkEncoder(entries protocol.EntryList) (protocol.ChunkEncoder, error) {
+	switch modeVar {
+	case modeForward:
+		return protocol.NewForwardMessage(tagVar, entries), nil
+	case modePacked:
+		return protocol.NewPackedForwardMessage(tagVar, entries)
+	case modeCompressed:
+		return protocol.NewCompressedPackedForwardMessage(tagVar, entries)
+	}
+
+	msg := protocol.NewMessageExt(tagVar, entries[0].Record)
+	msg.Timestamp = entries[0].Timestamp
+
+	return msg, nil
+}
+
+// catter sends the records read from one or more inputs in batches.
+type catter struct {
+	s       sender
+	batch   protocol.EntryList
+	sent    int
+	records int
+}
+
+func (ct *catter) flush() error {
+	if len(ct.batch) == 0 {
+		return nil
+	}
+
+	msg, err := newChunkEncoder(ct.batch)
+	if err == nil {
+		err = ct.s.Send(msg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	ct.sent += len(ct.batch)
+	// the encoders may retain the slice, so start a new one
+	ct.batch = make(protocol.EntryList, 0, batchVar)
+
+	return nil
+}
+
+func (ct *catter) add(record interface{}) error {
+	ct.records++
+	ct.batch = append(ct.batch, protocol.EntryExt{
+		Timestamp: protocol.EventTimeNow(),
+		Record:    record,
+	})
+
+	if modeVar == modeMessage || len(ct.batch) >= batchVar {
+		return ct.flush()
+	}
+
+	return nil
+}
+
+var errSend = errors.New("send failed")
+
+func (ct *catter) cat(name string, r io.Reader) error {
+	rr := newRecordReader(formatVar, bufio.NewReader(r))
+
+	for {
+		record, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %w", name, rr.Count(), err)
+		}
+
+		if err = ct.add(record); err != nil {
+			return fmt.Errorf("%w: %s: record %d: %v", errSend, name, rr.Count(), err)
+		}
+	}
+}
+
+func (ct *catter) catFiles(names []string) error {
+	if len(names) == 0 {
+		return ct.cat("stdin", os.Stdin)
+	}
+
+	for _, name := range names {
+		f, err := os.Open(name) //#nosec
+		if err != nil {
+			return err
+		}
+
+		err = ct.cat(name, f)
+		_ = f.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	validateFlags()
+
+	s := newSender()
+
+	if err := connect(s); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to connect, exiting:", err)
+		os.Exit(exitConnectError)
+	}
+
+	ct := &catter{
+		s:     s,
+		batch: make(protocol.EntryList, 0, batchVar),
+	}
+
+	code := exitOK
+
+	err := ct.catFiles(flag.Args())
+	if err == nil {
+		if err = ct.flush(); err != nil {
+			err = fmt.Errorf("%w: %v", errSend, err)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		code = exitInputError
+		if errors.Is(err, errSend) {
+			code = exitSendError
+		}
+	}
+
+	if derr := s.Disconnect(); derr != nil && code == exitOK {
+		fmt.Fprintln(os.Stderr, "disconnect:", derr)
+
+		code = exitSendError
+	}
+
+	fmt.Fprintf(os.Stderr, "%d of %d records sent\n", ct.sent, ct.records)
+
+	os.Exit(code)
+}
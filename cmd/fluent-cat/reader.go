@@ -0,0 +1,155 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	formatJSON    = "json"
+	formatMsgpack = "msgpack"
+)
+
+type recordReader interface {
+	// Next returns the next record, or io.EOF when the input is exhausted.
+	Next() (map[string]interface{}, error)
+	// Count returns the number of records read so far, including the
+	// one that failed.
+	Count() int
+}
+
+func newRecordReader(format string, r *bufio.Reader) recordReader {
+	if format == formatMsgpack {
+		return &msgpackReader{r: msgp.NewReader(r)}
+	}
+
+	return &jsonLineReader{r: r}
+}
+
+type jsonLineReader struct {
+	r     *bufio.Reader
+	count int
+}
+
+func (jr *jsonLineReader) Count() int {
+	return jr.count
+}
+
+func (jr *jsonLineReader) Next() (map[string]interface{}, error) {
+	for {
+		line, err := jr.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		jr.count++
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+
+		var record map[string]interface{}
+		if err = dec.Decode(&record); err != nil {
+			return nil, err
+		}
+
+		if record == nil {
+			return nil, errors.New("record must be a JSON object")
+		}
+
+		normalizeMap(record)
+
+		return record, nil
+	}
+}
+
+// normalizeMap converts json.Number values, which msgp cannot encode,
+// to int64 or float64.
+func normalizeMap(m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = normalize(v)
+	}
+}
+
+func normalize(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case json.Number:
+		if i, err := vv.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := vv.Float64()
+
+		return f
+	case map[string]interface{}:
+		normalizeMap(vv)
+	case []interface{}:
+		for i := range vv {
+			vv[i] = normalize(vv[i])
+		}
+	}
+
+	return v
+}
+
+type msgpackReader struct {
+	r     *msgp.Reader
+	count int
+}
+
+func (mr *msgpackReader) Count() int {
+	return mr.count
+}
+
+func (mr *msgpackReader) Next() (map[string]interface{}, error) {
+	if _, err := mr.r.NextType(); err != nil {
+		return nil, err
+	}
+
+	mr.count++
+
+	v, err := mr.r.ReadIntf()
+	if err != nil {
+		return nil, err
+	}
+
+	record, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("record must be a msgpack map")
+	}
+
+	return record, nil
+}
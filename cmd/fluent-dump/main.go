@@ -0,0 +1,340 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/capture"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+)
+
+const (
+	formatPretty = "pretty"
+	formatJSONL  = "jsonl"
+
+	transportTCP  = "tcp"
+	transportTLS  = "tls"
+	transportUnix = "unix"
+	transportWS   = "ws"
+)
+
+var (
+	listenVar    string
+	transportVar string
+	formatVar    string
+	certVar      string
+	keyVar       string
+	sharedKeyVar string
+	usernameVar  string
+	passwordVar  string
+	hostnameVar  string
+	captureVar   string
+	ackVar       bool
+	verboseVar   bool
+)
+
+func init() {
+	flag.StringVar(&listenVar, "listen", "", "-listen <host:port|socket path> (defaults depend on -transport)")
+	flag.StringVar(&transportVar, "transport", transportTCP, "-transport <tcp|tls|unix|ws>")
+	flag.StringVar(&formatVar, "format", formatPretty, "-format <pretty|jsonl>")
+	flag.StringVar(&certVar, "cert", "", "-cert <file> for the tls transport, or wss:// with the ws transport")
+	flag.StringVar(&keyVar, "key", "", "-key <file> for the tls transport, or wss:// with the ws transport")
+	flag.StringVar(&sharedKeyVar, "shared-key", "", "-shared-key <key> to require the handshake")
+	flag.StringVar(&usernameVar, "username", "", "-username <name> to require user authentication")
+	flag.StringVar(&passwordVar, "password", "", "-password <password> to require user authentication")
+	flag.StringVar(&hostnameVar, "hostname", "", "-hostname <name> sent during the handshake (defaults to os.Hostname)")
+	flag.StringVar(&captureVar, "capture", "", "-capture <file> to also record every message for fluent-replay")
+	flag.BoolVar(&ackVar, "ack", true, "specify false to not ack chunks")
+	flag.BoolVar(&verboseVar, "v", false, "specify to log connection details")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags]\n\nListens for Fluent forward messages and prints every event to stdout.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// event is the JSON representation of a single received event.
+type event struct {
+	Tag        string                 `json:"tag"`
+	Time       time.Time              `json:"time"`
+	Mode       string                 `json:"mode"`
+	Options    *options               `json:"options,omitempty"`
+	Remote     string                 `json:"remote,omitempty"`
+	ReceivedAt time.Time              `json:"received_at"`
+	Record     map[string]interface{} `json:"record"`
+}
+
+type options struct {
+	Chunk      string `json:"chunk,omitempty"`
+	Size       *int   `json:"size,omitempty"`
+	Compressed string `json:"compressed,omitempty"`
+}
+
+type printer struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func newPrinter(format string) *printer {
+	enc := json.NewEncoder(os.Stdout)
+	if format == formatPretty {
+		enc.SetIndent("", "  ")
+	}
+
+	return &printer{enc: enc}
+}
+
+func (p *printer) print(msg *server.Message) error {
+	e := event{
+		Tag:        msg.Tag,
+		Mode:       msg.Mode.String(),
+		ReceivedAt: msg.ReceivedAt,
+	}
+
+	if msg.RemoteAddr != nil {
+		e.Remote = msg.RemoteAddr.String()
+	}
+
+	if msg.Options != nil {
+		e.Options = &options{
+			Chunk:      msg.Options.Chunk,
+			Size:       msg.Options.Size,
+			Compressed: msg.Options.Compressed,
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, entry := range msg.Entries {
+		e.Time = entry.Timestamp.Time
+		e.Record = toJSON(entry.Record)
+
+		if err := p.enc.Encode(&e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toJSON converts msgpack-decoded records into values that encoding/json
+// can marshal. Strings that msgp decoded as []byte are printed as strings.
+func toJSON(record interface{}) map[string]interface{} {
+	m, ok := convert(record).(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{"record": convert(record)}
+	}
+
+	return m
+}
+
+func convert(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case []byte:
+		return string(vv)
+	case map[string]interface{}:
+		for k, val := range vv {
+			vv[k] = convert(val)
+		}
+	case []interface{}:
+		for i := range vv {
+			vv[i] = convert(vv[i])
+		}
+	case *protocol.EventTime:
+		return vv.Time
+	}
+
+	return v
+}
+
+func usageError(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	flag.Usage()
+	os.Exit(2)
+}
+
+func validateFlags() {
+	switch formatVar {
+	case formatPretty, formatJSONL:
+	default:
+		usageError("unknown format %q", formatVar)
+	}
+
+	switch transportVar {
+	case transportTCP, transportUnix:
+	case transportTLS:
+		if len(certVar) == 0 || len(keyVar) == 0 {
+			usageError("the tls transport requires -cert and -key")
+		}
+	case transportWS:
+		if len(sharedKeyVar) > 0 {
+			usageError("-shared-key is not supported by the ws transport")
+		}
+	default:
+		usageError("unknown transport %q", transportVar)
+	}
+
+	if len(usernameVar) > 0 && len(sharedKeyVar) == 0 {
+		usageError("-username requires -shared-key")
+	}
+
+	if len(listenVar) == 0 {
+		switch transportVar {
+		case transportUnix:
+			usageError("the unix transport requires -listen <socket path>")
+		case transportWS:
+			listenVar = ":8083"
+		default:
+			listenVar = ":24224"
+		}
+	}
+}
+
+func newListener() (net.Listener, error) {
+	switch transportVar {
+	case transportUnix:
+		return net.Listen("unix", listenVar)
+	case transportTLS:
+		cert, err := tls.LoadX509KeyPair(certVar, keyVar)
+		if err != nil {
+			return nil, err
+		}
+
+		return tls.Listen("tcp", listenVar, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+
+	return net.Listen("tcp", listenVar)
+}
+
+func main() {
+	flag.Parse()
+	validateFlags()
+
+	p := newPrinter(formatVar)
+
+	opts := server.Options{
+		Handler: func(msg *server.Message) error {
+			if err := p.print(msg); err != nil {
+				log.Println("print error:", err)
+			}
+
+			return nil
+		},
+		Hostname:   hostnameVar,
+		DisableAck: !ackVar,
+	}
+
+	if len(opts.Hostname) == 0 {
+		opts.Hostname, _ = os.Hostname()
+	}
+
+	if len(captureVar) > 0 {
+		f, err := os.Create(captureVar)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer f.Close()
+
+		w, err := capture.NewWriter(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts.Handler = capture.Handler(w, opts.Handler)
+	}
+
+	if len(sharedKeyVar) > 0 {
+		opts.SharedKey = []byte(sharedKeyVar)
+	}
+
+	if len(usernameVar) > 0 {
+		opts.Users = map[string]string{usernameVar: passwordVar}
+	}
+
+	if verboseVar {
+		opts.Logger = log.New(os.Stderr, "dump> ", log.LstdFlags|log.Lmicroseconds)
+	}
+
+	svr := server.New(opts)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	errs := make(chan error, 1)
+
+	if transportVar == transportWS {
+		httpSvr := &http.Server{
+			Addr:              listenVar,
+			Handler:           svr,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			if len(certVar) > 0 {
+				errs <- httpSvr.ListenAndServeTLS(certVar, keyVar)
+			} else {
+				errs <- httpSvr.ListenAndServe()
+			}
+		}()
+
+		defer httpSvr.Close()
+	} else {
+		l, err := newListener()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() { errs <- svr.Serve(l) }()
+	}
+
+	log.Printf("listening for %s connections on %s", transportVar, listenVar)
+
+	select {
+	case err := <-errs:
+		_ = svr.Close()
+		log.Fatal(err)
+	case <-interrupt:
+	}
+
+	_ = svr.Close()
+}
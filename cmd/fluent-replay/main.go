@@ -0,0 +1,207 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/capture"
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+)
+
+const (
+	transportTCP  = "tcp"
+	transportTLS  = "tls"
+	transportUnix = "unix"
+)
+
+var (
+	addressVar   string
+	transportVar string
+	speedVar     float64
+	sharedKeyVar string
+	usernameVar  string
+	passwordVar  string
+	hostnameVar  string
+	ackVar       bool
+	timeoutVar   time.Duration
+	insecureVar  bool
+)
+
+func init() {
+	flag.StringVar(&addressVar, "address", "localhost:24224", "-address <host:port|socket path>")
+	flag.StringVar(&transportVar, "transport", transportTCP, "-transport <tcp|tls|unix>")
+	flag.Float64Var(&speedVar, "speed", 1, "-speed <factor> relative to the original pace; 0 sends as fast as possible")
+	flag.StringVar(&sharedKeyVar, "shared-key", "", "-shared-key <key> for the handshake")
+	flag.StringVar(&usernameVar, "username", "", "-username <name> for the handshake")
+	flag.StringVar(&passwordVar, "password", "", "-password <password> for the handshake")
+	flag.StringVar(&hostnameVar, "hostname", "", "-hostname <name> sent during the handshake (defaults to os.Hostname)")
+	flag.BoolVar(&ackVar, "ack", false, "specify to wait for acks of messages captured with a chunk")
+	flag.DurationVar(&timeoutVar, "timeout", client.DefaultConnectionTimeout, "-timeout <duration> to wait for acks")
+	flag.BoolVar(&insecureVar, "insecure", false, "specify to skip certificate verification")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] [capture file ...]\n\nResends the messages in the capture files, "+
+				"or stdin if none are given, to a Fluent forward endpoint.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func usageError(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	flag.Usage()
+	os.Exit(2)
+}
+
+func validateFlags() {
+	switch transportVar {
+	case transportTCP, transportTLS, transportUnix:
+	default:
+		usageError("unknown transport %q", transportVar)
+	}
+
+	if speedVar < 0 {
+		usageError("-speed must not be negative")
+	}
+
+	if len(usernameVar) > 0 && len(sharedKeyVar) == 0 {
+		usageError("-username requires -shared-key")
+	}
+}
+
+func newClient() *client.Client {
+	factory := &client.ConnFactory{
+		Network: "tcp",
+		Address: addressVar,
+		Timeout: timeoutVar,
+	}
+
+	switch transportVar {
+	case transportUnix:
+		factory.Network = "unix"
+	case transportTLS:
+		factory.TLSConfig = &tls.Config{InsecureSkipVerify: insecureVar} //#nosec
+	}
+
+	c := client.New(client.ConnectionOptions{
+		Factory:           factory,
+		RequireAck:        ackVar,
+		ConnectionTimeout: timeoutVar,
+		AuthInfo: client.AuthInfo{
+			Username: usernameVar,
+			Password: passwordVar,
+		},
+	})
+
+	if len(sharedKeyVar) > 0 {
+		c.AuthInfo.SharedKey = []byte(sharedKeyVar)
+	}
+
+	c.Hostname = hostnameVar
+	if len(c.Hostname) == 0 {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	return c
+}
+
+func replay(c *client.Client, name string, r io.Reader) (int, error) {
+	cr, err := capture.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+
+	n, err := capture.Replay(cr, c, capture.ReplayOptions{Speed: speedVar})
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+	}
+
+	return n, err
+}
+
+func replayFiles(c *client.Client, names []string) (int, error) {
+	if len(names) == 0 {
+		return replay(c, "stdin", os.Stdin)
+	}
+
+	var sent int
+
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return sent, err
+		}
+
+		n, err := replay(c, name, f)
+		sent += n
+
+		f.Close()
+
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+func main() {
+	flag.Parse()
+	validateFlags()
+
+	c := newClient()
+
+	err := c.Connect()
+	if err == nil && !c.TransportPhase() {
+		err = c.Handshake()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to connect, exiting:", err)
+		os.Exit(3)
+	}
+
+	code := 0
+
+	sent, err := replayFiles(c, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		code = 1
+	}
+
+	_ = c.Disconnect()
+
+	fmt.Fprintf(os.Stderr, "%d messages sent\n", sent)
+
+	os.Exit(code)
+}
@@ -0,0 +1,307 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+// exit codes
+const (
+	exitOK = iota
+	exitSendError
+	exitUsage
+	exitConnectError
+	exitInputError
+)
+
+const (
+	transportTCP  = "tcp"
+	transportTLS  = "tls"
+	transportUnix = "unix"
+)
+
+var (
+	addressVar   string
+	transportVar string
+	tagVar       string
+	batchVar     int
+	sharedKeyVar string
+	usernameVar  string
+	passwordVar  string
+	hostnameVar  string
+	ackVar       bool
+	timeoutVar   time.Duration
+	insecureVar  bool
+	dryRunVar    bool
+)
+
+func init() {
+	flag.StringVar(&addressVar, "address", "localhost:24224", "-address <host:port|socket path>")
+	flag.StringVar(&transportVar, "transport", transportTCP, "-transport <tcp|tls|unix>")
+	flag.StringVar(&tagVar, "tag", "", "-tag <tag> for chunks whose metadata has none")
+	flag.IntVar(&batchVar, "batch", 1000, "-batch <n> events per PackedForward message")
+	flag.StringVar(&sharedKeyVar, "shared-key", "", "-shared-key <key> for the handshake")
+	flag.StringVar(&usernameVar, "username", "", "-username <name> for the handshake")
+	flag.StringVar(&passwordVar, "password", "", "-password <password> for the handshake")
+	flag.StringVar(&hostnameVar, "hostname", "", "-hostname <name> sent during the handshake (defaults to os.Hostname)")
+	flag.BoolVar(&ackVar, "ack", true, "specify false to not wait for acks")
+	flag.DurationVar(&timeoutVar, "timeout", client.DefaultConnectionTimeout, "-timeout <duration> to wait for acks")
+	flag.BoolVar(&insecureVar, "insecure", false, "specify to skip certificate verification")
+	flag.BoolVar(&dryRunVar, "dry-run", false, "specify to list the recovered chunks without sending them")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] <buffer dir|chunk file> ...\n\nReads the .log chunks and .log.meta files of "+
+				"Fluentd file buffers and resends their events as PackedForward messages.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func usageError(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	flag.Usage()
+	os.Exit(exitUsage)
+}
+
+func validateFlags() {
+	switch transportVar {
+	case transportTCP, transportTLS, transportUnix:
+	default:
+		usageError("unknown transport %q", transportVar)
+	}
+
+	if batchVar < 1 {
+		usageError("-batch must be greater than zero")
+	}
+
+	if len(usernameVar) > 0 && len(sharedKeyVar) == 0 {
+		usageError("-username requires -shared-key")
+	}
+
+	if flag.NArg() == 0 {
+		usageError("no buffer directory or chunk file given")
+	}
+}
+
+func newClient() *client.Client {
+	factory := &client.ConnFactory{
+		Network: "tcp",
+		Address: addressVar,
+		Timeout: timeoutVar,
+	}
+
+	switch transportVar {
+	case transportUnix:
+		factory.Network = "unix"
+	case transportTLS:
+		factory.TLSConfig = &tls.Config{InsecureSkipVerify: insecureVar} //#nosec
+	}
+
+	c := client.New(client.ConnectionOptions{
+		Factory:           factory,
+		RequireAck:        ackVar,
+		ConnectionTimeout: timeoutVar,
+		AuthInfo: client.AuthInfo{
+			Username: usernameVar,
+			Password: passwordVar,
+		},
+	})
+
+	if len(sharedKeyVar) > 0 {
+		c.AuthInfo.SharedKey = []byte(sharedKeyVar)
+	}
+
+	c.Hostname = hostnameVar
+	if len(c.Hostname) == 0 {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	return c
+}
+
+// chunkPaths expands directories into the chunk files they contain. Chunks
+// are returned in name order within each directory.
+func chunkPaths(args []string) ([]string, error) {
+	var paths []string
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(arg, "*.log"))
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+
+	return paths, nil
+}
+
+// readChunk reads a chunk and its metadata file, if there is one. A
+// truncated chunk is returned with its complete events and a warning.
+func readChunk(path string) (*protocol.BufferChunk, error) {
+	chunk, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer chunk.Close()
+
+	var meta io.Reader
+
+	f, err := os.Open(path + ".meta")
+	switch {
+	case err == nil:
+		defer f.Close()
+
+		meta = f
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	bc, err := protocol.ReadBufferChunk(chunk, meta)
+	if err != nil && bc != nil && len(bc.Entries) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: warning: %v\n", path, err)
+		err = nil
+	}
+
+	if err == nil && len(bc.Tag) == 0 {
+		bc.Tag = tagVar
+	}
+
+	return bc, err
+}
+
+func send(c *client.Client, bc *protocol.BufferChunk) error {
+	for i := 0; i < len(bc.Entries); i += batchVar {
+		j := i + batchVar
+		if j > len(bc.Entries) {
+			j = len(bc.Entries)
+		}
+
+		if err := c.SendPacked(bc.Tag, bc.Entries[i:j]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	validateFlags()
+
+	paths, err := chunkPaths(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitInputError)
+	}
+
+	var c *client.Client
+
+	if !dryRunVar {
+		c = newClient()
+
+		err = c.Connect()
+		if err == nil && !c.TransportPhase() {
+			err = c.Handshake()
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to connect, exiting:", err)
+			os.Exit(exitConnectError)
+		}
+	}
+
+	code := exitOK
+
+	var events int
+
+	for _, path := range paths {
+		bc, err := readChunk(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+
+			code = exitInputError
+
+			continue
+		}
+
+		if len(bc.Tag) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: no tag in the metadata; specify -tag\n", path)
+
+			code = exitInputError
+
+			continue
+		}
+
+		if dryRunVar {
+			fmt.Printf("%s\t%s\t%d events\tcreated %s\n",
+				path, bc.Tag, len(bc.Entries), bc.Metadata.CreatedAt.Format(time.RFC3339))
+
+			continue
+		}
+
+		if err = send(c, bc); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			fmt.Fprintf(os.Stderr, "%d events sent before the error\n", events)
+
+			// later chunks would fail the same way
+			_ = c.Disconnect()
+			os.Exit(exitSendError)
+		}
+
+		events += len(bc.Entries)
+		fmt.Fprintf(os.Stderr, "%s: %d events sent as %s\n", path, len(bc.Entries), bc.Tag)
+	}
+
+	if c != nil {
+		_ = c.Disconnect()
+	}
+
+	os.Exit(code)
+}
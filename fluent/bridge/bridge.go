@@ -0,0 +1,218 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package bridge
+
+import (
+	"net/http"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/gorilla/websocket"
+)
+
+type noopLogger struct{}
+
+func (l *noopLogger) Println(_ ...interface{}) {}
+
+func (l *noopLogger) Printf(_ string, _ ...interface{}) {}
+
+// UpstreamFactory creates the client used to relay the messages received on
+// a single websocket connection.
+type UpstreamFactory func() *client.Client
+
+type Options struct {
+	// Upstream configures the forward client created for each websocket
+	// connection. RequireAck is always enabled so that acks can be relayed
+	// back to the websocket peer.
+	Upstream client.ConnectionOptions
+	// UpstreamFactory overrides Upstream when more control over the client
+	// is needed, e.g., to set the Hostname used during the handshake.
+	UpstreamFactory UpstreamFactory
+	// ConnectionOptions configures the server side of each websocket
+	// connection. The ReadHandler is replaced by the bridge.
+	ConnectionOptions ws.ConnectionOptions
+	// Upgrader upgrades incoming HTTP requests. The zero value is used
+	// if nil.
+	Upgrader *websocket.Upgrader
+	// Logger is an optional debug log writer.
+	Logger ws.Logger
+}
+
+// Bridge is an http.Handler that accepts websocket connections, typically
+// from a client.WSClient, and relays each binary frame to an upstream
+// forward server. Every websocket connection gets its own upstream
+// connection. Frames that carry a "chunk" option are sent with ack
+// confirmation, and the upstream ack is written back to the websocket
+// peer unchanged. Frames without a chunk are relayed as-is.
+type Bridge struct {
+	opts     Options
+	upgrader *websocket.Upgrader
+	logger   ws.Logger
+}
+
+func New(opts Options) *Bridge {
+	b := &Bridge{
+		opts:     opts,
+		upgrader: opts.Upgrader,
+		logger:   opts.Logger,
+	}
+
+	if b.upgrader == nil {
+		b.upgrader = &websocket.Upgrader{}
+	}
+
+	if b.logger == nil {
+		b.logger = &noopLogger{}
+	}
+
+	return b
+}
+
+func (b *Bridge) newUpstream() *client.Client {
+	if b.opts.UpstreamFactory != nil {
+		return b.opts.UpstreamFactory()
+	}
+
+	opts := b.opts.Upstream
+	opts.RequireAck = true
+
+	return client.New(opts)
+}
+
+func (b *Bridge) connectUpstream() (*client.Client, error) {
+	upstream := b.newUpstream()
+	upstream.RequireAck = true
+
+	if err := upstream.Connect(); err != nil {
+		return nil, err
+	}
+
+	if !upstream.TransportPhase() {
+		if err := upstream.Handshake(); err != nil {
+			_ = upstream.Disconnect()
+			return nil, err
+		}
+	}
+
+	return upstream, nil
+}
+
+// ServeHTTP upgrades the request to a websocket connection, opens the
+// upstream connection and relays messages until either side closes.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream, err := b.connectUpstream()
+	if err != nil {
+		b.logger.Println("upstream connect error:", err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+
+		return
+	}
+
+	defer func() {
+		if err := upstream.Disconnect(); err != nil {
+			b.logger.Println("upstream disconnect error:", err)
+		}
+	}()
+
+	wc, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		b.logger.Println("upgrade error:", err)
+		return
+	}
+
+	opts := b.opts.ConnectionOptions
+	opts.ReadHandler = b.readHandler(upstream)
+
+	if opts.Logger == nil {
+		opts.Logger = b.logger
+	}
+
+	connection, err := ws.NewConnection(wc, opts)
+	if err != nil {
+		b.logger.Println("connection error:", err)
+		_ = wc.Close()
+
+		return
+	}
+
+	if err := connection.Listen(); err != nil &&
+		!websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		b.logger.Println("listen error:", err)
+	}
+}
+
+func (b *Bridge) readHandler(upstream *client.Client) ws.ReadHandler {
+	return func(conn ws.Connection, _ int, p []byte, err error) error {
+		if err != nil {
+			if !conn.Closed() {
+				_ = conn.Close()
+			}
+
+			return err
+		}
+
+		if err = relay(conn, upstream, p); err != nil {
+			b.logger.Println("relay error:", err)
+
+			// The peer never gets an ack for this message, so it is expected
+			// to reconnect and resend. Close asynchronously: CloseWithMsg waits
+			// for the peer's response, which is delivered by the same read
+			// loop that is currently blocked on this handler.
+			if !conn.Closed() {
+				go func() {
+					_ = conn.CloseWithMsg(websocket.CloseInternalServerErr, "upstream error")
+				}()
+			}
+		}
+
+		return err
+	}
+}
+
+// relay sends a single frame upstream. If the frame has a chunk, relay waits
+// for the upstream ack and writes it back to the websocket peer.
+func relay(conn ws.Connection, upstream *client.Client, frame []byte) error {
+	chunk, err := protocol.GetChunk(frame)
+	if err != nil {
+		return upstream.SendRaw(frame)
+	}
+
+	if err = upstream.Send(protocol.RawMessage(frame)); err != nil {
+		return err
+	}
+
+	ack := protocol.AckMessage{Ack: chunk}
+
+	bits, err := ack.MarshalMsg(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(bits)
+
+	return err
+}
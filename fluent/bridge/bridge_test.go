@@ -0,0 +1,179 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package bridge_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/bridge"
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tinylib/msgp/msgp"
+)
+
+var _ = Describe("Bridge", func() {
+	var (
+		listener net.Listener
+		svr      *httptest.Server
+		wsClient *client.WSClient
+		received chan protocol.MessageExt
+		acks     chan protocol.AckMessage
+		sendAcks bool
+	)
+
+	serveUpstream := func(listener net.Listener, received chan<- protocol.MessageExt, sendAcks bool) {
+		defer GinkgoRecover()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		r := msgp.NewReader(conn)
+		w := msgp.NewWriter(conn)
+
+		for {
+			var msg protocol.MessageExt
+			if err := msg.DecodeMsg(r); err != nil {
+				return
+			}
+
+			received <- msg
+
+			if sendAcks && msg.Options != nil && msg.Options.Chunk != "" {
+				ack := protocol.AckMessage{Ack: msg.Options.Chunk}
+				Expect(ack.EncodeMsg(w)).To(Succeed())
+				Expect(w.Flush()).To(Succeed())
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		received = make(chan protocol.MessageExt, 1)
+		acks = make(chan protocol.AckMessage, 1)
+		sendAcks = true
+	})
+
+	JustBeforeEach(func() {
+		go serveUpstream(listener, received, sendAcks)
+
+		b := bridge.New(bridge.Options{
+			Upstream: client.ConnectionOptions{
+				Factory: &client.ConnFactory{
+					Address: listener.Addr().String(),
+				},
+				ConnectionTimeout: 500 * time.Millisecond,
+			},
+		})
+
+		svr = httptest.NewServer(b)
+
+		wsClient = client.NewWS(client.WSConnectionOptions{
+			Factory: &client.DefaultWSConnectionFactory{
+				URL: "ws" + strings.TrimPrefix(svr.URL, "http"),
+			},
+			ConnectionOptions: ws.ConnectionOptions{
+				ReadHandler: func(conn ws.Connection, _ int, p []byte, err error) error {
+					if err != nil {
+						_ = conn.Close()
+						return err
+					}
+
+					var ack protocol.AckMessage
+					_, err = ack.UnmarshalMsg(p)
+					Expect(err).ToNot(HaveOccurred())
+					acks <- ack
+
+					return nil
+				},
+			},
+		})
+
+		Expect(wsClient.Connect()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		_ = wsClient.Disconnect()
+		svr.Close()
+		listener.Close()
+	})
+
+	It("relays messages without a chunk", func() {
+		msg := protocol.NewMessageExt("foo.bar", map[string]interface{}{"a": "b"})
+		Expect(wsClient.Send(msg)).To(Succeed())
+
+		var rcvd protocol.MessageExt
+		Eventually(received).Should(Receive(&rcvd))
+		Expect(rcvd.Tag).To(Equal("foo.bar"))
+		Expect(rcvd.Options).To(BeNil())
+		Consistently(acks, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("preserves the chunk and relays the upstream ack", func() {
+		msg := protocol.NewMessageExt("foo.bar", map[string]interface{}{"a": "b"})
+		chunk, err := msg.Chunk()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wsClient.Send(msg)).To(Succeed())
+
+		var rcvd protocol.MessageExt
+		Eventually(received).Should(Receive(&rcvd))
+		Expect(rcvd.Options.Chunk).To(Equal(chunk))
+
+		var ack protocol.AckMessage
+		Eventually(acks).Should(Receive(&ack))
+		Expect(ack.Ack).To(Equal(chunk))
+	})
+
+	When("the upstream does not ack", func() {
+		BeforeEach(func() {
+			sendAcks = false
+		})
+
+		It("closes the websocket connection", func() {
+			msg := protocol.NewMessageExt("foo.bar", map[string]interface{}{"a": "b"})
+			_, err := msg.Chunk()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(wsClient.Send(msg)).To(Succeed())
+
+			Eventually(received).Should(Receive())
+			Consistently(acks, 100*time.Millisecond).ShouldNot(Receive())
+			Eventually(func() bool {
+				return wsClient.Session().Connection.Closed()
+			}, 2*time.Second).Should(BeTrue())
+		})
+	})
+})
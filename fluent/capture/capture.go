@@ -0,0 +1,181 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package capture records forward messages to a file and replays them.
+//
+// A capture file starts with the 8-byte magic "FFWDCAP1". Each record that
+// follows is laid out as:
+//
+//	int64 (big-endian)  receive time, in nanoseconds since the Unix epoch
+//	uint8               protocol.MessageMode
+//	uint32 (big-endian) length of the message
+//	[]byte              the raw msgpack-encoded message
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	magic      = "FFWDCAP1"
+	headerSize = 8 + 1 + 4
+
+	// MaxRecordSize is the largest message a capture file holds. A
+	// larger length in a record header means the file is corrupt.
+	MaxRecordSize = 256 << 20
+
+	// readStep caps the memory allocated for a message before its bytes
+	// are read, so that a truncated file does not allocate the length in
+	// its last header.
+	readStep = 1 << 20
+)
+
+var (
+	// ErrBadMagic is returned by NewReader when the input is not a capture
+	// file.
+	ErrBadMagic = errors.New("capture: not a capture file")
+	// ErrCorrupt is returned by Reader.Next for a record header whose
+	// length exceeds MaxRecordSize.
+	ErrCorrupt = errors.New("capture: corrupt record")
+)
+
+// Record is a single captured message.
+type Record struct {
+	// Time is when the message was sent or received.
+	Time time.Time
+	Mode protocol.MessageMode
+	// Raw is the message as sent on the wire.
+	Raw []byte
+}
+
+// Writer appends records to a capture file. It is safe for concurrent use.
+type Writer struct {
+	lock sync.Mutex
+	w    io.Writer
+	buf  []byte
+}
+
+// NewWriter writes the capture file header to w and returns a Writer for
+// the records that follow.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// Write appends the record. The record header and message are written
+// with a single call to the underlying writer.
+func (cw *Writer) Write(rec Record) error {
+	if len(rec.Raw) > MaxRecordSize {
+		return fmt.Errorf("capture: message of %d bytes is too large", len(rec.Raw))
+	}
+
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	var header [headerSize]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(rec.Time.UnixNano()))
+	header[8] = byte(rec.Mode)
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(rec.Raw)))
+
+	buf := append(cw.buf[:0], header[:]...)
+	buf = append(buf, rec.Raw...)
+	cw.buf = buf
+
+	_, err := cw.w.Write(buf)
+
+	return err
+}
+
+// Reader reads records from a capture file.
+type Reader struct {
+	r      io.Reader
+	header [headerSize]byte
+}
+
+// NewReader reads and checks the capture file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	var m [len(magic)]byte
+	if _, err := io.ReadFull(r, m[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrBadMagic
+		}
+
+		return nil, err
+	}
+
+	if string(m[:]) != magic {
+		return nil, ErrBadMagic
+	}
+
+	return &Reader{r: r}, nil
+}
+
+// Next returns the next record. It returns io.EOF when there are no more
+// records, io.ErrUnexpectedEOF if the last record is incomplete, and an
+// error wrapping ErrCorrupt if the length of the record is too large.
+func (cr *Reader) Next() (*Record, error) {
+	if _, err := io.ReadFull(cr.r, cr.header[:]); err != nil {
+		return nil, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(cr.header[9:13]))
+	if size > MaxRecordSize {
+		return nil, fmt.Errorf("%w: message of %d bytes", ErrCorrupt, size)
+	}
+
+	// the buffer grows as the message is read, rather than trusting size
+	var raw bytes.Buffer
+
+	if size < readStep {
+		raw.Grow(int(size))
+	} else {
+		raw.Grow(readStep)
+	}
+
+	n, err := raw.ReadFrom(io.LimitReader(cr.r, size))
+	if err != nil {
+		return nil, err
+	}
+
+	if n < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return &Record{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(cr.header[0:8]))),
+		Mode: protocol.MessageMode(cr.header[8]),
+		Raw:  raw.Bytes(),
+	}, nil
+}
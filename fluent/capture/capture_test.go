@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package capture_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/capture"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer and Reader", func() {
+	var (
+		buf     *bytes.Buffer
+		records []capture.Record
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+
+		ts := time.Unix(1700000000, 123456789)
+		records = []capture.Record{
+			{Time: ts, Mode: protocol.ModeMessage, Raw: []byte{0x93, 0xa3, 'f', 'o', 'o'}},
+			{Time: ts.Add(time.Second), Mode: protocol.ModeCompressedPackedForward, Raw: []byte{0x92}},
+		}
+
+		w, err := capture.NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, rec := range records {
+			Expect(w.Write(rec)).To(Succeed())
+		}
+	})
+
+	It("round-trips records", func() {
+		r, err := capture.NewReader(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, expected := range records {
+			rec, err := r.Next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rec.Time.Equal(expected.Time)).To(BeTrue())
+			Expect(rec.Mode).To(Equal(expected.Mode))
+			Expect(rec.Raw).To(Equal(expected.Raw))
+		}
+
+		_, err = r.Next()
+		Expect(err).To(MatchError(io.EOF))
+	})
+
+	It("rejects input that is not a capture file", func() {
+		_, err := capture.NewReader(bytes.NewBufferString("not a capture"))
+		Expect(err).To(MatchError(capture.ErrBadMagic))
+
+		_, err = capture.NewReader(&bytes.Buffer{})
+		Expect(err).To(MatchError(capture.ErrBadMagic))
+	})
+
+	It("reports a truncated record", func() {
+		r, err := capture.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = r.Next()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = r.Next()
+		Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+	})
+
+	Describe("a corrupt length", func() {
+		corrupt := func(size uint32) *capture.Reader {
+			bits := append([]byte(nil), buf.Bytes()...)
+			// the length of the first record
+			binary.BigEndian.PutUint32(bits[8+9:8+13], size)
+
+			r, err := capture.NewReader(bytes.NewReader(bits))
+			Expect(err).ToNot(HaveOccurred())
+
+			return r
+		}
+
+		It("is rejected when it exceeds MaxRecordSize", func() {
+			_, err := corrupt(math.MaxUint32).Next()
+			Expect(errors.Is(err, capture.ErrCorrupt)).To(BeTrue())
+		})
+
+		It("reports a truncated record without allocating the length", func() {
+			r := corrupt(capture.MaxRecordSize)
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			_, err := r.Next()
+			Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+
+			runtime.ReadMemStats(&after)
+			Expect(after.TotalAlloc - before.TotalAlloc).To(BeNumerically("<", capture.MaxRecordSize/16))
+		})
+	})
+})
@@ -0,0 +1,153 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package capture
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+)
+
+// Recorder is a client.MessageClient that sends through another
+// MessageClient and records every message sent successfully. Messages are
+// recorded after they are sent, so they include the chunk option when the
+// underlying client requires acks.
+//
+// A failure to record does not fail the send; the first such error is
+// returned by Err.
+type Recorder struct {
+	client.SendFunc
+	mc client.MessageClient
+	w  *Writer
+
+	errLock sync.Mutex
+	err     error
+}
+
+var _ client.MessageClient = (*Recorder)(nil)
+
+// NewRecorder returns a Recorder that sends through mc and records to w.
+func NewRecorder(mc client.MessageClient, w *Writer) *Recorder {
+	r := &Recorder{
+		mc: mc,
+		w:  w,
+	}
+
+	r.SendFunc = r.Send
+
+	return r
+}
+
+func (r *Recorder) Connect() error {
+	return r.mc.Connect()
+}
+
+func (r *Recorder) Disconnect() error {
+	return r.mc.Disconnect()
+}
+
+func (r *Recorder) Reconnect() error {
+	return r.mc.Reconnect()
+}
+
+// Err returns the first error encountered while recording.
+func (r *Recorder) Err() error {
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+
+	return r.err
+}
+
+func (r *Recorder) setErr(err error) {
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *Recorder) record(raw []byte) {
+	err := r.w.Write(Record{
+		Time: time.Now(),
+		Mode: protocol.PeekMode(raw),
+		Raw:  raw,
+	})
+	if err != nil {
+		r.setErr(err)
+	}
+}
+
+func (r *Recorder) Send(e protocol.ChunkEncoder) error {
+	if err := r.mc.Send(e); err != nil {
+		return err
+	}
+
+	bits, err := protocol.Encode(e)
+	if err != nil {
+		r.setErr(err)
+		return nil
+	}
+
+	r.record(bits)
+
+	return nil
+}
+
+func (r *Recorder) SendRaw(raw []byte) error {
+	if err := r.mc.SendRaw(raw); err != nil {
+		return err
+	}
+
+	r.record(raw)
+
+	return nil
+}
+
+// Handler returns a server.Handler that records every message a server
+// receives and then passes it to next. A nil next acks every message.
+// If the message cannot be recorded, the error is returned and the
+// message is not acked.
+func Handler(w *Writer, next server.Handler) server.Handler {
+	return func(msg *server.Message) error {
+		err := w.Write(Record{
+			Time: msg.ReceivedAt,
+			Mode: msg.Mode,
+			Raw:  msg.Raw,
+		})
+		if err != nil {
+			return err
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		return next(msg)
+	}
+}
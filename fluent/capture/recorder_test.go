@@ -0,0 +1,169 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package capture_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/capture"
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func readAll(buf *bytes.Buffer) []*capture.Record {
+	r, err := capture.NewReader(buf)
+	Expect(err).ToNot(HaveOccurred())
+
+	var recs []*capture.Record
+
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return recs
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+		recs = append(recs, rec)
+	}
+}
+
+var _ = Describe("Recorder", func() {
+	var (
+		svr     *fluenttest.Server
+		buf     *bytes.Buffer
+		c       *client.Client
+		rec     *capture.Recorder
+		record  map[string]interface{}
+		entries protocol.EntryList
+	)
+
+	BeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+		buf = &bytes.Buffer{}
+
+		c = client.New(client.ConnectionOptions{
+			Factory:    svr.ConnFactory(),
+			RequireAck: true,
+		})
+
+		w, err := capture.NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		rec = capture.NewRecorder(c, w)
+		Expect(rec.Connect()).To(Succeed())
+
+		record = map[string]interface{}{"first": "Sir"}
+		entries = protocol.EntryList{{Timestamp: protocol.EventTimeNow(), Record: record}}
+	})
+
+	AfterEach(func() {
+		_ = rec.Disconnect()
+		svr.Close()
+	})
+
+	It("records every message sent", func() {
+		Expect(rec.SendMessage("msg", record)).To(Succeed())
+		Expect(rec.SendForward("fwd", entries)).To(Succeed())
+		Expect(rec.SendPacked("pkd", entries)).To(Succeed())
+		Expect(rec.SendCompressed("cmp", entries)).To(Succeed())
+		Expect(rec.Err()).ToNot(HaveOccurred())
+
+		recs := readAll(buf)
+		Expect(recs).To(HaveLen(4))
+
+		msgs := svr.Messages()
+		Expect(msgs).To(HaveLen(4))
+
+		modes := []protocol.MessageMode{
+			protocol.ModeMessage,
+			protocol.ModeForward,
+			protocol.ModePackedForward,
+			protocol.ModeCompressedPackedForward,
+		}
+
+		for i, r := range recs {
+			Expect(r.Mode).To(Equal(modes[i]))
+			Expect(r.Raw).To(Equal(msgs[i].Raw))
+		}
+	})
+
+	It("does not record failed sends", func() {
+		Expect(rec.Disconnect()).To(Succeed())
+		Expect(rec.SendMessage("msg", record)).ToNot(Succeed())
+		Expect(rec.SendRaw([]byte{0x90})).ToNot(Succeed())
+		Expect(readAll(buf)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Handler", func() {
+	It("records every message received", func() {
+		buf := &bytes.Buffer{}
+		w, err := capture.NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		received := make(chan *server.Message, 1)
+		svr := server.New(server.Options{
+			Handler: capture.Handler(w, func(msg *server.Message) error {
+				received <- msg
+				return nil
+			}),
+		})
+
+		defer svr.Close()
+
+		fake := &clientfakes.FakeConnectionFactory{}
+		fake.NewStub = func() (net.Conn, error) {
+			clientSide, serverSide := net.Pipe()
+			go func() { _ = svr.ServeConn(serverSide) }()
+
+			return clientSide, nil
+		}
+
+		c := client.New(client.ConnectionOptions{Factory: fake, RequireAck: true})
+		Expect(c.Connect()).To(Succeed())
+
+		defer c.Disconnect()
+
+		Expect(c.SendMessage("foo", map[string]interface{}{"first": "Sir"})).To(Succeed())
+
+		var msg *server.Message
+		Eventually(received).Should(Receive(&msg))
+
+		recs := readAll(buf)
+		Expect(recs).To(HaveLen(1))
+		Expect(recs[0].Raw).To(Equal(msg.Raw))
+		Expect(recs[0].Mode).To(Equal(protocol.ModeMessage))
+		Expect(recs[0].Time).To(BeTemporally("~", time.Now(), time.Second))
+	})
+})
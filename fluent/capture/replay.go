@@ -0,0 +1,88 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package capture
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Speed scales the pace at which messages are resent. 1 keeps the
+	// original intervals between messages, 2 halves them, and 0 sends as
+	// fast as possible.
+	Speed float64
+}
+
+// Replay resends every record read from r through mc and returns the
+// number of messages sent. Messages with a chunk option are sent with Send,
+// so the client waits for an ack if it requires one; other messages are
+// sent with SendRaw.
+func Replay(r *Reader, mc client.MessageClient, opts ReplayOptions) (int, error) {
+	var (
+		sent      int
+		first     time.Time
+		startedAt time.Time
+	)
+
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return sent, nil
+		}
+
+		if err != nil {
+			return sent, err
+		}
+
+		if opts.Speed > 0 {
+			if sent == 0 {
+				first, startedAt = rec.Time, time.Now()
+			}
+
+			offset := time.Duration(float64(rec.Time.Sub(first)) / opts.Speed)
+			if wait := time.Until(startedAt.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		if _, err = protocol.GetChunk(rec.Raw); err == nil {
+			err = mc.Send(protocol.RawMessage(rec.Raw))
+		} else {
+			err = mc.SendRaw(rec.Raw)
+		}
+
+		if err != nil {
+			return sent, err
+		}
+
+		sent++
+	}
+}
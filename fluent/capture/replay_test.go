@@ -0,0 +1,113 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package capture_test
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/capture"
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	var (
+		svr *fluenttest.Server
+		c   *client.Client
+		buf *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+		c = client.New(client.ConnectionOptions{
+			Factory:    svr.ConnFactory(),
+			RequireAck: true,
+		})
+		Expect(c.Connect()).To(Succeed())
+
+		buf = &bytes.Buffer{}
+		w, err := capture.NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		withChunk := protocol.NewMessage("chunked", map[string]interface{}{"first": "Sir"})
+		_, err = withChunk.Chunk()
+		Expect(err).ToNot(HaveOccurred())
+
+		withoutChunk := protocol.NewMessage("plain", map[string]interface{}{"last": "Gawain"})
+
+		start := time.Now()
+
+		for i, m := range []*protocol.Message{withChunk, withoutChunk} {
+			raw, err := m.MarshalMsg(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.Write(capture.Record{
+				Time: start.Add(time.Duration(i) * 200 * time.Millisecond),
+				Mode: protocol.ModeMessage,
+				Raw:  raw,
+			})).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		_ = c.Disconnect()
+		svr.Close()
+	})
+
+	replay := func(speed float64) (int, time.Duration) {
+		r, err := capture.NewReader(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		start := time.Now()
+		n, err := capture.Replay(r, c, capture.ReplayOptions{Speed: speed})
+		Expect(err).ToNot(HaveOccurred())
+
+		return n, time.Since(start)
+	}
+
+	It("resends every message as fast as possible", func() {
+		n, elapsed := replay(0)
+		Expect(n).To(Equal(2))
+		Expect(elapsed).To(BeNumerically("<", 200*time.Millisecond))
+
+		_, err := svr.WaitForEvents("plain", 1, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(svr.Events("chunked")).To(HaveLen(1))
+	})
+
+	It("keeps the original pace", func() {
+		_, elapsed := replay(1)
+		Expect(elapsed).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+
+	It("accelerates the pace", func() {
+		_, elapsed := replay(4)
+		Expect(elapsed).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(elapsed).To(BeNumerically("<", 200*time.Millisecond))
+	})
+})
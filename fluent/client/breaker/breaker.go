@@ -0,0 +1,363 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package breaker provides a client.MessageClient that stops sending to an
+// unhealthy destination for a while, so that sends fail fast instead of
+// each waiting for a timeout.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	DefaultConsecutiveFailures = 5
+	DefaultWindow              = 10 * time.Second
+	DefaultMinRequests         = 10
+	DefaultOpenTimeout         = 10 * time.Second
+)
+
+// ErrOpen is returned for sends that are not attempted because the breaker
+// is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets every send through.
+	Closed State = iota
+	// Open fails every send with ErrOpen.
+	Open
+	// HalfOpen lets a limited number of trial sends through to decide
+	// whether to close or open again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Options configures a Breaker.
+type Options struct {
+	Client client.MessageClient
+	// ConsecutiveFailures opens the breaker after that many failed sends
+	// in a row. It defaults to DefaultConsecutiveFailures unless
+	// FailureRate is set.
+	ConsecutiveFailures int
+	// FailureRate, if set, opens the breaker when the fraction of sends
+	// that failed in the last Window reaches it, once there were at least
+	// MinRequests sends. Window and MinRequests default to DefaultWindow
+	// and DefaultMinRequests.
+	FailureRate float64
+	Window      time.Duration
+	MinRequests int
+	// OpenTimeout is how long the breaker stays open before it lets trial
+	// sends through. Defaults to DefaultOpenTimeout.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial sends that must succeed to
+	// close the breaker. Defaults to 1.
+	HalfOpenRequests int
+	// OnStateChange, if set, is called after every change of state.
+	OnStateChange func(from, to State)
+}
+
+type transition struct {
+	from, to State
+}
+
+// Breaker is a client.MessageClient that counts the failed sends of
+// another MessageClient and opens when there are too many. While open,
+// sends and reconnects fail with ErrOpen without being attempted. After
+// OpenTimeout, the breaker is half-open: the first trial send reconnects
+// the client, the other trials wait for it, and the trials close the
+// breaker if they succeed and open it again if any fails.
+type Breaker struct {
+	client.SendFunc
+	client              client.MessageClient
+	consecutiveFailures int
+	failureRate         float64
+	minRequests         int
+	openTimeout         time.Duration
+	halfOpenRequests    int
+	onStateChange       func(from, to State)
+
+	lock     sync.Mutex
+	state    State
+	failures int
+	window   *window
+	openedAt time.Time
+	// trials is the number of trial sends started since the breaker was
+	// half-opened, and successes the number that succeeded.
+	trials    int
+	successes int
+	// reconnect is the reconnect of the first trial since the breaker was
+	// half-opened.
+	reconnect *trialReconnect
+}
+
+// trialReconnect is the result of the reconnect made by the first trial.
+// err is set before done is closed.
+type trialReconnect struct {
+	done chan struct{}
+	err  error
+}
+
+var _ client.MessageClient = (*Breaker)(nil)
+
+// New returns a Breaker configured by opts.
+func New(opts Options) *Breaker {
+	b := &Breaker{
+		client:              opts.Client,
+		consecutiveFailures: opts.ConsecutiveFailures,
+		failureRate:         opts.FailureRate,
+		minRequests:         opts.MinRequests,
+		openTimeout:         opts.OpenTimeout,
+		halfOpenRequests:    opts.HalfOpenRequests,
+		onStateChange:       opts.OnStateChange,
+	}
+
+	b.SendFunc = b.Send
+
+	if b.consecutiveFailures <= 0 && b.failureRate <= 0 {
+		b.consecutiveFailures = DefaultConsecutiveFailures
+	}
+
+	if b.failureRate > 0 {
+		if opts.Window <= 0 {
+			opts.Window = DefaultWindow
+		}
+
+		if b.minRequests <= 0 {
+			b.minRequests = DefaultMinRequests
+		}
+
+		b.window = newWindow(opts.Window)
+	}
+
+	if b.openTimeout <= 0 {
+		b.openTimeout = DefaultOpenTimeout
+	}
+
+	if b.halfOpenRequests <= 0 {
+		b.halfOpenRequests = 1
+	}
+
+	return b
+}
+
+func (b *Breaker) Connect() error {
+	return b.client.Connect()
+}
+
+func (b *Breaker) Disconnect() error {
+	return b.client.Disconnect()
+}
+
+// Reconnect reconnects the client while the breaker is closed. Otherwise
+// it returns ErrOpen, since the first trial send reconnects the client.
+func (b *Breaker) Reconnect() error {
+	if b.State() != Closed {
+		return ErrOpen
+	}
+
+	return b.client.Reconnect()
+}
+
+// State returns the current state. An open breaker whose OpenTimeout has
+// expired is reported as open until the next send.
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) Send(e protocol.ChunkEncoder) error {
+	return b.do(func() error {
+		return b.client.Send(e)
+	})
+}
+
+func (b *Breaker) SendRaw(raw []byte) error {
+	return b.do(func() error {
+		return b.client.SendRaw(raw)
+	})
+}
+
+func (b *Breaker) do(send func() error) error {
+	trial, first, reconnect, t, err := b.before()
+	b.notify(t)
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case first:
+		// the connection is likely broken after the failures that opened
+		// the breaker
+		err = client.ReconnectAndHandshake(b.client)
+		reconnect.err = err
+		close(reconnect.done)
+	case trial:
+		<-reconnect.done
+
+		// the first trial fails, and opens the breaker again
+		if reconnect.err != nil {
+			return ErrOpen
+		}
+	}
+
+	if err == nil {
+		err = send()
+	}
+
+	b.notify(b.after(trial, err))
+
+	return err
+}
+
+// before decides whether a send may be attempted, and whether it is a
+// trial and the first trial since the breaker was half-opened. Trials
+// also get the reconnect of the first trial.
+func (b *Breaker) before() (trial, first bool, reconnect *trialReconnect, t *transition, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.openTimeout {
+		t = b.setState(HalfOpen)
+	}
+
+	switch b.state {
+	case Open:
+		return false, false, nil, t, ErrOpen
+	case HalfOpen:
+		if b.trials >= b.halfOpenRequests {
+			return false, false, nil, t, ErrOpen
+		}
+
+		b.trials++
+
+		return true, b.trials == 1, b.reconnect, t, nil
+	}
+
+	return false, false, nil, t, nil
+}
+
+// after records the result of a send.
+func (b *Breaker) after(trial bool, err error) *transition {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if trial {
+		// another trial may have already decided
+		if b.state != HalfOpen {
+			return nil
+		}
+
+		if err != nil {
+			return b.setState(Open)
+		}
+
+		if b.successes++; b.successes >= b.halfOpenRequests {
+			return b.setState(Closed)
+		}
+
+		return nil
+	}
+
+	if b.state != Closed {
+		return nil
+	}
+
+	now := time.Now()
+
+	if b.window != nil {
+		b.window.add(now, err != nil)
+	}
+
+	if err == nil {
+		b.failures = 0
+		return nil
+	}
+
+	b.failures++
+
+	if b.consecutiveFailures > 0 && b.failures >= b.consecutiveFailures {
+		return b.setState(Open)
+	}
+
+	if b.window != nil {
+		total, failed := b.window.counts(now)
+		if total >= b.minRequests && float64(failed) >= b.failureRate*float64(total) {
+			return b.setState(Open)
+		}
+	}
+
+	return nil
+}
+
+// setState must be called with the lock held.
+func (b *Breaker) setState(s State) *transition {
+	t := &transition{from: b.state, to: s}
+	b.state = s
+
+	switch s {
+	case Closed:
+		b.failures = 0
+
+		if b.window != nil {
+			b.window.reset()
+		}
+	case Open:
+		b.openedAt = time.Now()
+	case HalfOpen:
+		b.trials, b.successes = 0, 0
+		b.reconnect = &trialReconnect{done: make(chan struct{})}
+	}
+
+	return t
+}
+
+func (b *Breaker) notify(t *transition) {
+	if t != nil && b.onStateChange != nil {
+		b.onStateChange(t.from, t.to)
+	}
+}
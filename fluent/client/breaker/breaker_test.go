@@ -0,0 +1,345 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package breaker_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/breaker"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Breaker", func() {
+	type change struct {
+		from, to breaker.State
+	}
+
+	var (
+		mc      *clientfakes.FakeMessageClient
+		opts    breaker.Options
+		b       *breaker.Breaker
+		lock    sync.Mutex
+		changes []change
+		errDown = errors.New("down")
+		record  = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	send := func() error {
+		return b.SendMessage("foo", record)
+	}
+
+	fail := func(n int) {
+		mc.SendReturns(errDown)
+
+		for i := 0; i < n; i++ {
+			ExpectWithOffset(1, send()).To(MatchError(errDown))
+		}
+	}
+
+	recorded := func() []change {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return append([]change(nil), changes...)
+	}
+
+	BeforeEach(func() {
+		mc = &clientfakes.FakeMessageClient{}
+		changes = nil
+
+		opts = breaker.Options{
+			Client:              mc,
+			ConsecutiveFailures: 3,
+			OpenTimeout:         20 * time.Millisecond,
+			OnStateChange: func(from, to breaker.State) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				changes = append(changes, change{from, to})
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		b = breaker.New(opts)
+		Expect(b.Connect()).To(Succeed())
+	})
+
+	It("starts closed", func() {
+		Expect(send()).To(Succeed())
+		Expect(b.State()).To(Equal(breaker.Closed))
+		Expect(recorded()).To(BeEmpty())
+	})
+
+	It("opens after consecutive failures", func() {
+		fail(3)
+
+		Expect(b.State()).To(Equal(breaker.Open))
+		Expect(recorded()).To(Equal([]change{{breaker.Closed, breaker.Open}}))
+	})
+
+	It("fails fast while open", func() {
+		fail(3)
+
+		Expect(send()).To(MatchError(breaker.ErrOpen))
+		Expect(b.SendRaw([]byte{0x90})).To(MatchError(breaker.ErrOpen))
+		Expect(mc.SendCallCount()).To(Equal(3))
+		Expect(mc.SendRawCallCount()).To(BeZero())
+	})
+
+	It("does not reconnect the client while open", func() {
+		fail(3)
+
+		Expect(b.Reconnect()).To(MatchError(breaker.ErrOpen))
+		Expect(mc.ReconnectCallCount()).To(BeZero())
+	})
+
+	It("counts only consecutive failures", func() {
+		fail(2)
+
+		mc.SendReturns(nil)
+		Expect(send()).To(Succeed())
+
+		fail(2)
+		Expect(b.State()).To(Equal(breaker.Closed))
+	})
+
+	When("the open timeout expires", func() {
+		JustBeforeEach(func() {
+			fail(3)
+			time.Sleep(30 * time.Millisecond)
+		})
+
+		It("reconnects and closes after a successful trial", func() {
+			mc.SendReturns(nil)
+
+			Expect(send()).To(Succeed())
+			Expect(mc.ReconnectCallCount()).To(Equal(1))
+			Expect(b.State()).To(Equal(breaker.Closed))
+			Expect(recorded()).To(Equal([]change{
+				{breaker.Closed, breaker.Open},
+				{breaker.Open, breaker.HalfOpen},
+				{breaker.HalfOpen, breaker.Closed},
+			}))
+
+			// the failures were forgotten
+			fail(2)
+			Expect(b.State()).To(Equal(breaker.Closed))
+		})
+
+		It("opens again after a failed trial", func() {
+			Expect(send()).To(MatchError(errDown))
+			Expect(b.State()).To(Equal(breaker.Open))
+			Expect(send()).To(MatchError(breaker.ErrOpen))
+			Expect(recorded()[2]).To(Equal(change{breaker.HalfOpen, breaker.Open}))
+		})
+
+		It("opens again if the client fails to reconnect", func() {
+			mc.ReconnectReturns(errors.New("refused"))
+
+			Expect(send()).To(MatchError("refused"))
+			Expect(mc.SendCallCount()).To(Equal(3))
+			Expect(b.State()).To(Equal(breaker.Open))
+		})
+
+		When("several trials are required", func() {
+			BeforeEach(func() {
+				opts.HalfOpenRequests = 2
+			})
+
+			It("closes once all of them succeed", func() {
+				mc.SendReturns(nil)
+
+				Expect(send()).To(Succeed())
+				Expect(b.State()).To(Equal(breaker.HalfOpen))
+
+				Expect(send()).To(Succeed())
+				Expect(b.State()).To(Equal(breaker.Closed))
+				Expect(mc.ReconnectCallCount()).To(Equal(1))
+			})
+
+			When("the first trial is reconnecting", func() {
+				var (
+					release   chan struct{}
+					errRecon  error
+					first     chan error
+					secondErr chan error
+				)
+
+				JustBeforeEach(func() {
+					release = make(chan struct{})
+					errRecon = nil
+					mc.SendReturns(nil)
+					mc.ReconnectStub = func() error {
+						<-release
+						return errRecon
+					}
+
+					first = make(chan error, 1)
+					go func() { first <- send() }()
+					Eventually(mc.ReconnectCallCount).Should(Equal(1))
+
+					secondErr = make(chan error, 1)
+					go func() { secondErr <- send() }()
+				})
+
+				It("makes the other trials wait for it", func() {
+					Consistently(mc.SendCallCount).Should(Equal(3))
+
+					close(release)
+					Eventually(first).Should(Receive(BeNil()))
+					Eventually(secondErr).Should(Receive(BeNil()))
+					Expect(mc.ReconnectCallCount()).To(Equal(1))
+					Expect(b.State()).To(Equal(breaker.Closed))
+				})
+
+				It("fails the other trials if it fails", func() {
+					errRecon = errors.New("refused")
+					close(release)
+
+					Eventually(first).Should(Receive(MatchError("refused")))
+					Eventually(secondErr).Should(Receive(MatchError(breaker.ErrOpen)))
+					Expect(mc.SendCallCount()).To(Equal(3))
+					Expect(b.State()).To(Equal(breaker.Open))
+				})
+			})
+		})
+
+		It("limits the trials in flight", func() {
+			release := make(chan struct{})
+			mc.SendStub = func(_ protocol.ChunkEncoder) error {
+				<-release
+				return nil
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- send()
+			}()
+
+			Eventually(mc.SendCallCount).Should(Equal(4))
+			Expect(send()).To(MatchError(breaker.ErrOpen))
+
+			close(release)
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(b.State()).To(Equal(breaker.Closed))
+		})
+	})
+
+	When("FailureRate is set", func() {
+		BeforeEach(func() {
+			opts.ConsecutiveFailures = 0
+			opts.FailureRate = 0.5
+			opts.MinRequests = 4
+			opts.Window = time.Minute
+		})
+
+		It("opens when the rate of failures is reached", func() {
+			for i := 0; i < 2; i++ {
+				mc.SendReturns(nil)
+				Expect(send()).To(Succeed())
+				Expect(b.State()).To(Equal(breaker.Closed))
+
+				fail(1)
+			}
+
+			Expect(b.State()).To(Equal(breaker.Open))
+		})
+
+		It("waits for MinRequests", func() {
+			fail(3)
+			Expect(b.State()).To(Equal(breaker.Closed))
+
+			fail(1)
+			Expect(b.State()).To(Equal(breaker.Open))
+		})
+	})
+
+	When("FailureRate is set with a short window", func() {
+		BeforeEach(func() {
+			opts.ConsecutiveFailures = 0
+			opts.FailureRate = 0.5
+			opts.MinRequests = 2
+			opts.Window = 50 * time.Millisecond
+		})
+
+		It("forgets old sends", func() {
+			fail(1)
+			time.Sleep(60 * time.Millisecond)
+
+			fail(1)
+			Expect(b.State()).To(Equal(breaker.Closed))
+		})
+	})
+	When("the server requires a handshake", func() {
+		var (
+			svr *fluenttest.Server
+			fc  *client.Client
+		)
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{
+				SharedKey: []byte("thisisasharedkey"),
+				DropAcks:  true,
+			})
+			DeferCleanup(svr.Close)
+
+			fc = client.New(client.ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: 50 * time.Millisecond,
+				AuthInfo:          client.AuthInfo{SharedKey: []byte("thisisasharedkey")},
+			})
+			opts.Client = fc
+			opts.ConsecutiveFailures = 1
+		})
+
+		JustBeforeEach(func() {
+			Expect(fc.Handshake()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(b.Disconnect()).To(Succeed())
+		})
+
+		It("performs it again before the trial", func() {
+			Expect(send()).ToNot(Succeed())
+			Expect(b.State()).To(Equal(breaker.Open))
+
+			svr.SetDropAcks(false)
+			time.Sleep(30 * time.Millisecond)
+
+			Expect(send()).To(Succeed())
+			Expect(b.State()).To(Equal(breaker.Closed))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package breaker
+
+import "time"
+
+const windowSlots = 10
+
+// window counts the sends and failures of a rolling period, in slots of a
+// tenth of the period each.
+type window struct {
+	width int64
+	slots [windowSlots]struct {
+		epoch         int64
+		total, failed int
+	}
+}
+
+func newWindow(size time.Duration) *window {
+	width := int64(size) / windowSlots
+	if width <= 0 {
+		width = 1
+	}
+
+	return &window{width: width}
+}
+
+func (w *window) add(now time.Time, failed bool) {
+	epoch := now.UnixNano() / w.width
+	s := &w.slots[epoch%windowSlots]
+
+	if s.epoch != epoch {
+		s.epoch, s.total, s.failed = epoch, 0, 0
+	}
+
+	s.total++
+
+	if failed {
+		s.failed++
+	}
+}
+
+func (w *window) counts(now time.Time) (total, failed int) {
+	epoch := now.UnixNano() / w.width
+
+	for _, s := range w.slots {
+		if epoch-s.epoch < windowSlots {
+			total += s.total
+			failed += s.failed
+		}
+	}
+
+	return
+}
+
+func (w *window) reset() {
+	for i := range w.slots {
+		w.slots[i].total, w.slots[i].failed = 0, 0
+	}
+}
@@ -0,0 +1,102 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Operations reported in Event.Op.
+const (
+	OpConnect    = "connect"
+	OpReconnect  = "reconnect"
+	OpDisconnect = "disconnect"
+	OpHandshake  = "handshake"
+	OpSend       = "send"
+	OpAck        = "ack"
+	OpRead       = "read"
+)
+
+// Event describes a client lifecycle event.
+type Event struct {
+	// Op is the operation that produced the event.
+	Op string
+	// RemoteAddr is the address of the peer, if known.
+	RemoteAddr net.Addr
+	// Chunk is the chunk ID of the message for send and ack events, if
+	// the message has one.
+	Chunk string
+	// Latency is the time from the start of a write to its ack.
+	Latency time.Duration
+	Err     error
+}
+
+// Callbacks are optional functions called on client lifecycle events.
+// They are called on the goroutine that caused the event, after the
+// client has released its locks, so they may call the client.
+type Callbacks struct {
+	// OnConnect is called after every Connect or Reconnect. Err is set if
+	// the connection failed.
+	OnConnect func(Event)
+	// OnHandshake is called after every Handshake. Err is set if the
+	// handshake failed.
+	OnHandshake func(Event)
+	// OnDisconnect is called when Disconnect or Reconnect closes a
+	// connection. Err is set if closing it failed.
+	OnDisconnect func(Event)
+	// OnAck is called when the ack of a chunk is received. WSClient does
+	// not wait for acks and never calls it.
+	OnAck func(Event)
+	// OnAckTimeout is called when the ack of a chunk is not received
+	// before the timeout.
+	OnAckTimeout func(Event)
+	// OnError is called when a send fails, when an ack fails for a reason
+	// other than a timeout, and when a WSClient connection fails to read.
+	OnError func(Event)
+}
+
+// pending collects callbacks so that they can be run after the locks
+// that were held when they were triggered are released.
+type pending []func()
+
+func (p *pending) add(fn func(Event), ev Event) {
+	if fn != nil {
+		*p = append(*p, func() { fn(ev) })
+	}
+}
+
+func (p pending) run() {
+	for _, fn := range p {
+		fn()
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+
+	return errors.As(err, &ne) && ne.Timeout()
+}
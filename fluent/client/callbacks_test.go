@@ -0,0 +1,226 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type eventLog struct {
+	lock   sync.Mutex
+	events map[string][]Event
+}
+
+func (l *eventLog) record(name string) func(Event) {
+	return func(ev Event) {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		l.events[name] = append(l.events[name], ev)
+	}
+}
+
+func (l *eventLog) get(name string) []Event {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.events[name]
+}
+
+func (l *eventLog) callbacks() Callbacks {
+	return Callbacks{
+		OnConnect:    l.record("connect"),
+		OnHandshake:  l.record("handshake"),
+		OnDisconnect: l.record("disconnect"),
+		OnAck:        l.record("ack"),
+		OnAckTimeout: l.record("ackTimeout"),
+		OnError:      l.record("error"),
+	}
+}
+
+var _ = Describe("Callbacks", func() {
+	var (
+		svr    *fluenttest.Server
+		events *eventLog
+		record = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	BeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{SharedKey: []byte("secret")})
+		events = &eventLog{events: map[string][]Event{}}
+	})
+
+	AfterEach(func() {
+		svr.Close()
+	})
+
+	Describe("Client", func() {
+		var c *Client
+
+		BeforeEach(func() {
+			c = New(ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: 100 * time.Millisecond,
+				AuthInfo:          AuthInfo{SharedKey: []byte("secret")},
+				Callbacks:         events.callbacks(),
+			})
+
+			Expect(c.Connect()).To(Succeed())
+			Expect(c.Handshake()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			_ = c.Disconnect()
+		})
+
+		It("reports the connection and handshake", func() {
+			connects := events.get("connect")
+			Expect(connects).To(HaveLen(1))
+			Expect(connects[0].Op).To(Equal(OpConnect))
+			Expect(connects[0].RemoteAddr.String()).To(Equal(svr.Addr))
+			Expect(connects[0].Err).ToNot(HaveOccurred())
+
+			handshakes := events.get("handshake")
+			Expect(handshakes).To(HaveLen(1))
+			Expect(handshakes[0].RemoteAddr.String()).To(Equal(svr.Addr))
+			Expect(handshakes[0].Err).ToNot(HaveOccurred())
+		})
+
+		It("reports acks with their chunk", func() {
+			msg := protocol.NewMessage("foo", record)
+			Expect(c.Send(msg)).To(Succeed())
+
+			chunk, err := msg.Chunk()
+			Expect(err).ToNot(HaveOccurred())
+
+			acks := events.get("ack")
+			Expect(acks).To(HaveLen(1))
+			Expect(acks[0].Op).To(Equal(OpAck))
+			Expect(acks[0].Chunk).To(Equal(chunk))
+			Expect(acks[0].Latency).To(BeNumerically(">", 0))
+		})
+
+		It("reports ack timeouts without holding locks", func() {
+			svr.SetDropAcks(true)
+
+			// reconnecting from the callback would deadlock under a lock
+			c.OnAckTimeout = func(ev Event) {
+				events.record("ackTimeout")(ev)
+				Expect(c.Reconnect()).To(Succeed())
+			}
+
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+
+			timeouts := events.get("ackTimeout")
+			Expect(timeouts).To(HaveLen(1))
+			Expect(timeouts[0].Chunk).ToNot(BeEmpty())
+			Expect(timeouts[0].Err).To(HaveOccurred())
+			Expect(events.get("error")).To(BeEmpty())
+
+			Expect(events.get("disconnect")).To(HaveLen(1))
+			Expect(events.get("connect")[1].Op).To(Equal(OpReconnect))
+		})
+
+		It("reports send errors", func() {
+			Expect(c.Disconnect()).To(Succeed())
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+			Expect(c.SendRaw([]byte{0x90})).ToNot(Succeed())
+
+			disconnects := events.get("disconnect")
+			Expect(disconnects).To(HaveLen(1))
+			Expect(disconnects[0].Op).To(Equal(OpDisconnect))
+			Expect(disconnects[0].RemoteAddr.String()).To(Equal(svr.Addr))
+
+			errs := events.get("error")
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0].Op).To(Equal(OpSend))
+			Expect(errs[0].Err).To(MatchError("no active session"))
+		})
+
+		It("reports failed handshakes", func() {
+			c.AuthInfo.SharedKey = []byte("wrong")
+
+			Expect(c.Reconnect()).To(Succeed())
+			Expect(c.Handshake()).ToNot(Succeed())
+
+			handshakes := events.get("handshake")
+			Expect(handshakes).To(HaveLen(2))
+			Expect(handshakes[1].Err).To(HaveOccurred())
+		})
+	})
+
+	It("reports failed connections", func() {
+		factory := &clientfakes.FakeConnectionFactory{}
+		factory.NewReturns(nil, errors.New("refused"))
+
+		c := New(ConnectionOptions{
+			Factory:   factory,
+			Callbacks: events.callbacks(),
+		})
+
+		Expect(c.Connect()).ToNot(Succeed())
+
+		connects := events.get("connect")
+		Expect(connects).To(HaveLen(1))
+		Expect(connects[0].RemoteAddr).To(BeNil())
+		Expect(connects[0].Err).To(MatchError("refused"))
+	})
+
+	Describe("WSClient", func() {
+		It("reports connections, disconnections, and send errors", func() {
+			c := NewWS(WSConnectionOptions{
+				Factory:   svr.WSConnectionFactory(),
+				Callbacks: events.callbacks(),
+			})
+
+			Expect(c.Connect()).To(Succeed())
+			Expect(c.Reconnect()).To(Succeed())
+			Expect(c.Disconnect()).To(Succeed())
+			Expect(c.Send(protocol.NewMessage("foo", record))).ToNot(Succeed())
+
+			connects := events.get("connect")
+			Expect(connects).To(HaveLen(2))
+			Expect(connects[0].RemoteAddr).ToNot(BeNil())
+			Expect(connects[1].Op).To(Equal(OpReconnect))
+
+			disconnects := events.get("disconnect")
+			Expect(disconnects).To(HaveLen(2))
+			Expect(disconnects[0].Op).To(Equal(OpReconnect))
+			Expect(disconnects[1].Op).To(Equal(OpDisconnect))
+
+			Expect(events.get("error")).To(ContainElement(HaveField("Op", OpSend)))
+		})
+	})
+})
@@ -25,6 +25,7 @@ SOFTWARE.
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -71,13 +72,30 @@ type ConnectionFactory interface {
 
 type Client struct {
 	ConnectionFactory
-	RequireAck  bool
-	Timeout     time.Duration
-	AuthInfo    AuthInfo
-	Hostname    string
+	Callbacks
+	RequireAck bool
+	Timeout    time.Duration
+	AuthInfo   AuthInfo
+	Hostname   string
+	Metrics    Metrics
+	Logger     Logger
+	// ChunkIDGenerator generates the chunk IDs of messages sent with
+	// RequireAck. protocol.DefaultChunkIDGenerator is used if nil.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	// Coalesce, if set, enables the coalescing write path.
+	Coalesce    *CoalesceOptions
 	session     *Session
 	ackLock     sync.Mutex
 	sessionLock sync.RWMutex
+	flights     flights
+	encoders    encoderPool
+	// conn is the connection of the session. It has its own lock so that
+	// abort can reach it while sends hold the session lock.
+	connLock sync.Mutex
+	conn     net.Conn
+	// aborted is set by abort, after which the sends must not extend the
+	// deadline of conn. It is guarded by connLock.
+	aborted bool
 }
 
 type ConnectionOptions struct {
@@ -88,17 +106,37 @@ type ConnectionOptions struct {
 	// ReadTimeout       time.Duration
 	// WriteTimeout      time.Duration
 	AuthInfo AuthInfo
+	// Metrics, if set, receives measurements of connections and sends.
+	Metrics Metrics
+	// Logger, if set, receives debug events for the connection, handshake,
+	// sends, and acks.
+	Logger Logger
+	// ChunkIDGenerator, if set, generates the chunk IDs of messages sent
+	// with RequireAck.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	// Coalesce, if set, makes sends go through a writer goroutine that
+	// coalesces the writes of concurrent sends.
+	Coalesce *CoalesceOptions
+	Callbacks
 }
 
 type AuthInfo struct {
 	SharedKey []byte
-	Username  string
-	Password  string
+	// Username and Password are sent during the handshake when set. The
+	// password is digested with the auth salt provided by the server.
+	Username string
+	Password string
 }
 
 type Session struct {
 	Connection     net.Conn
 	TransportPhase bool
+	// writer is set when writes are coalesced.
+	writer *coalescer
+	// writeLock serializes the writes to Connection, which otherwise go
+	// through enc.
+	writeLock sync.Mutex
+	enc       *msgp.Writer
 }
 
 func New(opts ConnectionOptions) *Client {
@@ -119,9 +157,28 @@ func New(opts ConnectionOptions) *Client {
 		AuthInfo:          opts.AuthInfo,
 		RequireAck:        opts.RequireAck,
 		Timeout:           opts.ConnectionTimeout,
+		Metrics:           opts.Metrics,
+		Logger:            opts.Logger,
+		ChunkIDGenerator:  opts.ChunkIDGenerator,
+		Coalesce:          opts.Coalesce,
+		Callbacks:         opts.Callbacks,
 	}
 }
 
+func (c *Client) log() Logger {
+	if c.Logger == nil {
+		return noopLogger{}
+	}
+
+	return c.Logger
+}
+
+// debugEnabled reports whether debug events are logged, so that the sends
+// can skip boxing their arguments, which allocates.
+func (c *Client) debugEnabled() bool {
+	return c.Logger != nil && debugEnabled(c.Logger)
+}
+
 // TransportPhase indicates if the client has completed the
 // initial connection handshake.
 func (c *Client) TransportPhase() bool {
@@ -131,9 +188,26 @@ func (c *Client) TransportPhase() bool {
 	return c.session != nil && c.session.TransportPhase
 }
 
+func (c *Client) remoteAddr() net.Addr {
+	c.sessionLock.RLock()
+	defer c.sessionLock.RUnlock()
+
+	return c.sessionAddr()
+}
+
+// sessionAddr must be called with the session lock held.
+func (c *Client) sessionAddr() net.Addr {
+	if c.session == nil || c.session.Connection == nil {
+		return nil
+	}
+
+	return c.session.Connection.RemoteAddr()
+}
+
 func (c *Client) connect() error {
 	conn, err := c.New()
 	if err != nil {
+		c.log().Error("dial failed", "err", err)
 		return err
 	}
 
@@ -141,6 +215,14 @@ func (c *Client) connect() error {
 		Connection: conn,
 	}
 
+	c.setConn(conn)
+
+	if c.Coalesce != nil {
+		c.session.writer = newCoalescer(conn, *c.Coalesce)
+	}
+
+	c.log().Debug("connected", "remote", c.sessionAddr())
+
 	// If no shared key, handshake mode is not required
 	if c.AuthInfo.SharedKey == nil {
 		c.session.TransportPhase = true
@@ -155,6 +237,23 @@ func (c *Client) connect() error {
 // handshake puts the connection into message (or forward) mode, at which time
 // the client is free to send event messages.
 func (c *Client) Handshake() error {
+	err := c.handshake()
+	if err != nil {
+		c.log().Error("handshake failed", "err", err)
+	}
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveHandshake(err)
+	}
+
+	if c.OnHandshake != nil {
+		c.OnHandshake(Event{Op: OpHandshake, RemoteAddr: c.remoteAddr(), Err: err})
+	}
+
+	return err
+}
+
+func (c *Client) handshake() error {
 	c.sessionLock.RLock()
 	defer c.sessionLock.RUnlock()
 
@@ -171,6 +270,8 @@ func (c *Client) Handshake() error {
 		return err
 	}
 
+	c.log().Debug("received HELO", "auth", len(helo.Options.Auth) > 0, "keepalive", helo.Options.Keepalive)
+
 	salt := make([]byte, 16)
 
 	_, err = rand.Read(salt)
@@ -178,7 +279,16 @@ func (c *Client) Handshake() error {
 		return err
 	}
 
-	ping, err := protocol.NewPing(c.Hostname, c.AuthInfo.SharedKey, salt, helo.Options.Nonce)
+	var ping *protocol.Ping
+
+	if len(c.AuthInfo.Username) > 0 {
+		digest := protocol.ComputePasswordDigest(helo.Options.Auth, c.AuthInfo.Username, c.AuthInfo.Password)
+		ping, err = protocol.NewPingWithAuth(c.Hostname, c.AuthInfo.SharedKey, salt,
+			helo.Options.Nonce, c.AuthInfo.Username, digest)
+	} else {
+		ping, err = protocol.NewPing(c.Hostname, c.AuthInfo.SharedKey, salt, helo.Options.Nonce)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -188,6 +298,8 @@ func (c *Client) Handshake() error {
 		return err
 	}
 
+	c.log().Debug("sent PING", "hostname", c.Hostname, "username", c.AuthInfo.Username)
+
 	var pong protocol.Pong
 
 	err = pong.DecodeMsg(r)
@@ -195,6 +307,12 @@ func (c *Client) Handshake() error {
 		return err
 	}
 
+	c.log().Debug("received PONG", "hostname", pong.ServerHostname, "auth_result", pong.AuthResult)
+
+	if !pong.AuthResult {
+		return fmt.Errorf("authentication failed: %s", pong.Reason)
+	}
+
 	if err := protocol.ValidatePongDigest(&pong, c.AuthInfo.SharedKey,
 		helo.Options.Nonce, salt); err != nil {
 		return err
@@ -208,46 +326,181 @@ func (c *Client) Handshake() error {
 // Connect initializes the Session and Connection objects by opening
 // a client connect to the target configured in the ConnectionFactory
 func (c *Client) Connect() error {
+	var p pending
+
+	err := c.lockedConnect(false, &p)
+	p.run()
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveConnect(false, err)
+	}
+
+	return err
+}
+
+func (c *Client) lockedConnect(reconnect bool, p *pending) error {
 	c.sessionLock.Lock()
 	defer c.sessionLock.Unlock()
 
-	if c.session != nil {
-		return errors.New("a session is already active")
+	op := OpConnect
+
+	if reconnect {
+		op = OpReconnect
+
+		_ = c.lockedDisconnect(op, p)
+	} else if c.session != nil {
+		err := errors.New("a session is already active")
+		p.add(c.OnConnect, Event{Op: op, RemoteAddr: c.sessionAddr(), Err: err})
+
+		return err
 	}
 
-	return c.connect()
+	err := c.connect()
+	p.add(c.OnConnect, Event{Op: op, RemoteAddr: c.sessionAddr(), Err: err})
+
+	return err
+}
+
+// lockedDisconnect must be called with the session lock held.
+func (c *Client) lockedDisconnect(op string, p *pending) error {
+	if c.session == nil {
+		return nil
+	}
+
+	addr := c.sessionAddr()
+	err := c.disconnect()
+
+	c.log().Debug("disconnected", "remote", addr, "op", op)
+	p.add(c.OnDisconnect, Event{Op: op, RemoteAddr: addr, Err: err})
+
+	return err
 }
 
 func (c *Client) disconnect() (err error) {
 	if c.session != nil {
+		if c.session.writer != nil {
+			c.session.writer.close()
+		}
+
 		err = c.session.Connection.Close()
 	}
 
 	c.session = nil
+	c.setConn(nil)
 
 	return
 }
 
+func (c *Client) setConn(conn net.Conn) {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
+	c.conn = conn
+}
+
 // Disconnect terminates a client connection
 func (c *Client) Disconnect() error {
+	var p pending
+
 	c.sessionLock.Lock()
-	defer c.sessionLock.Unlock()
+	err := c.lockedDisconnect(OpDisconnect, &p)
+	c.sessionLock.Unlock()
 
-	return c.disconnect()
+	p.run()
+
+	return err
+}
+
+// Shutdown stops accepting sends, which then fail with ErrShutdown, waits
+// for the sends in progress and their acks, and disconnects. If ctx is
+// done first, the sends in progress are aborted and a *ShutdownError
+// describes them. The Client cannot send after Shutdown.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.log().Debug("shutting down")
+
+	var serr *ShutdownError
+
+	drained := c.flights.close()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		serr = c.flights.shutdownError(ctx.Err())
+		c.log().Warn("aborting sends", "in_flight", serr.InFlight, "chunks", serr.Chunks)
+
+		c.abort()
+		<-drained
+	}
+
+	err := c.Disconnect()
+	if serr != nil {
+		return serr
+	}
+
+	return err
+}
+
+// abort makes the reads and writes in progress fail. It does not take the
+// session lock, which a Reconnect or Disconnect may be waiting for behind
+// the sends.
+func (c *Client) abort() {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
+	c.aborted = true
+
+	if c.conn != nil {
+		_ = c.conn.SetDeadline(time.Now())
+	}
+}
+
+// setAckDeadline sets the read deadline of conn for an ack, unless the
+// sends were aborted, in which case the deadline set by abort is kept.
+func (c *Client) setAckDeadline(conn net.Conn) error {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
+	if c.aborted {
+		return nil
+	}
+
+	return conn.SetReadDeadline(time.Now().Add(c.Timeout))
 }
 
 func (c *Client) Reconnect() error {
-	c.sessionLock.Lock()
-	defer c.sessionLock.Unlock()
+	var p pending
+
+	c.log().Debug("reconnecting")
+
+	err := c.lockedConnect(true, &p)
+	p.run()
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveConnect(true, err)
+	}
+
+	return err
+}
+
+// ReconnectAndHandshake reconnects mc and, when mc is a *Client with a
+// shared key, performs the handshake again, which Reconnect does not do.
+// Clients that reconnect the client they wrap use it, so that they recover
+// against servers that require authentication.
+func ReconnectAndHandshake(mc interface{ Reconnect() error }) error {
+	if err := mc.Reconnect(); err != nil {
+		return err
+	}
 
-	_ = c.disconnect()
+	if c, ok := mc.(*Client); ok && c.AuthInfo.SharedKey != nil {
+		return c.Handshake()
+	}
 
-	return c.connect()
+	return nil
 }
 
 func (c *Client) checkAck(chunk string) error {
 	if c.Timeout != 0 {
-		if err := c.session.Connection.SetReadDeadline(time.Now().Add(c.Timeout)); err != nil {
+		if err := c.setAckDeadline(c.session.Connection); err != nil {
 			return err
 		}
 	}
@@ -267,43 +520,194 @@ func (c *Client) checkAck(chunk string) error {
 // Send sends a single protocol.ChunkEncoder across the wire.  If the session
 // is not yet in transport phase, an error is returned, and no message is sent.
 func (c *Client) Send(e protocol.ChunkEncoder) error {
+	var info MessageInfo
+
+	if c.Metrics != nil {
+		var (
+			raw protocol.RawMessage
+			err error
+		)
+
+		enc := c.encoders.get()
+		defer c.encoders.put(enc)
+
+		if info, raw, err = encodeForMetrics(enc, e, c.RequireAck, c.ChunkIDGenerator); err != nil {
+			c.Metrics.ObserveSend(info, err)
+			c.Metrics.ObserveError(err)
+
+			return err
+		}
+
+		e = raw
+	}
+
+	r, err := c.send(e)
+	c.observeSend(info, r, err)
+
+	return err
+}
+
+type sendResult struct {
+	written    bool
+	chunk      string
+	latency    time.Duration
+	remoteAddr net.Addr
+}
+
+// send writes e and, if acks are required, waits for its ack.
+func (c *Client) send(e protocol.ChunkEncoder) (r sendResult, err error) {
+	if err = c.flights.begin(); err != nil {
+		return r, err
+	}
+
+	defer func() {
+		c.flights.end(r.chunk)
+	}()
+
 	c.sessionLock.RLock()
 	defer c.sessionLock.RUnlock()
 
 	if c.session == nil {
-		return errors.New("no active session")
+		return r, errors.New("no active session")
 	}
 
 	if !c.session.TransportPhase {
-		return errors.New("session handshake not completed")
+		return r, errors.New("session handshake not completed")
 	}
 
-	var (
-		chunk string
-		err   error
-	)
+	r.remoteAddr = c.sessionAddr()
 
 	if c.RequireAck {
-		if chunk, err = e.Chunk(); err != nil {
-			return err
+		if r.chunk, err = protocol.ChunkWith(e, c.ChunkIDGenerator); err != nil {
+			return r, err
 		}
 
 		c.ackLock.Lock()
 		defer c.ackLock.Unlock()
 	}
 
-	err = msgp.Encode(c.session.Connection, e)
+	start := time.Now()
+
+	err = c.write(e)
 	if err != nil || !c.RequireAck {
-		return err
+		r.written = err == nil
+		return r, err
 	}
 
-	return c.checkAck(chunk)
+	r.written = true
+
+	c.flights.awaitAck(r.chunk)
+	err = c.checkAck(r.chunk)
+	r.latency = time.Since(start)
+
+	return r, err
+}
+
+// observeSend reports the result of send to the metrics and callbacks.
+func (c *Client) observeSend(info MessageInfo, r sendResult, err error) {
+	ev := Event{
+		Op:         OpSend,
+		RemoteAddr: r.remoteAddr,
+		Chunk:      r.chunk,
+		Err:        err,
+	}
+
+	if !r.written {
+		c.log().Error("send failed", "chunk", r.chunk, "err", err)
+
+		if c.Metrics != nil {
+			c.Metrics.ObserveSend(info, err)
+
+			if err != nil {
+				c.Metrics.ObserveError(err)
+			}
+		}
+
+		if err != nil && c.OnError != nil {
+			c.OnError(ev)
+		}
+
+		return
+	}
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveSend(info, nil)
+
+		if c.RequireAck {
+			c.Metrics.ObserveAck(info, r.latency, err)
+
+			if err != nil {
+				c.Metrics.ObserveError(err)
+			}
+		}
+	}
+
+	if !c.RequireAck {
+		c.log().Debug("sent message")
+		return
+	}
+
+	if c.debugEnabled() {
+		c.Logger.Debug("sent message", "chunk", r.chunk)
+	}
+
+	ev.Op = OpAck
+	ev.Latency = r.latency
+
+	switch {
+	case err == nil:
+		if c.debugEnabled() {
+			c.Logger.Debug("received ack", "chunk", r.chunk, "latency", r.latency)
+		}
+
+		if c.OnAck != nil {
+			c.OnAck(ev)
+		}
+	case isTimeout(err):
+		c.log().Warn("ack timed out", "chunk", r.chunk, "err", err)
+
+		if c.OnAckTimeout != nil {
+			c.OnAckTimeout(ev)
+		}
+	default:
+		c.log().Error("ack failed", "chunk", r.chunk, "err", err)
+
+		if c.OnError != nil {
+			c.OnError(ev)
+		}
+	}
 }
 
 // SendRaw sends bytes across the wire. If the session
 // is not yet in transport phase, an error is returned,
 // and no message is sent.
 func (c *Client) SendRaw(m []byte) error {
+	err := c.sendRaw(m)
+
+	if c.Metrics != nil {
+		observeRaw(c.Metrics, m, err)
+	}
+
+	if err != nil {
+		c.log().Error("send failed", "err", err)
+
+		if c.OnError != nil {
+			c.OnError(Event{Op: OpSend, RemoteAddr: c.remoteAddr(), Err: err})
+		}
+	} else if c.debugEnabled() {
+		c.Logger.Debug("sent raw message", "bytes", len(m))
+	}
+
+	return err
+}
+
+func (c *Client) sendRaw(m []byte) error {
+	if err := c.flights.begin(); err != nil {
+		return err
+	}
+
+	defer c.flights.end("")
+
 	c.sessionLock.RLock()
 	defer c.sessionLock.RUnlock()
 
@@ -315,13 +719,63 @@ func (c *Client) SendRaw(m []byte) error {
 		return errors.New("session handshake not completed")
 	}
 
-	_, err := c.session.Connection.Write(m)
+	s := c.session
+	if s.writer != nil {
+		return s.writer.write(m)
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	_, err := s.Connection.Write(m)
+
+	return err
+}
+
+// write encodes e to the connection, or through the session writer. It
+// must be called with the session lock held.
+func (c *Client) write(e protocol.ChunkEncoder) error {
+	s := c.session
+	if s.writer != nil {
+		enc := c.encoders.get()
+		defer c.encoders.put(enc)
+
+		bits, err := enc.encode(e)
+		if err != nil {
+			return err
+		}
+
+		return s.writer.write(bits)
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if s.enc == nil {
+		s.enc = msgp.NewWriter(s.Connection)
+	}
+
+	err := e.EncodeMsg(s.enc)
+	if err == nil {
+		err = s.enc.Flush()
+	}
+
+	if err != nil {
+		// drop what is left of the message
+		s.enc.Reset(s.Connection)
+	}
 
 	return err
 }
 
+// The Send* helpers build their message from a pooled encoder, which is
+// reused once the send returns.
+
 func (c *Client) SendPacked(tag string, entries protocol.EntryList) error {
-	msg, err := protocol.NewPackedForwardMessage(tag, entries)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
+
+	msg, err := enc.newPackedForwardMessage(tag, entries)
 	if err == nil {
 		err = c.Send(msg)
 	}
@@ -330,31 +784,38 @@ func (c *Client) SendPacked(tag string, entries protocol.EntryList) error {
 }
 
 func (c *Client) SendPackedFromBytes(tag string, entries []byte) error {
-	msg := protocol.NewPackedForwardMessageFromBytes(tag, entries)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
 
-	return c.Send(msg)
+	return c.Send(enc.newPackedForwardMessageFromBytes(tag, entries))
 }
 
 func (c *Client) SendMessage(tag string, record interface{}) error {
-	msg := protocol.NewMessage(tag, record)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
 
-	return c.Send(msg)
+	return c.Send(enc.newMessage(tag, record))
 }
 
 func (c *Client) SendMessageExt(tag string, record interface{}) error {
-	msg := protocol.NewMessageExt(tag, record)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
 
-	return c.Send(msg)
+	return c.Send(enc.newMessageExt(tag, record))
 }
 
 func (c *Client) SendForward(tag string, entries protocol.EntryList) error {
-	msg := protocol.NewForwardMessage(tag, entries)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
 
-	return c.Send(msg)
+	return c.Send(enc.newForwardMessage(tag, entries))
 }
 
 func (c *Client) SendCompressed(tag string, entries protocol.EntryList) error {
-	msg, err := protocol.NewCompressedPackedForwardMessage(tag, entries)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
+
+	msg, err := enc.newCompressedPackedForwardMessage(tag, entries)
 	if err == nil {
 		err = c.Send(msg)
 	}
@@ -363,7 +824,10 @@ func (c *Client) SendCompressed(tag string, entries protocol.EntryList) error {
 }
 
 func (c *Client) SendCompressedFromBytes(tag string, entries []byte) error {
-	msg, err := protocol.NewCompressedPackedForwardMessageFromBytes(tag, entries)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
+
+	msg, err := enc.newCompressedPackedForwardMessageFromBytes(tag, entries)
 	if err == nil {
 		err = c.Send(msg)
 	}
@@ -29,6 +29,7 @@ import (
 	"math/rand"
 	"net"
 	"reflect"
+	"strings"
 	"time"
 
 	. "github.com/aanujj/fluent-forward-go/fluent/client"
@@ -257,6 +258,34 @@ var _ = Describe("Client", func() {
 
 				<-done
 			})
+
+			Context("ChunkIDGenerator is set", func() {
+				JustBeforeEach(func() {
+					client.ChunkIDGenerator = &protocol.SequentialChunkIDGenerator{Prefix: "test-"}
+				})
+
+				It("generates the chunk with it", func() {
+					done := make(chan bool)
+					go func() {
+						defer GinkgoRecover()
+						defer func() { done <- true }()
+						err := client.Send(&msg)
+						Expect(err).ToNot(HaveOccurred())
+					}()
+
+					rcvd := &protocol.MessageExt{}
+					err := rcvd.DecodeMsg(serverReader)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(rcvd.Options.Chunk).To(Equal("test-1"))
+
+					ack := &protocol.AckMessage{Ack: "test-1"}
+					err = ack.EncodeMsg(serverWriter)
+					Expect(err).ToNot(HaveOccurred())
+					serverWriter.Flush()
+
+					<-done
+				})
+			})
 		})
 	})
 
@@ -429,6 +458,29 @@ var _ = Describe("Client", func() {
 				})
 			})
 		})
+
+		Context("When sends are concurrent", func() {
+			It("does not interleave the messages", func() {
+				// larger than the write buffer, so that every message takes
+				// several writes
+				record := map[string]interface{}{"pad": strings.Repeat("x", 16*1024)}
+
+				const n = 10
+				for i := 0; i < n; i++ {
+					go func() {
+						defer GinkgoRecover()
+						Expect(client.SendMessageExt("ext", record)).To(Succeed())
+					}()
+				}
+
+				r := msgp.NewReader(serverSide)
+				for i := 0; i < n; i++ {
+					var msg protocol.MessageExt
+					Expect(msg.DecodeMsg(r)).To(Succeed())
+					Expect(msg.Tag).To(Equal("ext"))
+				}
+			})
+		})
 	})
 
 	Describe("Handshake", func() {
@@ -497,6 +549,63 @@ var _ = Describe("Client", func() {
 			<-hs
 		})
 
+		Context("When the client has a username and password", func() {
+			var authSalt []byte
+
+			BeforeEach(func() {
+				client.AuthInfo.Username = "gawain"
+				client.AuthInfo.Password = "greenknight"
+
+				authSalt = []byte("authsalt")
+				helo.Options.Auth = authSalt
+			})
+
+			It("Sends the credentials", func() {
+				hs := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(hs)
+					Expect(client.Handshake()).To(Succeed())
+				}()
+
+				Expect(helo.EncodeMsg(serverWriter)).To(Succeed())
+				serverWriter.Flush()
+
+				Expect(ping.DecodeMsg(serverReader)).To(Succeed())
+				Expect(ping.Username).To(Equal("gawain"))
+				Expect(ping.Password).To(Equal(
+					protocol.ComputePasswordDigest(authSalt, "gawain", "greenknight")))
+
+				pong, err := protocol.NewPong(true, "", "", sharedKey, helo, &ping)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pong.EncodeMsg(serverWriter)).To(Succeed())
+				serverWriter.Flush()
+				<-hs
+			})
+
+			It("Returns an error when the server rejects the credentials", func() {
+				hs := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(hs)
+					err := client.Handshake()
+					Expect(err).To(MatchError(ContainSubstring("bad password")))
+					Expect(client.TransportPhase()).To(BeFalse())
+				}()
+
+				Expect(helo.EncodeMsg(serverWriter)).To(Succeed())
+				serverWriter.Flush()
+
+				Expect(ping.DecodeMsg(serverReader)).To(Succeed())
+
+				pong, err := protocol.NewPong(false, "bad password", "", sharedKey, helo, &ping)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pong.EncodeMsg(serverWriter)).To(Succeed())
+				serverWriter.Flush()
+				<-hs
+			})
+		})
+
 		Context("When the client is not currently connected", func() {
 			JustBeforeEach(func() {
 				err := client.Disconnect()
@@ -0,0 +1,186 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const DefaultCoalesceBufferSize = 64 * 1024
+
+var errWriterClosed = errors.New("session writer closed")
+
+// donePool holds the channels that writes wait on. The writer sends a
+// single result on each, so a channel is empty again once it is received.
+var donePool = sync.Pool{
+	New: func() interface{} {
+		return make(chan error, 1)
+	},
+}
+
+// CoalesceOptions enables the coalescing write path of a Client. Sends
+// hand their encoded message to a single writer goroutine per connection,
+// which buffers the messages of concurrent sends and writes them together,
+// with fewer syscalls. Every send still waits until its message is written
+// and returns the error of that write.
+//
+// With RequireAck, sends are serialized while they wait for their ack, so
+// messages are not coalesced.
+type CoalesceOptions struct {
+	// BufferSize is the size of the write buffer, which is written when it
+	// is full. Defaults to DefaultCoalesceBufferSize.
+	BufferSize int
+	// Linger is how long the writer waits for more messages before it
+	// writes the buffer. Zero writes it as soon as no more messages are
+	// queued.
+	Linger time.Duration
+}
+
+type writeRequest struct {
+	bits []byte
+	done chan error
+}
+
+// coalescer is the writer goroutine of a session.
+type coalescer struct {
+	w        *bufio.Writer
+	linger   time.Duration
+	requests chan writeRequest
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newCoalescer(conn io.Writer, opts CoalesceOptions) *coalescer {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultCoalesceBufferSize
+	}
+
+	co := &coalescer{
+		w:        bufio.NewWriterSize(conn, opts.BufferSize),
+		linger:   opts.Linger,
+		requests: make(chan writeRequest, 64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go co.run()
+
+	return co
+}
+
+// write queues bits and waits until they are written.
+func (co *coalescer) write(bits []byte) error {
+	done := donePool.Get().(chan error)
+	req := writeRequest{bits: bits, done: done}
+
+	select {
+	case co.requests <- req:
+	case <-co.done:
+		donePool.Put(done)
+		return errWriterClosed
+	}
+
+	err := <-done
+	donePool.Put(done)
+
+	return err
+}
+
+// close writes what is buffered and stops the writer. There must be no
+// concurrent write.
+func (co *coalescer) close() {
+	close(co.stop)
+	<-co.done
+}
+
+func (co *coalescer) run() {
+	defer close(co.done)
+
+	var (
+		// pending holds the sends whose messages are buffered.
+		pending []chan error
+		timer   *time.Timer
+		timerC  <-chan time.Time
+	)
+
+	flush := func() {
+		err := co.w.Flush()
+
+		for _, done := range pending {
+			done <- err
+		}
+
+		pending = pending[:0]
+
+		if timerC != nil {
+			if !timer.Stop() {
+				<-timer.C
+			}
+
+			timerC = nil
+		}
+	}
+
+	for {
+		select {
+		case req := <-co.requests:
+			// an error is kept by the bufio.Writer and returned by Flush
+			_, _ = co.w.Write(req.bits)
+			pending = append(pending, req.done)
+
+			switch {
+			case len(co.requests) > 0:
+				// more messages are coming
+			case co.linger <= 0 || co.w.Buffered() == 0:
+				flush()
+			case timerC == nil:
+				if timer == nil {
+					timer = time.NewTimer(co.linger)
+				} else {
+					timer.Reset(co.linger)
+				}
+
+				timerC = timer.C
+			}
+		case <-timerC:
+			timerC = nil
+			flush()
+		case <-co.stop:
+			for {
+				select {
+				case req := <-co.requests:
+					req.done <- errWriterClosed
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
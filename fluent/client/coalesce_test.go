@@ -0,0 +1,178 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// countingConn counts the writes to a connection.
+type countingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+type countingFactory struct {
+	ConnectionFactory
+	writes int64
+	last   net.Conn
+}
+
+func (f *countingFactory) New() (net.Conn, error) {
+	conn, err := f.ConnectionFactory.New()
+	if err != nil {
+		return nil, err
+	}
+
+	f.last = conn
+
+	return countingConn{Conn: conn, writes: &f.writes}, nil
+}
+
+var _ = Describe("Coalesce", func() {
+	var (
+		svr     *fluenttest.Server
+		factory *countingFactory
+		opts    ConnectionOptions
+		c       *Client
+		record  = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	sendConcurrently := func(n int) {
+		var wg sync.WaitGroup
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+			}()
+		}
+
+		wg.Wait()
+	}
+
+	BeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+		factory = &countingFactory{ConnectionFactory: svr.ConnFactory()}
+		opts = ConnectionOptions{
+			Factory:  factory,
+			Coalesce: &CoalesceOptions{Linger: 20 * time.Millisecond},
+		}
+	})
+
+	JustBeforeEach(func() {
+		c = New(opts)
+		Expect(c.Connect()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(c.Disconnect()).To(Succeed())
+		svr.Close()
+	})
+
+	It("writes the messages of concurrent sends together", func() {
+		sendConcurrently(50)
+
+		Eventually(func() int { return len(svr.Events("foo")) }).Should(Equal(50))
+		Expect(atomic.LoadInt64(&factory.writes)).To(BeNumerically("<", 10))
+	})
+
+	It("writes a lone message after the linger interval", func() {
+		start := time.Now()
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+
+		Eventually(func() int { return len(svr.Events("foo")) }).Should(Equal(1))
+	})
+
+	It("writes raw messages", func() {
+		Expect(c.SendRaw([]byte{0x93, 0xa3, 'b', 'a', 'r', 0x00, 0x80})).To(Succeed())
+		Eventually(func() int { return len(svr.Events("bar")) }).Should(Equal(1))
+	})
+
+	It("returns the write error to every send", func() {
+		// the writes now fail
+		Expect(factory.last.SetWriteDeadline(time.Now())).To(Succeed())
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+			}()
+		}
+
+		wg.Wait()
+	})
+
+	When("Linger is zero", func() {
+		BeforeEach(func() {
+			opts.Coalesce.Linger = 0
+		})
+
+		It("writes as soon as no more messages are queued", func() {
+			start := time.Now()
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", 20*time.Millisecond))
+
+			sendConcurrently(20)
+			Eventually(func() int { return len(svr.Events("foo")) }).Should(Equal(21))
+		})
+	})
+
+	When("acks are required", func() {
+		BeforeEach(func() {
+			opts.RequireAck = true
+			opts.ConnectionTimeout = time.Second
+		})
+
+		It("waits for every ack", func() {
+			sendConcurrently(5)
+			Expect(svr.Events("foo")).To(HaveLen(5))
+		})
+	})
+})
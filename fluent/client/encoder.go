@@ -0,0 +1,224 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// maxPooledBuffer is the largest buffer an encoder keeps when it is put
+// back in the pool, so that a single large message does not pin memory.
+const maxPooledBuffer = 1 << 20
+
+// encoder holds the messages and buffers that a send reuses to build and
+// encode its message, so that the send does not allocate once they have
+// grown to the size of the messages.
+type encoder struct {
+	buf bytes.Buffer
+	w   *msgp.Writer
+
+	message    protocol.Message
+	messageExt protocol.MessageExt
+	forward    protocol.ForwardMessage
+	packed     protocol.PackedForwardMessage
+	options    protocol.MessageOptions
+	size       int
+	stream     []byte
+	gzip       protocol.GzipCompressor
+}
+
+// encoderPool is a pool of encoders. The zero value is ready to use.
+type encoderPool struct {
+	pool sync.Pool
+}
+
+func (p *encoderPool) get() *encoder {
+	if enc, ok := p.pool.Get().(*encoder); ok {
+		return enc
+	}
+
+	return new(encoder)
+}
+
+func (p *encoderPool) put(enc *encoder) {
+	if enc.buf.Cap() > maxPooledBuffer || cap(enc.stream) > maxPooledBuffer ||
+		(enc.gzip.Buffer != nil && enc.gzip.Buffer.Cap() > maxPooledBuffer) {
+		return
+	}
+
+	// drop the references to the records of the caller
+	enc.message = protocol.Message{}
+	enc.messageExt = protocol.MessageExt{}
+	enc.forward = protocol.ForwardMessage{}
+	enc.packed = protocol.PackedForwardMessage{}
+	enc.options = protocol.MessageOptions{}
+
+	p.pool.Put(enc)
+}
+
+// encode encodes e into the buffer of enc and returns the buffer, which
+// is valid until enc is reused.
+func (enc *encoder) encode(e msgp.Encodable) ([]byte, error) {
+	enc.buf.Reset()
+
+	if enc.w == nil {
+		enc.w = msgp.NewWriter(&enc.buf)
+	} else {
+		enc.w.Reset(&enc.buf)
+	}
+
+	if err := e.EncodeMsg(enc.w); err != nil {
+		return nil, err
+	}
+
+	if err := enc.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return enc.buf.Bytes(), nil
+}
+
+// sizeOptions returns options with Size set to n.
+func (enc *encoder) sizeOptions(n int) *protocol.MessageOptions {
+	enc.size = n
+	enc.options = protocol.MessageOptions{Size: &enc.size}
+
+	return &enc.options
+}
+
+// compress returns bits compressed with gzip.
+func (enc *encoder) compress(bits []byte) ([]byte, error) {
+	enc.gzip.Reset()
+
+	if err := enc.gzip.Write(bits); err != nil {
+		return nil, err
+	}
+
+	return enc.gzip.Bytes(), nil
+}
+
+// The following methods build the same messages as the protocol
+// constructors, into the messages of enc. Options are left nil where the
+// constructors leave them nil, and allocated by the chunk ID if required.
+
+func (enc *encoder) newMessage(tag string, record interface{}) *protocol.Message {
+	msg := &enc.message
+	msg.Tag = tag
+	msg.Timestamp = time.Now().UTC().Unix()
+	msg.Record = record
+	msg.Options = nil
+
+	return msg
+}
+
+func (enc *encoder) newMessageExt(tag string, record interface{}) *protocol.MessageExt {
+	msg := &enc.messageExt
+	msg.Tag = tag
+	msg.Timestamp = protocol.EventTimeNow()
+	msg.Record = record
+	msg.Options = nil
+
+	return msg
+}
+
+func (enc *encoder) newForwardMessage(tag string, entries protocol.EntryList) *protocol.ForwardMessage {
+	msg := &enc.forward
+	msg.Tag = tag
+	msg.Entries = entries
+	msg.Options = enc.sizeOptions(len(entries))
+
+	return msg
+}
+
+func (enc *encoder) newPackedForwardMessage(
+	tag string, entries protocol.EntryList,
+) (*protocol.PackedForwardMessage, error) {
+	stream, err := entries.AppendPacked(enc.stream[:0])
+	if err != nil {
+		return nil, err
+	}
+
+	enc.stream = stream
+
+	msg := &enc.packed
+	msg.Tag = tag
+	msg.EventStream = stream
+	msg.Options = enc.sizeOptions(len(entries))
+
+	return msg, nil
+}
+
+func (enc *encoder) newPackedForwardMessageFromBytes(
+	tag string, entries []byte,
+) *protocol.PackedForwardMessage {
+	msg := &enc.packed
+	msg.Tag = tag
+	msg.EventStream = entries
+	msg.Options = nil
+
+	return msg
+}
+
+func (enc *encoder) newCompressedPackedForwardMessage(
+	tag string, entries protocol.EntryList,
+) (*protocol.PackedForwardMessage, error) {
+	stream, err := entries.AppendPacked(enc.stream[:0])
+	if err != nil {
+		return nil, err
+	}
+
+	enc.stream = stream
+
+	msg, err := enc.newCompressedPackedForwardMessageFromBytes(tag, stream)
+	if err == nil {
+		enc.size = len(entries)
+		msg.Options.Size = &enc.size
+	}
+
+	return msg, err
+}
+
+func (enc *encoder) newCompressedPackedForwardMessageFromBytes(
+	tag string, entries []byte,
+) (*protocol.PackedForwardMessage, error) {
+	bits, err := enc.compress(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	enc.options = protocol.MessageOptions{Compressed: "gzip"}
+
+	msg := &enc.packed
+	msg.Tag = tag
+	msg.EventStream = bits
+	msg.Options = &enc.options
+
+	return msg, nil
+}
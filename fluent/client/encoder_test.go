@@ -0,0 +1,193 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"time"
+
+	. "github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pooled encoders", func() {
+	var (
+		svr    *fluenttest.Server
+		record = map[string]interface{}{
+			"first":     "Sir",
+			"last":      "Gawain",
+			"age":       32,
+			"height":    1.85,
+			"knighted":  true,
+			"sigil":     []byte{0x70, 0x65, 0x6e},
+			"equipment": []interface{}{"sword", "lance", map[string]interface{}{"shield": "pentangle"}},
+			"horse":     map[string]string{"name": "Gringolet"},
+			// encoded through reflection
+			"victories": map[string]int{"green knight": 1},
+		}
+		entries = protocol.EntryList{
+			{Timestamp: protocol.EventTimeNow(), Record: record},
+			{Timestamp: protocol.EventTimeNow(), Record: map[string]interface{}{"first": "Sir"}},
+		}
+	)
+
+	// normalize clears what differs between two sends of the same message:
+	// the time of Message modes and the chunk.
+	normalize := func(dm protocol.DecodedMessage) protocol.DecodedMessage {
+		dm.Entries = append(protocol.EntryList(nil), dm.Entries...)
+
+		if dm.Mode == protocol.ModeMessage {
+			for i := range dm.Entries {
+				dm.Entries[i].Timestamp = protocol.EventTime{}
+			}
+		}
+
+		if dm.Options != nil {
+			opts := *dm.Options
+			opts.Chunk = ""
+
+			if opts == (protocol.MessageOptions{}) {
+				dm.Options = nil
+			} else {
+				dm.Options = &opts
+			}
+		}
+
+		return dm
+	}
+
+	decode := func(e protocol.ChunkEncoder) protocol.DecodedMessage {
+		bits, err := protocol.Encode(e)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+		var dm protocol.DecodedMessage
+		_, err = dm.UnmarshalMsg(bits)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+		return dm
+	}
+
+	DescribeTable("build the same messages as the protocol constructors",
+		func(requireAck bool) {
+			svr = fluenttest.NewServer(fluenttest.Options{})
+			defer svr.Close()
+
+			c := New(ConnectionOptions{
+				Factory:           svr.PipeFactory(),
+				RequireAck:        requireAck,
+				ConnectionTimeout: time.Second,
+			})
+			Expect(c.Connect()).To(Succeed())
+			defer func() {
+				Expect(c.Disconnect()).To(Succeed())
+			}()
+
+			stream, err := entries.MarshalPacked()
+			Expect(err).ToNot(HaveOccurred())
+
+			packed, err := protocol.NewPackedForwardMessage("foo", entries)
+			Expect(err).ToNot(HaveOccurred())
+
+			compressed, err := protocol.NewCompressedPackedForwardMessage("foo", entries)
+			Expect(err).ToNot(HaveOccurred())
+
+			compressedFromBytes, err := protocol.NewCompressedPackedForwardMessageFromBytes("foo", stream)
+			Expect(err).ToNot(HaveOccurred())
+
+			expected := []protocol.ChunkEncoder{
+				protocol.NewMessage("foo", record),
+				protocol.NewMessageExt("foo", record),
+				protocol.NewForwardMessage("foo", entries),
+				packed,
+				protocol.NewPackedForwardMessageFromBytes("foo", stream),
+				compressed,
+				compressedFromBytes,
+			}
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(c.SendMessageExt("foo", record)).To(Succeed())
+			Expect(c.SendForward("foo", entries)).To(Succeed())
+			Expect(c.SendPacked("foo", entries)).To(Succeed())
+			Expect(c.SendPackedFromBytes("foo", stream)).To(Succeed())
+			Expect(c.SendCompressed("foo", entries)).To(Succeed())
+			Expect(c.SendCompressedFromBytes("foo", stream)).To(Succeed())
+
+			Eventually(svr.Messages).Should(HaveLen(len(expected)))
+
+			for i, msg := range svr.Messages() {
+				Expect(normalize(msg.DecodedMessage)).To(Equal(normalize(decode(expected[i]))), "message %d", i)
+
+				if requireAck {
+					Expect(msg.Options).ToNot(BeNil())
+					Expect(msg.Options.Chunk).ToNot(BeEmpty())
+				}
+			}
+		},
+		Entry("without acks", false),
+		Entry("with acks", true),
+	)
+
+	It("generate the same content hash chunk IDs as the protocol constructors", func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+		defer svr.Close()
+
+		gen := protocol.ContentHashChunkIDGenerator{}
+		c := New(ConnectionOptions{
+			Factory:           svr.PipeFactory(),
+			RequireAck:        true,
+			ChunkIDGenerator:  gen,
+			ConnectionTimeout: time.Second,
+		})
+		Expect(c.Connect()).To(Succeed())
+		defer func() {
+			Expect(c.Disconnect()).To(Succeed())
+		}()
+
+		stream, err := entries.MarshalPacked()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+		Expect(c.SendMessageExt("foo", record)).To(Succeed())
+		Expect(c.SendPackedFromBytes("foo", stream)).To(Succeed())
+
+		Eventually(svr.Messages).Should(HaveLen(3))
+		msgs := svr.Messages()
+
+		// the messages are rebuilt with the timestamps that were sent
+		expected := []protocol.ChunkEncoder{
+			&protocol.Message{Tag: "foo", Timestamp: msgs[0].Entries[0].Timestamp.Unix(), Record: record},
+			&protocol.MessageExt{Tag: "foo", Timestamp: msgs[1].Entries[0].Timestamp, Record: record},
+			protocol.NewPackedForwardMessageFromBytes("foo", stream),
+		}
+
+		for i, msg := range msgs {
+			chunk, err := protocol.ChunkWith(expected[i], gen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Options.Chunk).To(Equal(chunk), "message %d", i)
+		}
+	})
+})
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -15,3 +16,28 @@ func (e *HTTPError) Error() string {
 func NewHTTPError(statusCode int, message string) *HTTPError {
 	return &HTTPError{StatusCode: statusCode, Message: message}
 }
+
+// IsAny reports whether any of errs matches target. It is meant for the Is
+// method of errors that hold the errors of several destinations, since
+// errors.Is only follows Unwrap() []error from Go 1.20.
+func IsAny(target error, errs ...error) bool {
+	for _, err := range errs {
+		if err != nil && errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AsAny finds the first of errs that matches target. It is meant for the
+// As method of the same errors as IsAny.
+func AsAny(target interface{}, errs ...error) bool {
+	for _, err := range errs {
+		if err != nil && errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package fanout provides a client.MessageClient that sends every message
+// to several destinations.
+package fanout
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+// Policy determines when an operation on the destinations succeeds.
+type Policy int
+
+const (
+	// RequireAll succeeds only if every destination succeeds.
+	RequireAll Policy = iota
+	// RequireAny succeeds if at least one destination succeeds.
+	RequireAny
+	// BestEffort always succeeds. Failures are only reported to
+	// Options.OnError.
+	BestEffort
+)
+
+// Error holds the errors of the destinations that failed, indexed like
+// Options.Destinations. The entries of destinations that succeeded are nil.
+type Error struct {
+	Errs []error
+}
+
+func (e *Error) Error() string {
+	var (
+		sb     strings.Builder
+		failed int
+	)
+
+	for i, err := range e.Errs {
+		if err == nil {
+			continue
+		}
+
+		if failed > 0 {
+			sb.WriteString("; ")
+		}
+
+		failed++
+
+		fmt.Fprintf(&sb, "[%d] %v", i, err)
+	}
+
+	return fmt.Sprintf("%d of %d destinations failed: %s", failed, len(e.Errs), sb.String())
+}
+
+// Is reports whether the error of any destination matches target.
+func (e *Error) Is(target error) bool {
+	return client.IsAny(target, e.Errs...)
+}
+
+// As finds the first error of the destinations that matches target.
+func (e *Error) As(target interface{}) bool {
+	return client.AsAny(target, e.Errs...)
+}
+
+// Options configures a Client.
+type Options struct {
+	Destinations []client.MessageClient
+	Policy       Policy
+	// RequireAck must be set if any destination requires acks, since such
+	// a destination fails every message that has no chunk ID. The ID is
+	// given once, before the message is encoded, so that every destination
+	// waits for the same chunk.
+	RequireAck bool
+	// ChunkIDGenerator generates the chunk ID shared by the destinations.
+	// protocol.DefaultChunkIDGenerator is used if nil.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	// OnError, if set, is called with the index and error of every
+	// destination that fails, whatever the Policy.
+	OnError func(destination int, err error)
+}
+
+// Client is a client.MessageClient that encodes each message once and
+// sends the same bytes to every destination concurrently.
+type Client struct {
+	client.SendFunc
+	destinations []client.MessageClient
+	policy       Policy
+	requireAck   bool
+	chunkIDs     protocol.ChunkIDGenerator
+	onError      func(int, error)
+}
+
+var _ client.MessageClient = (*Client)(nil)
+
+// New returns a Client for the destinations in opts. The destinations
+// are owned by the Client; Connect, Disconnect, and Reconnect apply to all.
+func New(opts Options) *Client {
+	c := &Client{
+		destinations: opts.Destinations,
+		policy:       opts.Policy,
+		requireAck:   opts.RequireAck,
+		chunkIDs:     opts.ChunkIDGenerator,
+		onError:      opts.OnError,
+	}
+
+	c.SendFunc = c.Send
+
+	return c
+}
+
+// each calls fn for every destination concurrently and applies the policy
+// to the results.
+func (c *Client) each(fn func(mc client.MessageClient) error) error {
+	var (
+		errs = make([]error, len(c.destinations))
+		wg   sync.WaitGroup
+	)
+
+	for i, mc := range c.destinations {
+		wg.Add(1)
+
+		go func(i int, mc client.MessageClient) {
+			defer wg.Done()
+
+			errs[i] = fn(mc)
+		}(i, mc)
+	}
+
+	wg.Wait()
+
+	failed := 0
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failed++
+
+		if c.onError != nil {
+			c.onError(i, err)
+		}
+	}
+
+	switch {
+	case failed == 0, c.policy == BestEffort:
+		return nil
+	case c.policy == RequireAny && failed < len(errs):
+		return nil
+	}
+
+	return &Error{Errs: errs}
+}
+
+func (c *Client) Connect() error {
+	return c.each(func(mc client.MessageClient) error {
+		return mc.Connect()
+	})
+}
+
+func (c *Client) Disconnect() error {
+	return c.each(func(mc client.MessageClient) error {
+		return mc.Disconnect()
+	})
+}
+
+func (c *Client) Reconnect() error {
+	return c.each(func(mc client.MessageClient) error {
+		return mc.Reconnect()
+	})
+}
+
+// Send encodes e once and sends it to every destination.
+func (c *Client) Send(e protocol.ChunkEncoder) error {
+	bits, err := protocol.EncodeAcked(e, c.requireAck, c.chunkIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.each(func(mc client.MessageClient) error {
+		return mc.Send(protocol.RawMessage(bits))
+	})
+}
+
+func (c *Client) SendRaw(raw []byte) error {
+	return c.each(func(mc client.MessageClient) error {
+		return mc.SendRaw(raw)
+	})
+}
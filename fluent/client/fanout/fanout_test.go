@@ -0,0 +1,213 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package fanout_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/fanout"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	record := map[string]interface{}{"first": "Sir", "last": "Gawain"}
+
+	Context("with real destinations", func() {
+		var (
+			servers []*fluenttest.Server
+			c       *fanout.Client
+		)
+
+		BeforeEach(func() {
+			servers = []*fluenttest.Server{
+				fluenttest.NewServer(fluenttest.Options{}),
+				fluenttest.NewServer(fluenttest.Options{}),
+			}
+
+			var dests []client.MessageClient
+			for _, svr := range servers {
+				dests = append(dests, client.New(client.ConnectionOptions{
+					Factory:           svr.ConnFactory(),
+					RequireAck:        true,
+					ConnectionTimeout: time.Second,
+				}))
+			}
+
+			c = fanout.New(fanout.Options{
+				Destinations:     dests,
+				RequireAck:       true,
+				ChunkIDGenerator: &protocol.SequentialChunkIDGenerator{Prefix: "fanout-"},
+			})
+
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(c.Disconnect()).To(Succeed())
+
+			for _, svr := range servers {
+				svr.Close()
+			}
+		})
+
+		It("sends the same bytes to every destination", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(c.SendPacked("bar", protocol.EntryList{
+				{Timestamp: protocol.EventTimeNow(), Record: record},
+			})).To(Succeed())
+
+			first, second := servers[0].Messages(), servers[1].Messages()
+			Expect(first).To(HaveLen(2))
+			Expect(second).To(HaveLen(2))
+
+			for i := range first {
+				Expect(first[i].Raw).To(Equal(second[i].Raw))
+				Expect(first[i].Options.Chunk).ToNot(BeEmpty())
+			}
+		})
+
+		It("gives messages chunk IDs from the ChunkIDGenerator", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			for _, svr := range servers {
+				Expect(svr.Messages()[0].Options.Chunk).To(Equal("fanout-1"))
+			}
+		})
+	})
+
+	Context("with failing destinations", func() {
+		var (
+			dests  []*clientfakes.FakeMessageClient
+			lock   sync.Mutex
+			failed map[int]error
+			opts   fanout.Options
+			errOne = errors.New("one")
+			errTwo = errors.New("two")
+		)
+
+		BeforeEach(func() {
+			dests = []*clientfakes.FakeMessageClient{{}, {}, {}}
+			failed = map[int]error{}
+
+			opts = fanout.Options{
+				OnError: func(i int, err error) {
+					lock.Lock()
+					defer lock.Unlock()
+
+					failed[i] = err
+				},
+			}
+
+			for _, d := range dests {
+				opts.Destinations = append(opts.Destinations, d)
+			}
+		})
+
+		send := func() error {
+			return fanout.New(opts).SendMessage("foo", record)
+		}
+
+		When("one destination fails", func() {
+			BeforeEach(func() {
+				dests[1].SendReturns(errOne)
+			})
+
+			It("fails with RequireAll", func() {
+				opts.Policy = fanout.RequireAll
+
+				err := send()
+				Expect(err).To(HaveOccurred())
+
+				var fe *fanout.Error
+				Expect(errors.As(err, &fe)).To(BeTrue())
+				Expect(fe.Errs).To(Equal([]error{nil, errOne, nil}))
+				Expect(errors.Is(err, errOne)).To(BeTrue())
+				Expect(errors.Is(err, errTwo)).To(BeFalse())
+				Expect(err.Error()).To(Equal("1 of 3 destinations failed: [1] one"))
+
+				Expect(failed).To(Equal(map[int]error{1: errOne}))
+			})
+
+			It("succeeds with RequireAny", func() {
+				opts.Policy = fanout.RequireAny
+				Expect(send()).To(Succeed())
+				Expect(failed).To(HaveKey(1))
+			})
+
+			It("sends to every destination", func() {
+				_ = send()
+
+				for _, d := range dests {
+					Expect(d.SendCallCount()).To(Equal(1))
+				}
+			})
+		})
+
+		When("every destination fails", func() {
+			BeforeEach(func() {
+				dests[0].SendReturns(errOne)
+				dests[1].SendReturns(errTwo)
+				dests[2].SendReturns(errTwo)
+			})
+
+			It("fails with RequireAny", func() {
+				opts.Policy = fanout.RequireAny
+				Expect(send()).To(MatchError(ContainSubstring("3 of 3 destinations failed")))
+			})
+
+			It("succeeds with BestEffort and reports every failure", func() {
+				opts.Policy = fanout.BestEffort
+				Expect(send()).To(Succeed())
+				Expect(failed).To(HaveLen(3))
+			})
+		})
+
+		It("connects every destination", func() {
+			dests[2].ConnectReturns(errTwo)
+
+			opts.Policy = fanout.RequireAll
+			Expect(fanout.New(opts).Connect()).ToNot(Succeed())
+
+			for _, d := range dests {
+				Expect(d.ConnectCallCount()).To(Equal(1))
+			}
+		})
+
+		It("does not assign a chunk unless acks are required", func() {
+			Expect(send()).To(Succeed())
+
+			raw := dests[0].SendArgsForCall(0).(protocol.RawMessage)
+			_, err := raw.Chunk()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,218 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// ConnFactory wraps a client.ConnectionFactory and injects faults into
+// the connections it creates.
+type ConnFactory struct {
+	client.ConnectionFactory
+	opts  Options
+	sched *schedule
+}
+
+// NewConnFactory returns a ConnFactory that dials with factory.
+func NewConnFactory(factory client.ConnectionFactory, opts Options) *ConnFactory {
+	return &ConnFactory{
+		ConnectionFactory: factory,
+		opts:              opts,
+		sched:             newSchedule(opts.Seed),
+	}
+}
+
+func (f *ConnFactory) New() (net.Conn, error) {
+	if f.opts.Latency > 0 {
+		time.Sleep(f.opts.Latency)
+	}
+
+	if f.sched.hit(f.opts.DialFailureRate) {
+		return nil, fmt.Errorf("%w: dial failed", ErrInjected)
+	}
+
+	seed := f.sched.seed()
+
+	nc, err := f.ConnectionFactory.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		Conn: nc,
+		f:    newFaulter(f.opts, seed),
+		buf:  make([]byte, 4096),
+	}, nil
+}
+
+// segment is data read from the peer that may not be returned before at.
+type segment struct {
+	data []byte
+	at   time.Time
+}
+
+type conn struct {
+	net.Conn
+	f *faulter
+
+	lock         sync.Mutex
+	readDeadline time.Time
+
+	// buf receives reads from Conn, inbuf holds an incomplete msgpack
+	// object, and segments hold complete data ready to be returned.
+	buf      []byte
+	inbuf    []byte
+	segments []segment
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.f.sleep()
+
+	action, at := c.f.planWrite(len(p))
+
+	switch action {
+	case writeReset, writeTruncate:
+		n, _ := c.Conn.Write(p[:at])
+		_ = c.Conn.Close()
+
+		return n, writeError(action)
+	case writeSplit:
+		n, err := c.Conn.Write(p[:at])
+		if err != nil {
+			return n, err
+		}
+
+		c.f.sleep()
+
+		m, err := c.Conn.Write(p[at:])
+
+		return n + m, err
+	}
+
+	return c.Conn.Write(p)
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.setReadDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.setReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *conn) setReadDeadline(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.readDeadline = t
+}
+
+// Read returns data from the peer, dropping and delaying acks. A delayed
+// ack that is not due before the read deadline causes a timeout, and is
+// returned by a later Read.
+func (c *conn) Read(p []byte) (int, error) {
+	for len(c.segments) == 0 {
+		if err := c.fill(); err != nil && len(c.segments) == 0 {
+			return 0, err
+		}
+	}
+
+	seg := &c.segments[0]
+
+	if wait := time.Until(seg.at); wait > 0 {
+		c.lock.Lock()
+		deadline := c.readDeadline
+		c.lock.Unlock()
+
+		if !deadline.IsZero() && deadline.Before(seg.at) {
+			time.Sleep(time.Until(deadline))
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		time.Sleep(wait)
+	}
+
+	n := copy(p, seg.data)
+	seg.data = seg.data[n:]
+
+	if len(seg.data) == 0 {
+		c.segments = c.segments[1:]
+	}
+
+	return n, nil
+}
+
+// fill reads from Conn and splits the data into msgpack objects, so that
+// acks can be dropped or delayed as a whole.
+func (c *conn) fill() error {
+	n, readErr := c.Conn.Read(c.buf)
+	c.inbuf = append(c.inbuf, c.buf[:n]...)
+
+	for len(c.inbuf) > 0 {
+		rest, err := msgp.Skip(c.inbuf)
+		if errors.Is(err, msgp.ErrShortBytes) {
+			break
+		}
+
+		if err != nil {
+			// Not msgpack; pass it through untouched.
+			c.segments = append(c.segments, segment{data: c.inbuf})
+			c.inbuf = nil
+
+			break
+		}
+
+		obj := c.inbuf[:len(c.inbuf)-len(rest)]
+		c.inbuf = rest
+
+		var at time.Time
+
+		if isAck(obj) {
+			drop, delay := c.f.ackAction()
+			if drop {
+				continue
+			}
+
+			if delay > 0 {
+				at = time.Now().Add(delay)
+			}
+		}
+
+		c.segments = append(c.segments, segment{data: obj, at: at})
+	}
+
+	return readErr
+}
@@ -0,0 +1,199 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package faults wraps client connection factories to inject network
+// faults, for testing how code behaves against a flaky peer.
+//
+// Every fault is drawn from a pseudo-random schedule seeded by
+// Options.Seed. Each new connection takes its own seed from the factory's
+// schedule, so the same sequence of dials, writes, and reads injects the
+// same faults on every run.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// ErrInjected is wrapped by every error caused by an injected fault.
+var ErrInjected = errors.New("injected fault")
+
+// Options configures the faults to inject. Rates are probabilities between
+// 0 and 1, drawn independently for each dial, write, or ack.
+type Options struct {
+	// Seed seeds the schedule of faults.
+	Seed int64
+	// DialFailureRate is the rate at which dials fail.
+	DialFailureRate float64
+	// Latency is added before every dial and write, and between the two
+	// halves of a split write.
+	Latency time.Duration
+	// TruncateRate is the rate at which writes are cut short. The peer
+	// receives a random prefix of the data, after which the connection is
+	// closed.
+	TruncateRate float64
+	// SplitRate is the rate at which writes are split in two at a random
+	// offset. The data is delivered intact.
+	SplitRate float64
+	// DropAckRate is the rate at which acks from the peer are discarded.
+	DropAckRate float64
+	// AckDelay delays every ack from the peer that is not dropped.
+	AckDelay time.Duration
+	// ResetAfterBytes closes a connection once it has written this many
+	// bytes. The write that crosses the limit is cut short. Zero disables
+	// resets.
+	ResetAfterBytes int
+}
+
+// schedule is a seeded source of faults shared by everything that draws
+// from it.
+type schedule struct {
+	lock sync.Mutex
+	rnd  *rand.Rand
+}
+
+func newSchedule(seed int64) *schedule {
+	return &schedule{
+		rnd: rand.New(rand.NewSource(seed)), //nolint:gosec // reproducibility, not security
+	}
+}
+
+func (s *schedule) hit(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.rnd.Float64() < rate
+}
+
+// offset returns a random offset in [1, n).
+func (s *schedule) offset(n int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return 1 + s.rnd.Intn(n-1)
+}
+
+func (s *schedule) seed() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.rnd.Int63()
+}
+
+type writeAction int
+
+const (
+	writeAll writeAction = iota
+	writeSplit
+	writeTruncate
+	writeReset
+)
+
+// faulter holds the per-connection state.
+type faulter struct {
+	opts    Options
+	sched   *schedule
+	lock    sync.Mutex
+	written int
+}
+
+func newFaulter(opts Options, seed int64) *faulter {
+	return &faulter{
+		opts:  opts,
+		sched: newSchedule(seed),
+	}
+}
+
+func (f *faulter) sleep() {
+	if f.opts.Latency > 0 {
+		time.Sleep(f.opts.Latency)
+	}
+}
+
+// planWrite decides what happens to a write of n bytes. It returns the
+// action and, except for writeAll, the offset at which to cut or split.
+func (f *faulter) planWrite(n int) (writeAction, int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.opts.ResetAfterBytes > 0 && f.written+n > f.opts.ResetAfterBytes {
+		at := f.opts.ResetAfterBytes - f.written
+		f.written = f.opts.ResetAfterBytes
+
+		return writeReset, at
+	}
+
+	if n > 1 && f.sched.hit(f.opts.TruncateRate) {
+		at := f.sched.offset(n)
+		f.written += at
+
+		return writeTruncate, at
+	}
+
+	f.written += n
+
+	if n > 1 && f.sched.hit(f.opts.SplitRate) {
+		return writeSplit, f.sched.offset(n)
+	}
+
+	return writeAll, n
+}
+
+func writeError(action writeAction) error {
+	if action == writeReset {
+		return fmt.Errorf("%w: connection reset", ErrInjected)
+	}
+
+	return fmt.Errorf("%w: write truncated", ErrInjected)
+}
+
+// ackAction decides whether an ack is dropped and how long it is delayed.
+func (f *faulter) ackAction() (drop bool, delay time.Duration) {
+	if f.sched.hit(f.opts.DropAckRate) {
+		return true, 0
+	}
+
+	return false, f.opts.AckDelay
+}
+
+// isAck reports whether b holds a msgpack-encoded protocol.AckMessage.
+func isAck(b []byte) bool {
+	sz, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil || sz != 1 {
+		return false
+	}
+
+	key, _, err := msgp.ReadMapKeyZC(b)
+
+	return err == nil && string(key) == "ack"
+}
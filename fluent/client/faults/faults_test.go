@@ -0,0 +1,277 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package faults_test
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/faults"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConnFactory", func() {
+	var (
+		opts   faults.Options
+		svr    *fluenttest.Server
+		c      *client.Client
+		record map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		opts = faults.Options{Seed: 42}
+		record = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	})
+
+	JustBeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+
+		c = client.New(client.ConnectionOptions{
+			Factory:           faults.NewConnFactory(svr.ConnFactory(), opts),
+			ConnectionTimeout: 100 * time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		_ = c.Disconnect()
+		svr.Close()
+	})
+
+	It("passes everything through without faults", func() {
+		Expect(c.Connect()).To(Succeed())
+
+		c.RequireAck = true
+		Expect(c.SendMessageExt("foo", record)).To(Succeed())
+		Expect(svr.Events("foo")).To(HaveLen(1))
+	})
+
+	It("follows a reproducible schedule", func() {
+		dials := func(seed int64) []bool {
+			fake := &clientfakes.FakeConnectionFactory{}
+			fake.NewStub = func() (net.Conn, error) {
+				conn, _ := net.Pipe()
+				return conn, nil
+			}
+
+			f := faults.NewConnFactory(fake, faults.Options{Seed: seed, DialFailureRate: 0.5})
+
+			var results []bool
+			for i := 0; i < 32; i++ {
+				conn, err := f.New()
+				results = append(results, err == nil)
+
+				if conn != nil {
+					conn.Close()
+				}
+			}
+
+			return results
+		}
+
+		first := dials(7)
+		Expect(first).To(ContainElement(true))
+		Expect(first).To(ContainElement(false))
+		Expect(dials(7)).To(Equal(first))
+		Expect(dials(8)).ToNot(Equal(first))
+	})
+
+	When("dials fail", func() {
+		BeforeEach(func() {
+			opts.DialFailureRate = 1
+		})
+
+		It("returns an injected error", func() {
+			err := c.Connect()
+			Expect(errors.Is(err, faults.ErrInjected)).To(BeTrue())
+		})
+	})
+
+	When("writes are truncated", func() {
+		BeforeEach(func() {
+			opts.TruncateRate = 1
+		})
+
+		It("returns an injected error and closes the connection", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			err := c.SendMessage("foo", record)
+			Expect(errors.Is(err, faults.ErrInjected)).To(BeTrue())
+
+			Consistently(svr.Messages, 50*time.Millisecond).Should(BeEmpty())
+		})
+	})
+
+	When("writes are split", func() {
+		BeforeEach(func() {
+			opts.SplitRate = 1
+			opts.Latency = 5 * time.Millisecond
+		})
+
+		It("delivers the data intact", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			c.RequireAck = true
+			Expect(c.SendMessageExt("foo", record)).To(Succeed())
+			Expect(c.SendMessageExt("foo", record)).To(Succeed())
+			Expect(svr.Events("foo")).To(HaveLen(2))
+		})
+	})
+
+	When("the connection resets after N bytes", func() {
+		BeforeEach(func() {
+			opts.ResetAfterBytes = 100
+		})
+
+		It("fails the write that crosses the limit", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			var err error
+			sent := 0
+
+			for ; sent < 10 && err == nil; sent++ {
+				err = c.SendMessage("foo", record)
+			}
+
+			Expect(errors.Is(err, faults.ErrInjected)).To(BeTrue())
+			Expect(sent).To(BeNumerically(">", 1))
+
+			_, werr := svr.WaitForEvents("foo", sent-1, time.Second)
+			Expect(werr).ToNot(HaveOccurred())
+		})
+	})
+
+	When("acks are dropped", func() {
+		BeforeEach(func() {
+			opts.DropAckRate = 1
+		})
+
+		It("times out waiting for the ack", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			c.RequireAck = true
+			err := c.SendMessageExt("foo", record)
+			Expect(err).To(HaveOccurred())
+
+			var netErr net.Error
+			Expect(errors.As(err, &netErr)).To(BeTrue())
+			Expect(netErr.Timeout()).To(BeTrue())
+			Expect(svr.Events("foo")).To(HaveLen(1))
+		})
+	})
+
+	When("acks are delayed", func() {
+		BeforeEach(func() {
+			opts.AckDelay = 50 * time.Millisecond
+		})
+
+		It("delivers the ack late", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			c.RequireAck = true
+			start := time.Now()
+			Expect(c.SendMessageExt("foo", record)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+
+		It("times out if the delay exceeds the timeout", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			c.RequireAck = true
+			c.Timeout = 10 * time.Millisecond
+			Expect(c.SendMessageExt("foo", record)).ToNot(Succeed())
+		})
+	})
+})
+
+var _ = Describe("WSConnectionFactory", func() {
+	var (
+		opts   faults.Options
+		svr    *fluenttest.Server
+		c      *client.WSClient
+		record map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		opts = faults.Options{Seed: 42}
+		record = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	})
+
+	JustBeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+
+		c = client.NewWS(client.WSConnectionOptions{
+			Factory: faults.NewWSConnectionFactory(svr.WSConnectionFactory(), opts),
+		})
+	})
+
+	AfterEach(func() {
+		_ = c.Disconnect()
+		svr.Close()
+	})
+
+	When("dials fail", func() {
+		BeforeEach(func() {
+			opts.DialFailureRate = 1
+		})
+
+		It("returns an injected error", func() {
+			err := c.Connect()
+			Expect(errors.Is(err, faults.ErrInjected)).To(BeTrue())
+		})
+	})
+
+	When("writes are split", func() {
+		BeforeEach(func() {
+			opts.SplitRate = 1
+		})
+
+		It("delivers the message intact", func() {
+			Expect(c.Connect()).To(Succeed())
+			Expect(c.Send(protocol.NewMessage("foo", record))).To(Succeed())
+
+			_, err := svr.WaitForEvents("foo", 1, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	When("writes are truncated", func() {
+		BeforeEach(func() {
+			opts.TruncateRate = 1
+		})
+
+		It("returns an injected error", func() {
+			Expect(c.Connect()).To(Succeed())
+
+			err := c.Send(protocol.NewMessage("foo", record))
+			Expect(errors.Is(err, faults.ErrInjected)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,140 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package faults
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws/ext"
+)
+
+// WSConnectionFactory wraps a client.WSConnectionFactory and injects
+// faults into the connections it creates. Each websocket message counts
+// as one write, and each received message as one possible ack.
+type WSConnectionFactory struct {
+	client.WSConnectionFactory
+	opts  Options
+	sched *schedule
+}
+
+// NewWSConnectionFactory returns a WSConnectionFactory that dials with
+// factory.
+func NewWSConnectionFactory(factory client.WSConnectionFactory, opts Options) *WSConnectionFactory {
+	return &WSConnectionFactory{
+		WSConnectionFactory: factory,
+		opts:                opts,
+		sched:               newSchedule(opts.Seed),
+	}
+}
+
+func (f *WSConnectionFactory) New() (ext.Conn, error) {
+	if f.opts.Latency > 0 {
+		time.Sleep(f.opts.Latency)
+	}
+
+	if f.sched.hit(f.opts.DialFailureRate) {
+		return nil, fmt.Errorf("%w: dial failed", ErrInjected)
+	}
+
+	seed := f.sched.seed()
+
+	conn, err := f.WSConnectionFactory.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &wsConn{
+		Conn: conn,
+		f:    newFaulter(f.opts, seed),
+	}, nil
+}
+
+type wsConn struct {
+	ext.Conn
+	f *faulter
+}
+
+// WriteMessage writes data as a single message. A truncated message is
+// delivered as a shorter message before the connection is closed. A split
+// message is written in two parts, with Options.Latency in between.
+func (c *wsConn) WriteMessage(messageType int, data []byte) error {
+	c.f.sleep()
+
+	action, at := c.f.planWrite(len(data))
+
+	switch action {
+	case writeReset, writeTruncate:
+		_ = c.Conn.WriteMessage(messageType, data[:at])
+
+		if nc := c.Conn.UnderlyingConn(); nc != nil {
+			_ = nc.Close()
+		}
+
+		return writeError(action)
+	case writeSplit:
+		w, err := c.Conn.NextWriter(messageType)
+		if err != nil {
+			return err
+		}
+
+		if _, err = w.Write(data[:at]); err != nil {
+			return err
+		}
+
+		c.f.sleep()
+
+		if _, err = w.Write(data[at:]); err != nil {
+			return err
+		}
+
+		return w.Close()
+	}
+
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// ReadMessage returns the next message from the peer, dropping and
+// delaying acks.
+func (c *wsConn) ReadMessage() (int, []byte, error) {
+	for {
+		mt, p, err := c.Conn.ReadMessage()
+		if err != nil || !isAck(p) {
+			return mt, p, err
+		}
+
+		drop, delay := c.f.ackAction()
+		if drop {
+			continue
+		}
+
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		return mt, p, nil
+	}
+}
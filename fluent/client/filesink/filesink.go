@@ -0,0 +1,421 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package filesink provides a client.MessageClient that writes events to
+// local files instead of a forward endpoint.
+package filesink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Format is the encoding of the events in a file.
+type Format int
+
+const (
+	// FormatJSON writes one line per event in the default format of the
+	// Fluent Bit file output: the tag, a colon, and a JSON array holding
+	// the event time in seconds and the record.
+	//
+	//	app.access: [1640995200.123456789, {"status":200}]
+	FormatJSON Format = iota
+	// FormatMsgpack writes the events as a msgpack event stream of
+	// [EventTime, record] entries, as in a PackedForward message. Files
+	// can be read back with protocol.UnmarshalEventStream.
+	FormatMsgpack
+)
+
+const (
+	// DefaultPath writes each tag to a file named after it in the working
+	// directory, like the Fluent Bit file output.
+	DefaultPath = "{tag}"
+	// rotatedTimeFormat is appended to the name of a rotated file.
+	rotatedTimeFormat = "20060102T150405.000000000"
+)
+
+var (
+	// ErrInvalidTag is returned for tags that cannot be used in a path,
+	// such as tags containing a path separator.
+	ErrInvalidTag = errors.New("tag cannot be used in a file path")
+
+	tagPattern = regexp.MustCompile(`\{tag(?:\[(\d+)\])?\}`)
+)
+
+// Options configures a Sink.
+type Options struct {
+	// Path is a template for the file of each tag. "{tag}" is replaced by
+	// the tag and "{tag[N]}" by its Nth dot-separated part, counting from
+	// zero. Missing directories are created. Defaults to DefaultPath.
+	Path   string
+	Format Format
+	// MaxSize, if greater than zero, rotates a file before a write would
+	// make it larger than MaxSize bytes. A single message larger than
+	// MaxSize is still written, to an empty file.
+	MaxSize int64
+	// MaxAge, if greater than zero, rotates a file on the first write
+	// after it has been open for MaxAge.
+	MaxAge time.Duration
+	// Compress gzips rotated files, adding a ".gz" suffix.
+	Compress bool
+}
+
+type file struct {
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// Sink is a client.MessageClient that decodes every message it is sent
+// and appends its events to the file of its tag. Files are rotated by
+// renaming them with the time of rotation appended, for example
+// "app.log.20220101T000000.000000000".
+type Sink struct {
+	client.SendFunc
+	path     string
+	format   Format
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+
+	lock  sync.Mutex
+	files map[string]*file
+}
+
+var _ client.MessageClient = (*Sink)(nil)
+
+// New returns a Sink configured by opts. Files are opened on the first
+// write to them.
+func New(opts Options) *Sink {
+	s := &Sink{
+		path:     opts.Path,
+		format:   opts.Format,
+		maxSize:  opts.MaxSize,
+		maxAge:   opts.MaxAge,
+		compress: opts.Compress,
+		files:    map[string]*file{},
+	}
+
+	if len(s.path) == 0 {
+		s.path = DefaultPath
+	}
+
+	s.SendFunc = s.Send
+
+	return s
+}
+
+// Connect is a no-op; files are opened when they are first written.
+func (s *Sink) Connect() error {
+	return nil
+}
+
+// Disconnect closes the open files. A later write reopens them.
+func (s *Sink) Disconnect() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err error
+
+	for path, fl := range s.files {
+		if cerr := fl.f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+
+		delete(s.files, path)
+	}
+
+	return err
+}
+
+// Reconnect closes the open files, so that files moved by an external
+// tool such as logrotate are recreated on the next write.
+func (s *Sink) Reconnect() error {
+	return s.Disconnect()
+}
+
+// Send encodes e and writes its events.
+func (s *Sink) Send(e protocol.ChunkEncoder) error {
+	bits, err := protocol.Encode(e)
+	if err != nil {
+		return err
+	}
+
+	return s.SendRaw(bits)
+}
+
+// SendRaw decodes a message of any mode and writes its events.
+func (s *Sink) SendRaw(raw []byte) error {
+	var dm protocol.DecodedMessage
+	if _, err := dm.UnmarshalMsg(raw); err != nil {
+		return err
+	}
+
+	path, err := s.pathFor(dm.Tag)
+	if err != nil {
+		return err
+	}
+
+	bits, err := s.encode(dm.Tag, dm.Entries)
+	if err != nil {
+		return err
+	}
+
+	return s.write(path, bits)
+}
+
+func (s *Sink) pathFor(tag string) (string, error) {
+	if !validPathElem(tag) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+	}
+
+	var (
+		parts = strings.Split(tag, ".")
+		err   error
+	)
+
+	path := tagPattern.ReplaceAllStringFunc(s.path, func(m string) string {
+		sub := tagPattern.FindStringSubmatch(m)
+		if len(sub[1]) == 0 {
+			return tag
+		}
+
+		i, _ := strconv.Atoi(sub[1])
+		if i >= len(parts) || len(parts[i]) == 0 {
+			err = fmt.Errorf("%w: %q has no part %d", ErrInvalidTag, tag, i)
+			return ""
+		}
+
+		return parts[i]
+	})
+
+	return path, err
+}
+
+func validPathElem(tag string) bool {
+	return len(tag) > 0 && tag != "." && tag != ".." &&
+		!strings.ContainsAny(tag, `/\`+"\x00")
+}
+
+func (s *Sink) encode(tag string, entries protocol.EntryList) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if s.format == FormatMsgpack {
+		for _, entry := range entries {
+			if err := msgp.Encode(&buf, entry); err != nil {
+				return nil, err
+			}
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	for _, entry := range entries {
+		record, err := json.Marshal(jsonValue(entry.Record))
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&buf, "%s: [%d.%09d, %s]\n",
+			tag, entry.Timestamp.Unix(), entry.Timestamp.Nanosecond(), record)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jsonValue converts the byte slices in a decoded msgpack value to
+// strings, which is how Fluent Bit renders them.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = jsonValue(e)
+		}
+
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			a[i] = jsonValue(e)
+		}
+
+		return a
+	}
+
+	return v
+}
+
+func (s *Sink) write(path string, bits []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fl, err := s.open(path)
+	if err != nil {
+		return err
+	}
+
+	if s.rotationDue(fl, len(bits)) {
+		if err = s.rotate(path, fl); err != nil {
+			return err
+		}
+
+		if fl, err = s.open(path); err != nil {
+			return err
+		}
+	}
+
+	n, err := fl.f.Write(bits)
+	fl.size += int64(n)
+
+	return err
+}
+
+func (s *Sink) open(path string) (*file, error) {
+	if fl, ok := s.files[path]; ok {
+		return fl, nil
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fl := &file{
+		f:        f,
+		size:     info.Size(),
+		openedAt: time.Now(),
+	}
+
+	s.files[path] = fl
+
+	return fl, nil
+}
+
+func (s *Sink) rotationDue(fl *file, n int) bool {
+	if fl.size == 0 {
+		return false
+	}
+
+	if s.maxSize > 0 && fl.size+int64(n) > s.maxSize {
+		return true
+	}
+
+	return s.maxAge > 0 && time.Since(fl.openedAt) >= s.maxAge
+}
+
+func (s *Sink) rotate(path string, fl *file) error {
+	delete(s.files, path)
+
+	if err := fl.f.Close(); err != nil {
+		return err
+	}
+
+	base := path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	rotated := base
+
+	// clocks with a coarse resolution can repeat a timestamp
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%d", base, i)
+	}
+
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+
+	if s.compress {
+		return gzipFile(rotated)
+	}
+
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+
+	return err == nil
+}
+
+// gzipFile replaces path with a gzipped copy named path + ".gz".
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		in.Close()
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	in.Close()
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,13 @@
+package filesink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFilesink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Filesink Suite")
+}
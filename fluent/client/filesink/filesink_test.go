@@ -0,0 +1,230 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package filesink_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client/filesink"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sink", func() {
+	var (
+		dir  string
+		opts filesink.Options
+		s    *filesink.Sink
+		ts   = protocol.EventTime{Time: time.Unix(1640995200, 123456789)}
+	)
+
+	entries := func(n int) protocol.EntryList {
+		el := make(protocol.EntryList, n)
+		for i := range el {
+			el[i] = protocol.EntryExt{
+				Timestamp: ts,
+				Record:    map[string]interface{}{"n": i},
+			}
+		}
+
+		return el
+	}
+
+	readFile := func(path string) string {
+		bits, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(bits)
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		opts = filesink.Options{
+			Path: filepath.Join(dir, "{tag}.log"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		s = filesink.New(opts)
+		Expect(s.Connect()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(s.Disconnect()).To(Succeed())
+	})
+
+	It("writes lines in the format of the Fluent Bit file output", func() {
+		Expect(s.SendForward("app.access", entries(2))).To(Succeed())
+		Expect(s.SendMessage("app.access", map[string]interface{}{
+			"first": "Sir",
+			"bin":   []byte("Gawain"),
+		})).To(Succeed())
+
+		lines := strings.Split(readFile(filepath.Join(dir, "app.access.log")), "\n")
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0]).To(Equal(`app.access: [1640995200.123456789, {"n":0}]`))
+		Expect(lines[1]).To(Equal(`app.access: [1640995200.123456789, {"n":1}]`))
+		Expect(lines[2]).To(MatchRegexp(`^app\.access: \[\d+\.\d{9}, {"bin":"Gawain","first":"Sir"}\]$`))
+		Expect(lines[3]).To(BeEmpty())
+	})
+
+	It("decodes raw messages of every mode", func() {
+		msg, err := protocol.NewCompressedPackedForwardMessage("foo", entries(3))
+		Expect(err).ToNot(HaveOccurred())
+
+		bits, err := msg.MarshalMsg(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(s.SendRaw(bits)).To(Succeed())
+		Expect(strings.Count(readFile(filepath.Join(dir, "foo.log")), "\n")).To(Equal(3))
+	})
+
+	When("the format is msgpack", func() {
+		BeforeEach(func() {
+			opts.Format = filesink.FormatMsgpack
+		})
+
+		It("writes an event stream", func() {
+			Expect(s.SendPacked("foo", entries(2))).To(Succeed())
+			Expect(s.SendPacked("foo", entries(1))).To(Succeed())
+
+			el, err := protocol.UnmarshalEventStream([]byte(readFile(filepath.Join(dir, "foo.log"))))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(el).To(HaveLen(3))
+			Expect(el[0].Timestamp.Equal(ts.Time)).To(BeTrue())
+			Expect(el[1].Record).To(HaveKeyWithValue("n", BeEquivalentTo(1)))
+		})
+	})
+
+	When("the path uses tag parts", func() {
+		BeforeEach(func() {
+			opts.Path = filepath.Join(dir, "{tag[0]}", "{tag[1]}.log")
+		})
+
+		It("creates the directories", func() {
+			Expect(s.SendMessage("app.access", map[string]interface{}{})).To(Succeed())
+			Expect(filepath.Join(dir, "app", "access.log")).To(BeARegularFile())
+		})
+
+		It("rejects tags without the part", func() {
+			err := s.SendMessage("app", map[string]interface{}{})
+			Expect(err).To(MatchError(filesink.ErrInvalidTag))
+		})
+	})
+
+	It("rejects tags containing path separators", func() {
+		err := s.SendMessage("../etc", map[string]interface{}{})
+		Expect(err).To(MatchError(filesink.ErrInvalidTag))
+	})
+
+	When("MaxSize is set", func() {
+		BeforeEach(func() {
+			opts.MaxSize = 100
+		})
+
+		It("rotates files before they grow past it", func() {
+			for i := 0; i < 5; i++ {
+				Expect(s.SendForward("foo", entries(1))).To(Succeed())
+			}
+
+			rotated, err := filepath.Glob(filepath.Join(dir, "foo.log.*"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rotated).To(HaveLen(2))
+
+			for _, path := range append(rotated, filepath.Join(dir, "foo.log")) {
+				info, err := os.Stat(path)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Size()).To(BeNumerically("<=", 100))
+			}
+		})
+
+		When("Compress is set", func() {
+			BeforeEach(func() {
+				opts.Compress = true
+			})
+
+			It("gzips rotated files", func() {
+				for i := 0; i < 3; i++ {
+					Expect(s.SendForward("foo", entries(1))).To(Succeed())
+				}
+
+				rotated, err := filepath.Glob(filepath.Join(dir, "foo.log.*"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rotated).To(HaveLen(1))
+				Expect(rotated[0]).To(HaveSuffix(".gz"))
+
+				f, err := os.Open(rotated[0])
+				Expect(err).ToNot(HaveOccurred())
+
+				defer f.Close()
+
+				zr, err := gzip.NewReader(f)
+				Expect(err).ToNot(HaveOccurred())
+
+				bits, err := io.ReadAll(zr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(bits)).To(HavePrefix("foo: ["))
+			})
+		})
+	})
+
+	When("MaxAge is set", func() {
+		BeforeEach(func() {
+			opts.MaxAge = 10 * time.Millisecond
+		})
+
+		It("rotates files that have been open too long", func() {
+			Expect(s.SendForward("foo", entries(1))).To(Succeed())
+			Expect(s.SendForward("foo", entries(1))).To(Succeed())
+
+			time.Sleep(20 * time.Millisecond)
+
+			Expect(s.SendForward("foo", entries(1))).To(Succeed())
+
+			rotated, err := filepath.Glob(filepath.Join(dir, "foo.log.*"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rotated).To(HaveLen(1))
+			Expect(strings.Count(readFile(rotated[0]), "\n")).To(Equal(2))
+			Expect(strings.Count(readFile(filepath.Join(dir, "foo.log")), "\n")).To(Equal(1))
+		})
+	})
+
+	It("reopens files after Reconnect", func() {
+		path := filepath.Join(dir, "foo.log")
+
+		Expect(s.SendForward("foo", entries(1))).To(Succeed())
+		Expect(os.Rename(path, path+".moved")).To(Succeed())
+		Expect(s.Reconnect()).To(Succeed())
+		Expect(s.SendForward("foo", entries(1))).To(Succeed())
+
+		Expect(strings.Count(readFile(path), "\n")).To(Equal(1))
+	})
+})
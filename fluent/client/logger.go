@@ -0,0 +1,73 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
+)
+
+// Logger is a leveled, structured logger. Args are alternating keys and
+// values. A *slog.Logger satisfies it, so a client can log through any
+// slog.Handler:
+//
+//	c.Logger = slog.New(handler)
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(_ string, _ ...interface{}) {}
+
+func (noopLogger) Info(_ string, _ ...interface{}) {}
+
+func (noopLogger) Warn(_ string, _ ...interface{}) {}
+
+func (noopLogger) Error(_ string, _ ...interface{}) {}
+
+// NewWSLogger adapts l to ws.Logger, so that the debug output of websocket
+// connections goes to the same logger as the clients. Lines are logged at
+// debug level.
+func NewWSLogger(l Logger) ws.Logger {
+	return &wsLogger{l: l}
+}
+
+type wsLogger struct {
+	l Logger
+}
+
+func (w *wsLogger) Println(v ...interface{}) {
+	w.l.Debug(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), "component", "websocket")
+}
+
+func (w *wsLogger) Printf(format string, v ...interface{}) {
+	w.l.Debug(fmt.Sprintf(format, v...), "component", "websocket")
+}
@@ -0,0 +1,234 @@
+//go:build go1.21
+
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// syncBuffer guards a buffer written by the client and read by the test.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// disabledDebugLogger records the messages of the Debug calls made to a
+// *slog.Logger that does not log at debug level.
+type disabledDebugLogger struct {
+	*slog.Logger
+	lock sync.Mutex
+	msgs []string
+}
+
+func (l *disabledDebugLogger) Debug(msg string, args ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.msgs = append(l.msgs, msg)
+	l.Logger.Debug(msg, args...)
+}
+
+func (l *disabledDebugLogger) debugged() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]string(nil), l.msgs...)
+}
+
+var _ = Describe("Logger", func() {
+	var (
+		svr    *fluenttest.Server
+		out    *syncBuffer
+		logger *slog.Logger
+	)
+
+	BeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{SharedKey: []byte("secret")})
+		out = &syncBuffer{}
+		logger = slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	})
+
+	AfterEach(func() {
+		svr.Close()
+	})
+
+	It("logs the lifecycle of a Client through slog", func() {
+		c := New(ConnectionOptions{
+			Factory:           svr.ConnFactory(),
+			RequireAck:        true,
+			ConnectionTimeout: 50 * time.Millisecond,
+			AuthInfo:          AuthInfo{SharedKey: []byte("secret")},
+			Logger:            logger,
+		})
+		c.Hostname = "knight"
+
+		Expect(c.Connect()).To(Succeed())
+		Expect(c.Handshake()).To(Succeed())
+		Expect(c.SendMessage("foo", map[string]interface{}{"first": "Sir"})).To(Succeed())
+
+		svr.SetDropAcks(true)
+		Expect(c.SendMessage("foo", map[string]interface{}{"first": "Sir"})).ToNot(Succeed())
+
+		Expect(c.Reconnect()).To(Succeed())
+		Expect(c.Disconnect()).To(Succeed())
+
+		var msgs []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			Expect(line).To(ContainSubstring("msg="))
+			msgs = append(msgs, line[strings.Index(line, "msg="):])
+		}
+
+		Expect(msgs).To(HaveExactElements(
+			HavePrefix(`msg=connected remote=`+svr.Addr),
+			HavePrefix(`msg="received HELO" auth=false keepalive=`),
+			HavePrefix(`msg="sent PING" hostname=knight username=""`),
+			HavePrefix(`msg="received PONG" hostname=fluenttest auth_result=true`),
+			MatchRegexp(`^msg="sent message" chunk=\S+$`),
+			MatchRegexp(`^msg="received ack" chunk=\S+ latency=\S+$`),
+			MatchRegexp(`^msg="sent message" chunk=\S+$`),
+			MatchRegexp(`^msg="ack timed out" chunk=\S+ err=`),
+			Equal(`msg=reconnecting`),
+			HavePrefix(`msg=disconnected remote=`+svr.Addr+` op=reconnect`),
+			HavePrefix(`msg=connected`),
+			HavePrefix(`msg=disconnected remote=`+svr.Addr+` op=disconnect`),
+		))
+		Expect(out.String()).To(ContainSubstring("level=WARN msg=\"ack timed out\""))
+	})
+
+	It("logs the lifecycle of a WSClient through slog", func() {
+		wsSvr := server.New(server.Options{
+			Handler: func(*server.Message) error { return nil },
+		})
+		httpSvr := httptest.NewServer(wsSvr)
+
+		defer func() {
+			httpSvr.Close()
+			_ = wsSvr.Close()
+		}()
+
+		c := NewWS(WSConnectionOptions{
+			Factory: &DefaultWSConnectionFactory{
+				URL: "ws" + strings.TrimPrefix(httpSvr.URL, "http"),
+			},
+			Logger: logger,
+		})
+
+		Expect(c.Connect()).To(Succeed())
+		Expect(c.Send(protocol.NewMessage("foo", map[string]interface{}{"first": "Sir"}))).To(Succeed())
+		Expect(c.SendRaw([]byte{0x93, 0xa3, 'b', 'a', 'r', 0x00, 0x80})).To(Succeed())
+		Expect(c.Reconnect()).To(Succeed())
+		Expect(c.Disconnect()).To(Succeed())
+
+		var msgs []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			// the debug output of the connections
+			if strings.HasSuffix(line, "component=websocket") {
+				continue
+			}
+
+			msgs = append(msgs, line[strings.Index(line, "msg="):])
+		}
+
+		addr := strings.TrimPrefix(httpSvr.URL, "http://")
+
+		Expect(msgs).To(HaveExactElements(
+			Equal(`msg=connected remote=`+addr),
+			MatchRegexp(`^msg="sent message" bytes=\d+$`),
+			Equal(`msg="sent raw message" bytes=7`),
+			Equal(`msg=reconnecting`),
+			Equal(`msg=disconnected remote=`+addr+` op=reconnect`),
+			Equal(`msg=connected remote=`+addr),
+			Equal(`msg=disconnected remote=`+addr+` op=disconnect`),
+		))
+		Expect(out.String()).To(ContainSubstring("component=websocket"))
+	})
+
+	It("skips the debug events of sends when debug is disabled", func() {
+		infoLogger := &disabledDebugLogger{
+			Logger: slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelInfo})),
+		}
+
+		c := New(ConnectionOptions{
+			Factory:           svr.ConnFactory(),
+			RequireAck:        true,
+			ConnectionTimeout: 50 * time.Millisecond,
+			AuthInfo:          AuthInfo{SharedKey: []byte("secret")},
+			Logger:            infoLogger,
+		})
+
+		Expect(c.Connect()).To(Succeed())
+		Expect(c.Handshake()).To(Succeed())
+		Expect(c.SendMessage("foo", map[string]interface{}{"first": "Sir"})).To(Succeed())
+		Expect(c.SendRaw([]byte{0x93, 0xa3, 'b', 'a', 'r', 0x00, 0x80})).To(Succeed())
+		Expect(c.Disconnect()).To(Succeed())
+
+		debugged := infoLogger.debugged()
+		Expect(debugged).To(ContainElement("connected"))
+		Expect(debugged).ToNot(ContainElement("sent message"))
+		Expect(debugged).ToNot(ContainElement("received ack"))
+		Expect(debugged).ToNot(ContainElement("sent raw message"))
+	})
+
+	It("adapts ws.Logger", func() {
+		var wl ws.Logger = NewWSLogger(logger)
+
+		wl.Println("closing", "the connection")
+		wl.Printf("close received: code %d", 1000)
+
+		Expect(out.String()).To(ContainSubstring(`level=DEBUG msg="closing the connection" component=websocket`))
+		Expect(out.String()).To(ContainSubstring(`level=DEBUG msg="close received: code 1000" component=websocket`))
+	})
+})
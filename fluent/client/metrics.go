@@ -0,0 +1,118 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// MessageInfo describes a message passed to Metrics.
+type MessageInfo struct {
+	Tag  string
+	Mode protocol.MessageMode
+	// Bytes is the size of the encoded message.
+	Bytes int
+	// Chunk is the chunk option of the message, if any.
+	Chunk string
+}
+
+// Metrics receives measurements from Client and WSClient. Implementations
+// must be safe for concurrent use and should return quickly, as they are
+// called on the sending goroutine. The metrics package provides an
+// in-memory implementation.
+type Metrics interface {
+	// ObserveConnect is called after every Connect or Reconnect.
+	ObserveConnect(reconnect bool, err error)
+	// ObserveHandshake is called after every Handshake.
+	ObserveHandshake(err error)
+	// ObserveSend is called after a message is written, or fails to be.
+	ObserveSend(msg MessageInfo, err error)
+	// ObserveAck is called after waiting for the ack of a message that
+	// was written. latency is measured from the start of the write.
+	ObserveAck(msg MessageInfo, latency time.Duration, err error)
+	// ObserveError is called with every error of a send or an ack, after
+	// ObserveSend or ObserveAck, and with errors that are not returned to
+	// a caller, such as the read errors of a WSClient connection.
+	ObserveError(err error)
+	// ObserveRetry is called before every resend of a message by a client
+	// that retries, such as retry.Client or secondary.Client, with the
+	// chunk ID, the number of the attempt about to be made, and the error
+	// of the previous one. Client and WSClient do not retry by themselves.
+	ObserveRetry(chunk string, attempt int, err error)
+}
+
+// encodeForMetrics encodes e with enc so that its size can be measured.
+// When acks are required, the chunk is assigned with gen before encoding.
+func encodeForMetrics(enc *encoder, e protocol.ChunkEncoder, requireAck bool, gen protocol.ChunkIDGenerator) (MessageInfo, protocol.RawMessage, error) {
+	var (
+		info MessageInfo
+		err  error
+	)
+
+	if requireAck {
+		if info.Chunk, err = protocol.ChunkWith(e, gen); err != nil {
+			return info, nil, err
+		}
+	}
+
+	bits, err := enc.encode(e)
+	if err != nil {
+		return info, nil, err
+	}
+
+	raw := protocol.RawMessage(bits)
+	info = newMessageInfo(raw, info.Chunk)
+
+	return info, raw, nil
+}
+
+// observeRaw reports the send of the encoded message bits, whose chunk
+// option is read from the message, and its error.
+func observeRaw(m Metrics, bits []byte, err error) {
+	chunk, _ := protocol.GetChunk(bits)
+	m.ObserveSend(newMessageInfo(bits, chunk), err)
+
+	if err != nil {
+		m.ObserveError(err)
+	}
+}
+
+func newMessageInfo(bits []byte, chunk string) MessageInfo {
+	info := MessageInfo{
+		Mode:  protocol.PeekMode(bits),
+		Bytes: len(bits),
+		Chunk: chunk,
+	}
+
+	// the tag is the first element of every mode
+	if _, rest, err := msgp.ReadArrayHeaderBytes(bits); err == nil {
+		info.Tag, _, _ = msgp.ReadStringBytes(rest)
+	}
+
+	return info
+}
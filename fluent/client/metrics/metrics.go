@@ -0,0 +1,271 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package metrics provides an in-memory implementation of client.Metrics
+// and publishes its stats with expvar.
+package metrics
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+)
+
+const (
+	// DefaultMaxTags is the default number of tags counted separately.
+	DefaultMaxTags = 1000
+	// OtherTag collects the counts of tags seen after the limit is reached.
+	OtherTag = "(other)"
+)
+
+// Counts are the message counters of a tag or mode.
+type Counts struct {
+	Messages uint64
+	Bytes    uint64
+	// Errors counts failed sends and failed acks.
+	Errors uint64
+}
+
+// Latency summarizes ack latencies.
+type Latency struct {
+	Count uint64
+	Total time.Duration
+	Min   time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the average latency, or zero if there is none.
+func (l Latency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+
+	return l.Total / time.Duration(l.Count)
+}
+
+func (l *Latency) observe(d time.Duration) {
+	if l.Count == 0 || d < l.Min {
+		l.Min = d
+	}
+
+	if d > l.Max {
+		l.Max = d
+	}
+
+	l.Count++
+	l.Total += d
+}
+
+// Stats is a snapshot of the measurements of a Collector.
+type Stats struct {
+	Connects        uint64
+	Reconnects      uint64
+	ConnectErrors   uint64
+	Handshakes      uint64
+	HandshakeErrors uint64
+	// Messages and Bytes count the messages written successfully.
+	Messages   uint64
+	Bytes      uint64
+	SendErrors uint64
+	Acks       uint64
+	// AckErrors counts acks that timed out or did not match their chunk.
+	AckErrors  uint64
+	AckLatency Latency
+	// Retries counts the resends of messages by clients that retry.
+	Retries uint64
+	// Errors counts every error reported by the client: failed sends and
+	// acks, and errors that were not returned to a caller.
+	Errors uint64
+	// ByTag and ByMode break down the message counters. Modes are keyed
+	// by their name.
+	ByTag  map[string]Counts
+	ByMode map[string]Counts
+}
+
+// Options configures a Collector.
+type Options struct {
+	// MaxTags limits the number of tags counted separately; later tags
+	// are counted under OtherTag. Defaults to DefaultMaxTags.
+	MaxTags int
+}
+
+// Collector is a client.Metrics that keeps its measurements in memory.
+type Collector struct {
+	maxTags int
+
+	lock  sync.Mutex
+	stats Stats
+}
+
+var _ client.Metrics = (*Collector)(nil)
+
+// New returns an empty Collector.
+func New(opts Options) *Collector {
+	if opts.MaxTags <= 0 {
+		opts.MaxTags = DefaultMaxTags
+	}
+
+	c := &Collector{maxTags: opts.MaxTags}
+	c.Reset()
+
+	return c
+}
+
+// Reset clears the measurements.
+func (c *Collector) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stats = Stats{
+		ByTag:  map[string]Counts{},
+		ByMode: map[string]Counts{},
+	}
+}
+
+// Stats returns a copy of the current measurements.
+func (c *Collector) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := c.stats
+	s.ByTag = make(map[string]Counts, len(c.stats.ByTag))
+	s.ByMode = make(map[string]Counts, len(c.stats.ByMode))
+
+	for k, v := range c.stats.ByTag {
+		s.ByTag[k] = v
+	}
+
+	for k, v := range c.stats.ByMode {
+		s.ByMode[k] = v
+	}
+
+	return s
+}
+
+// Publish exports the stats as an expvar variable. Like expvar.Publish,
+// it panics if the name is already in use.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+}
+
+func (c *Collector) ObserveConnect(reconnect bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch {
+	case err != nil:
+		c.stats.ConnectErrors++
+	case reconnect:
+		c.stats.Reconnects++
+	default:
+		c.stats.Connects++
+	}
+}
+
+func (c *Collector) ObserveHandshake(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err != nil {
+		c.stats.HandshakeErrors++
+	} else {
+		c.stats.Handshakes++
+	}
+}
+
+func (c *Collector) ObserveSend(msg client.MessageInfo, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err != nil {
+		c.stats.SendErrors++
+	} else {
+		c.stats.Messages++
+		c.stats.Bytes += uint64(msg.Bytes)
+	}
+
+	c.count(msg, func(counts *Counts) {
+		if err != nil {
+			counts.Errors++
+			return
+		}
+
+		counts.Messages++
+		counts.Bytes += uint64(msg.Bytes)
+	})
+}
+
+func (c *Collector) ObserveAck(msg client.MessageInfo, latency time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err != nil {
+		c.stats.AckErrors++
+
+		c.count(msg, func(counts *Counts) {
+			counts.Errors++
+		})
+
+		return
+	}
+
+	c.stats.Acks++
+	c.stats.AckLatency.observe(latency)
+}
+
+func (c *Collector) ObserveError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stats.Errors++
+}
+
+func (c *Collector) ObserveRetry(chunk string, attempt int, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stats.Retries++
+}
+
+// count applies fn to the counters of the tag and mode of msg. It must be
+// called with the lock held.
+func (c *Collector) count(msg client.MessageInfo, fn func(*Counts)) {
+	tag := msg.Tag
+	if _, ok := c.stats.ByTag[tag]; !ok && len(c.stats.ByTag) >= c.maxTags {
+		tag = OtherTag
+	}
+
+	counts := c.stats.ByTag[tag]
+	fn(&counts)
+	c.stats.ByTag[tag] = counts
+
+	mode := msg.Mode.String()
+	counts = c.stats.ByMode[mode]
+	fn(&counts)
+	c.stats.ByMode[mode] = counts
+}
@@ -0,0 +1,254 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package metrics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/metrics"
+	"github.com/aanujj/fluent-forward-go/fluent/client/retry"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// chunkRecorder records the chunks passed to ObserveSend.
+type chunkRecorder struct {
+	*metrics.Collector
+	chunks []string
+}
+
+func (r *chunkRecorder) ObserveSend(msg client.MessageInfo, err error) {
+	r.chunks = append(r.chunks, msg.Chunk)
+	r.Collector.ObserveSend(msg, err)
+}
+
+var _ = Describe("Collector", func() {
+	var (
+		collector *metrics.Collector
+		svr       *fluenttest.Server
+		record    = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	BeforeEach(func() {
+		collector = metrics.New(metrics.Options{})
+		svr = fluenttest.NewServer(fluenttest.Options{})
+	})
+
+	AfterEach(func() {
+		svr.Close()
+	})
+
+	Context("with a Client", func() {
+		var c *client.Client
+
+		BeforeEach(func() {
+			c = client.New(client.ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: 100 * time.Millisecond,
+				Metrics:           collector,
+			})
+
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(c.Disconnect()).To(Succeed())
+		})
+
+		It("counts messages, bytes, and acks by tag and mode", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(c.SendPacked("bar", protocol.EntryList{
+				{Timestamp: protocol.EventTimeNow(), Record: record},
+			})).To(Succeed())
+
+			var size int
+			for _, msg := range svr.Messages() {
+				size += len(msg.Raw)
+			}
+
+			stats := collector.Stats()
+			Expect(stats.Connects).To(Equal(uint64(1)))
+			Expect(stats.Messages).To(Equal(uint64(3)))
+			Expect(stats.Bytes).To(Equal(uint64(size)))
+			Expect(stats.Acks).To(Equal(uint64(3)))
+			Expect(stats.AckLatency.Count).To(Equal(uint64(3)))
+			Expect(stats.AckLatency.Max).To(BeNumerically(">=", stats.AckLatency.Mean()))
+			Expect(stats.AckLatency.Mean()).To(BeNumerically(">=", stats.AckLatency.Min))
+
+			Expect(stats.ByTag).To(HaveLen(2))
+			Expect(stats.ByTag["foo"].Messages).To(Equal(uint64(2)))
+			Expect(stats.ByTag["bar"].Messages).To(Equal(uint64(1)))
+			Expect(stats.ByMode).To(HaveKeyWithValue("Message", HaveField("Messages", uint64(2))))
+			Expect(stats.ByMode).To(HaveKeyWithValue("PackedForward", HaveField("Messages", uint64(1))))
+		})
+
+		It("counts raw messages", func() {
+			bits, err := protocol.NewMessage("foo", record).MarshalMsg(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(c.SendRaw(bits)).To(Succeed())
+
+			stats := collector.Stats()
+			Expect(stats.Messages).To(Equal(uint64(1)))
+			Expect(stats.Acks).To(BeZero())
+			Expect(stats.ByTag["foo"].Bytes).To(Equal(uint64(len(bits))))
+		})
+
+		It("counts ack timeouts", func() {
+			svr.SetDropAcks(true)
+
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+
+			stats := collector.Stats()
+			Expect(stats.Messages).To(Equal(uint64(1)))
+			Expect(stats.AckErrors).To(Equal(uint64(1)))
+			Expect(stats.Errors).To(Equal(uint64(1)))
+			Expect(stats.ByTag["foo"].Errors).To(Equal(uint64(1)))
+		})
+
+		It("counts reconnects and failed sends", func() {
+			Expect(c.Reconnect()).To(Succeed())
+			Expect(c.Disconnect()).To(Succeed())
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+
+			stats := collector.Stats()
+			Expect(stats.Reconnects).To(Equal(uint64(1)))
+			Expect(stats.SendErrors).To(Equal(uint64(1)))
+			Expect(stats.Errors).To(Equal(uint64(1)))
+			Expect(stats.Messages).To(BeZero())
+		})
+
+		It("reports the chunk of raw messages", func() {
+			recorder := &chunkRecorder{Collector: collector}
+			c.Metrics = recorder
+
+			msg := protocol.NewMessage("foo", record)
+			chunk, err := msg.Chunk()
+			Expect(err).ToNot(HaveOccurred())
+
+			bits, err := msg.MarshalMsg(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(c.SendRaw(bits)).To(Succeed())
+			Expect(recorder.chunks).To(Equal([]string{chunk}))
+		})
+	})
+
+	Context("with a WSClient", func() {
+		It("counts messages", func() {
+			c := client.NewWS(client.WSConnectionOptions{
+				Factory: svr.WSConnectionFactory(),
+				Metrics: collector,
+			})
+
+			Expect(c.Connect()).To(Succeed())
+			Expect(c.Send(protocol.NewMessage("foo", record))).To(Succeed())
+
+			_, err := svr.WaitForEvents("foo", 1, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Disconnect()).To(Succeed())
+
+			stats := collector.Stats()
+			Expect(stats.Connects).To(Equal(uint64(1)))
+			Expect(stats.Messages).To(Equal(uint64(1)))
+			Expect(stats.ByTag).To(HaveKey("foo"))
+		})
+
+		It("reports chunks and failed sends", func() {
+			recorder := &chunkRecorder{Collector: collector}
+
+			c := client.NewWS(client.WSConnectionOptions{
+				Factory: svr.WSConnectionFactory(),
+				Metrics: recorder,
+			})
+
+			msg := protocol.NewMessage("foo", record)
+			chunk, err := msg.Chunk()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(c.Connect()).To(Succeed())
+			Expect(c.Send(msg)).To(Succeed())
+			Expect(c.Disconnect()).To(Succeed())
+			Expect(c.Send(msg)).ToNot(Succeed())
+
+			Expect(recorder.chunks).To(Equal([]string{chunk, ""}))
+
+			stats := collector.Stats()
+			Expect(stats.Messages).To(Equal(uint64(1)))
+			Expect(stats.SendErrors).To(Equal(uint64(1)))
+			Expect(stats.Errors).To(BeNumerically(">=", 1))
+		})
+	})
+
+	Context("with a retry.Client", func() {
+		It("counts retries", func() {
+			primary := &clientfakes.FakeMessageClient{}
+			primary.SendReturns(errors.New("down"))
+
+			c := retry.New(retry.Options{
+				Client:      primary,
+				MaxAttempts: 3,
+				Backoff:     time.Millisecond,
+				Metrics:     collector,
+			})
+
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+			Expect(collector.Stats().Retries).To(Equal(uint64(2)))
+		})
+	})
+
+	It("limits the number of tags", func() {
+		collector = metrics.New(metrics.Options{MaxTags: 1})
+
+		collector.ObserveSend(client.MessageInfo{Tag: "foo", Bytes: 1}, nil)
+		collector.ObserveSend(client.MessageInfo{Tag: "bar", Bytes: 1}, nil)
+		collector.ObserveSend(client.MessageInfo{Tag: "baz", Bytes: 1}, errors.New("nope"))
+
+		stats := collector.Stats()
+		Expect(stats.ByTag).To(HaveLen(2))
+		Expect(stats.ByTag[metrics.OtherTag]).To(Equal(metrics.Counts{Messages: 1, Bytes: 1, Errors: 1}))
+	})
+
+	It("publishes the stats with expvar", func() {
+		collector.ObserveSend(client.MessageInfo{Tag: "foo", Bytes: 10}, nil)
+		collector.ObserveRetry("Z2F3YWlu", 2, errors.New("nope"))
+		collector.Publish("fluent_metrics_test")
+
+		var stats metrics.Stats
+		Expect(json.Unmarshal([]byte(expvar.Get("fluent_metrics_test").String()), &stats)).To(Succeed())
+		Expect(stats.Bytes).To(Equal(uint64(10)))
+		Expect(stats.Retries).To(Equal(uint64(1)))
+		Expect(stats.ByTag).To(HaveKey("foo"))
+	})
+})
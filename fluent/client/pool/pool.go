@@ -0,0 +1,385 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package pool provides a client.MessageClient that sends over several
+// connections to the same endpoint, so that concurrent senders are not
+// serialized on a single socket.
+package pool
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	DefaultSize              = 4
+	DefaultReconnectInterval = time.Second
+)
+
+var (
+	// ErrNotConnected is returned for sends made while the pool is not
+	// connected.
+	ErrNotConnected = errors.New("pool is not connected")
+	// ErrTimeout is returned for sends that waited AcquireTimeout without
+	// getting an idle connection.
+	ErrTimeout = errors.New("no idle connection")
+)
+
+// Options configures a Pool.
+type Options struct {
+	// ConnectionOptions configures every connection. Connections perform
+	// the handshake when AuthInfo.SharedKey is set.
+	ConnectionOptions client.ConnectionOptions
+	// Size is the number of connections. Defaults to DefaultSize.
+	Size int
+	// AcquireTimeout is the longest a send waits for an idle connection.
+	// Zero means no limit.
+	AcquireTimeout time.Duration
+	// ReconnectInterval is the wait between attempts to replace a broken
+	// connection. Defaults to DefaultReconnectInterval.
+	ReconnectInterval time.Duration
+}
+
+// Stats describes the connections of a Pool.
+type Stats struct {
+	// Size is the number of connections, and Idle and Broken the number
+	// that are waiting for a send and being replaced.
+	Size   int
+	Idle   int
+	Broken int
+	// Replaced counts the broken connections that were replaced.
+	Replaced uint64
+}
+
+// generation holds the connections opened by a single Connect.
+type generation struct {
+	clients []*client.Client
+	idle    chan *client.Client
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	lock    sync.Mutex
+	stopped bool
+	broken  int
+}
+
+// Pool is a client.MessageClient that keeps Size connections to the same
+// endpoint. Every send takes an idle connection, waiting for one if they
+// are all busy, and returns it once the send, including the wait for the
+// ack, is done. A connection whose send failed is replaced in the
+// background, and is not used until it is.
+type Pool struct {
+	client.SendFunc
+	opts              client.ConnectionOptions
+	size              int
+	acquireTimeout    time.Duration
+	reconnectInterval time.Duration
+
+	lock     sync.Mutex
+	gen      *generation
+	replaced uint64
+}
+
+var _ client.MessageClient = (*Pool)(nil)
+
+// New returns a Pool configured by opts. Connect opens the connections.
+func New(opts Options) *Pool {
+	p := &Pool{
+		opts:              opts.ConnectionOptions,
+		size:              opts.Size,
+		acquireTimeout:    opts.AcquireTimeout,
+		reconnectInterval: opts.ReconnectInterval,
+	}
+
+	p.SendFunc = p.Send
+
+	if p.size <= 0 {
+		p.size = DefaultSize
+	}
+
+	if p.reconnectInterval <= 0 {
+		p.reconnectInterval = DefaultReconnectInterval
+	}
+
+	return p
+}
+
+// Connect opens the connections concurrently. It fails if none could be
+// opened; otherwise the ones that failed are replaced in the background.
+func (p *Pool) Connect() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.gen != nil {
+		return errors.New("pool is already connected")
+	}
+
+	g := &generation{
+		clients: make([]*client.Client, p.size),
+		idle:    make(chan *client.Client, p.size),
+		stop:    make(chan struct{}),
+	}
+
+	errs := make([]error, p.size)
+
+	var wg sync.WaitGroup
+
+	for i := range g.clients {
+		g.clients[i] = client.New(p.opts)
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.open(g.clients[i], false)
+		}(i)
+	}
+
+	wg.Wait()
+
+	var firstErr error
+
+	for i, err := range errs {
+		if err == nil {
+			g.idle <- g.clients[i]
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(g.idle) == 0 {
+		for _, c := range g.clients {
+			_ = c.Disconnect()
+		}
+
+		return firstErr
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			p.repair(g, g.clients[i])
+		}
+	}
+
+	p.gen = g
+
+	return nil
+}
+
+// open connects c, or reconnects it, and performs the handshake if
+// required.
+func (p *Pool) open(c *client.Client, reconnect bool) error {
+	var err error
+
+	if reconnect {
+		err = c.Reconnect()
+	} else {
+		err = c.Connect()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.AuthInfo.SharedKey != nil {
+		if err = c.Handshake(); err != nil {
+			_ = c.Disconnect()
+		}
+	}
+
+	return err
+}
+
+// Disconnect stops replacing broken connections and closes every
+// connection, which aborts the sends in progress. It returns the first
+// error.
+func (p *Pool) Disconnect() error {
+	p.lock.Lock()
+	g := p.gen
+	p.gen = nil
+	p.lock.Unlock()
+
+	if g == nil {
+		return nil
+	}
+
+	g.lock.Lock()
+	g.stopped = true
+	g.lock.Unlock()
+
+	close(g.stop)
+	g.wg.Wait()
+
+	var err error
+
+	for _, c := range g.clients {
+		if derr := c.Disconnect(); err == nil {
+			err = derr
+		}
+	}
+
+	return err
+}
+
+// Reconnect replaces every connection.
+func (p *Pool) Reconnect() error {
+	_ = p.Disconnect()
+
+	return p.Connect()
+}
+
+// Stats returns the state of the connections.
+func (p *Pool) Stats() Stats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s := Stats{Size: p.size, Replaced: p.replaced}
+
+	if g := p.gen; g != nil {
+		g.lock.Lock()
+		s.Idle, s.Broken = len(g.idle), g.broken
+		g.lock.Unlock()
+	}
+
+	return s
+}
+
+// Send encodes e, with a chunk ID if acks are required, and sends it over
+// an idle connection. A message that cannot be encoded fails before a
+// connection is taken, so that it does not break one.
+func (p *Pool) Send(e protocol.ChunkEncoder) error {
+	bits, err := protocol.EncodeAcked(e, p.opts.RequireAck, p.opts.ChunkIDGenerator)
+	if err != nil {
+		return err
+	}
+
+	return p.do(func(c *client.Client) error {
+		return c.Send(protocol.RawMessage(bits))
+	})
+}
+
+// SendRaw sends raw over an idle connection.
+func (p *Pool) SendRaw(raw []byte) error {
+	return p.do(func(c *client.Client) error {
+		return c.SendRaw(raw)
+	})
+}
+
+func (p *Pool) do(send func(*client.Client) error) error {
+	g, c, err := p.acquire()
+	if err != nil {
+		return err
+	}
+
+	// the message is already encoded, so the errors are those of the
+	// connection or of the ack
+	err = send(c)
+
+	if err != nil {
+		p.repair(g, c)
+	} else {
+		g.idle <- c
+	}
+
+	return err
+}
+
+// acquire waits for an idle connection.
+func (p *Pool) acquire() (*generation, *client.Client, error) {
+	p.lock.Lock()
+	g := p.gen
+	p.lock.Unlock()
+
+	if g == nil {
+		return nil, nil, ErrNotConnected
+	}
+
+	select {
+	case c := <-g.idle:
+		return g, c, nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+
+	if p.acquireTimeout > 0 {
+		t := time.NewTimer(p.acquireTimeout)
+		defer t.Stop()
+
+		timeout = t.C
+	}
+
+	select {
+	case c := <-g.idle:
+		return g, c, nil
+	case <-g.stop:
+		return nil, nil, ErrNotConnected
+	case <-timeout:
+		return nil, nil, ErrTimeout
+	}
+}
+
+// repair reconnects c in the background until it succeeds or the pool is
+// disconnected, then makes it idle again.
+func (p *Pool) repair(g *generation, c *client.Client) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.stopped {
+		return
+	}
+
+	g.broken++
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		for {
+			if err := p.open(c, true); err == nil {
+				break
+			}
+
+			select {
+			case <-g.stop:
+				return
+			case <-time.After(p.reconnectInterval):
+			}
+		}
+
+		g.lock.Lock()
+		g.broken--
+		g.lock.Unlock()
+
+		p.lock.Lock()
+		p.replaced++
+		p.lock.Unlock()
+
+		g.idle <- c
+	}()
+}
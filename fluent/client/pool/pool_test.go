@@ -0,0 +1,221 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pool_test
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/pool"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pool", func() {
+	var (
+		svr    *fluenttest.Server
+		opts   pool.Options
+		p      *pool.Pool
+		record = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	sendConcurrently := func(n int) []error {
+		var wg sync.WaitGroup
+
+		errs := make([]error, n)
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = p.SendMessage("foo", record)
+			}(i)
+		}
+
+		wg.Wait()
+
+		return errs
+	}
+
+	BeforeEach(func() {
+		svr = fluenttest.NewServer(fluenttest.Options{})
+		DeferCleanup(svr.Close)
+
+		opts = pool.Options{
+			ConnectionOptions: client.ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: time.Second,
+			},
+			Size:              3,
+			ReconnectInterval: 10 * time.Millisecond,
+		}
+	})
+
+	JustBeforeEach(func() {
+		p = pool.New(opts)
+	})
+
+	AfterEach(func() {
+		Expect(p.Disconnect()).To(Succeed())
+	})
+
+	It("returns ErrNotConnected before Connect", func() {
+		Expect(p.SendMessage("foo", record)).To(MatchError(pool.ErrNotConnected))
+	})
+
+	When("connected", func() {
+		JustBeforeEach(func() {
+			Expect(p.Connect()).To(Succeed())
+		})
+
+		It("opens Size connections", func() {
+			Expect(p.Stats()).To(Equal(pool.Stats{Size: 3, Idle: 3}))
+			Expect(p.Connect()).ToNot(Succeed())
+		})
+
+		It("sends concurrently over different connections", func() {
+			svr.SetAckDelay(100 * time.Millisecond)
+
+			for _, err := range sendConcurrently(3) {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			addrs := map[string]bool{}
+			for _, msg := range svr.Messages() {
+				addrs[msg.RemoteAddr.String()] = true
+			}
+
+			Expect(addrs).To(HaveLen(3))
+			Expect(p.Stats().Idle).To(Equal(3))
+		})
+
+		It("rejects sends after Disconnect", func() {
+			Expect(p.Disconnect()).To(Succeed())
+			Expect(p.SendMessage("foo", record)).To(MatchError(pool.ErrNotConnected))
+			Expect(p.Stats()).To(Equal(pool.Stats{Size: 3}))
+		})
+
+		It("reconnects", func() {
+			Expect(p.Reconnect()).To(Succeed())
+			Expect(p.SendMessage("foo", record)).To(Succeed())
+		})
+	})
+
+	When("a send fails", func() {
+		BeforeEach(func() {
+			opts.ConnectionOptions.ConnectionTimeout = 50 * time.Millisecond
+		})
+
+		JustBeforeEach(func() {
+			Expect(p.Connect()).To(Succeed())
+		})
+
+		It("replaces the connection", func() {
+			svr.SetDropAcks(true)
+			Expect(p.SendMessage("foo", record)).ToNot(Succeed())
+			svr.SetDropAcks(false)
+
+			Eventually(p.Stats).Should(Equal(pool.Stats{Size: 3, Idle: 3, Replaced: 1}))
+			Expect(p.SendMessage("foo", record)).To(Succeed())
+		})
+
+		It("keeps the connection if the message cannot be encoded", func() {
+			Expect(p.SendMessage("foo", map[string]interface{}{"c": make(chan int)})).ToNot(Succeed())
+
+			Consistently(p.Stats).Should(Equal(pool.Stats{Size: 3, Idle: 3}))
+			Expect(p.SendMessage("foo", record)).To(Succeed())
+			Expect(p.Stats().Replaced).To(BeZero())
+		})
+	})
+
+	When("AcquireTimeout is set", func() {
+		BeforeEach(func() {
+			opts.Size = 1
+			opts.AcquireTimeout = 20 * time.Millisecond
+		})
+
+		JustBeforeEach(func() {
+			Expect(p.Connect()).To(Succeed())
+		})
+
+		It("fails sends that wait too long for a connection", func() {
+			svr.SetAckDelay(200 * time.Millisecond)
+
+			errs := sendConcurrently(2)
+			Expect(errs).To(ContainElement(MatchError(pool.ErrTimeout)))
+			Expect(errs).To(ContainElement(BeNil()))
+		})
+	})
+
+	When("the server requires a handshake", func() {
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{SharedKey: []byte("thisisasharedkey")})
+			DeferCleanup(svr.Close)
+
+			opts.ConnectionOptions.Factory = svr.ConnFactory()
+			opts.ConnectionOptions.AuthInfo = client.AuthInfo{SharedKey: []byte("thisisasharedkey")}
+		})
+
+		It("performs it on every connection", func() {
+			Expect(p.Connect()).To(Succeed())
+
+			for _, err := range sendConcurrently(3) {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			Expect(svr.Messages()).To(HaveLen(3))
+		})
+
+		It("fails when it is rejected", func() {
+			opts.ConnectionOptions.AuthInfo.SharedKey = []byte("thisisthewrongkey")
+			p = pool.New(opts)
+
+			Expect(p.Connect()).To(MatchError(ContainSubstring("shared key mismatch")))
+		})
+	})
+
+	When("the server is unreachable", func() {
+		BeforeEach(func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.Close()).To(Succeed())
+
+			opts.ConnectionOptions.Factory = &client.ConnFactory{
+				Network: "tcp",
+				Address: l.Addr().String(),
+			}
+		})
+
+		It("fails to connect", func() {
+			Expect(p.Connect()).ToNot(Succeed())
+			Expect(p.SendMessage("foo", record)).To(MatchError(pool.ErrNotConnected))
+		})
+	})
+})
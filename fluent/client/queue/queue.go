@@ -0,0 +1,470 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package queue provides a bounded in-memory queue in front of a client, so
+// that sends do not wait for the network, with a defined behavior when the
+// destination cannot keep up.
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	DefaultMaxMessages   = 10000
+	DefaultMaxBytes      = 8 << 20
+	DefaultRetryInterval = time.Second
+)
+
+var (
+	// ErrFull is returned when a message is not queued because the queue
+	// is full.
+	ErrFull = errors.New("queue is full")
+	// ErrTooLarge is returned for a message larger than the byte limit of
+	// the queue, which could never be queued.
+	ErrTooLarge = errors.New("message is larger than the queue")
+)
+
+// Policy decides what happens to a message sent to a full queue.
+type Policy int
+
+const (
+	// Block waits for space until BlockTimeout expires, then returns
+	// ErrFull.
+	Block Policy = iota
+	// DropNewest drops the message and returns ErrFull.
+	DropNewest
+	// DropOldest drops the oldest messages until the message fits.
+	DropOldest
+	// SpillToDisk writes the message to SpillDir. Once a message has been
+	// spilled, later messages are spilled too until the disk is drained,
+	// so that they are sent in order.
+	SpillToDisk
+)
+
+// Destination is the client a Queue sends to. Both client.Client and
+// client.WSClient implement it.
+type Destination interface {
+	Connect() error
+	Disconnect() error
+	Reconnect() error
+	Send(e protocol.ChunkEncoder) error
+}
+
+// Options configures a Queue.
+type Options struct {
+	Destination Destination
+	// MaxMessages and MaxBytes limit the messages held in memory. They
+	// default to DefaultMaxMessages and DefaultMaxBytes. A message is
+	// whatever was passed to one Send, whatever the number of events in it.
+	MaxMessages int
+	MaxBytes    int
+	Policy      Policy
+	// BlockTimeout is how long the Block policy waits for space. If zero,
+	// it waits until there is space.
+	BlockTimeout time.Duration
+	// SpillDir is the directory used by the SpillToDisk policy. Messages
+	// left in it by a previous Queue are sent before new ones.
+	SpillDir string
+	// MaxSpillBytes limits the size of SpillDir. Messages that do not fit
+	// are dropped as with DropNewest. If zero, there is no limit.
+	MaxSpillBytes int64
+	// RequireAck must be set if the destination requires acks, since it
+	// fails every message that has no chunk ID. The ID is given when the
+	// message is queued, and kept when it is spilled and sent again.
+	RequireAck bool
+	// ChunkIDGenerator generates the chunk IDs of queued messages.
+	// protocol.DefaultChunkIDGenerator is used if nil.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	// RetryInterval is the time to wait after a failed send before
+	// reconnecting the destination and sending the message again.
+	// Defaults to DefaultRetryInterval.
+	RetryInterval time.Duration
+	// OnError, if set, is called with the errors of the destination and
+	// of SpillDir, which cannot be returned by Send.
+	OnError func(error)
+}
+
+// Stats describes the contents of a Queue and counts what happened to the
+// messages sent to it.
+type Stats struct {
+	// Messages and Bytes are held in memory. The message being sent is
+	// not included.
+	Messages int
+	Bytes    int
+	// SpillBytes is the size of the messages in SpillDir.
+	SpillBytes int64
+
+	Sent uint64
+	// Spilled counts the messages written to SpillDir.
+	Spilled uint64
+	// DroppedNewest counts messages dropped by DropNewest or because
+	// SpillDir was full, DroppedOldest messages dropped by DropOldest, and
+	// TimedOut messages that Block gave up on.
+	DroppedNewest uint64
+	DroppedOldest uint64
+	TimedOut      uint64
+}
+
+// Queue is a client.MessageClient that encodes messages, holds them in a
+// bounded queue, and sends them to its destination from a single
+// goroutine, in order. Failed sends are retried, after reconnecting the
+// destination, until they succeed or the Queue is disconnected, so
+// messages are delivered at least once.
+//
+// Send returns an error only if the message was not queued.
+type Queue struct {
+	client.SendFunc
+	dest          Destination
+	maxMessages   int
+	maxBytes      int
+	policy        Policy
+	blockTimeout  time.Duration
+	maxSpillBytes int64
+	requireAck    bool
+	chunkIDs      protocol.ChunkIDGenerator
+	retryInterval time.Duration
+	onError       func(error)
+
+	lock  sync.Mutex
+	items [][]byte
+	stats Stats
+	// spill is nil unless the policy is SpillToDisk.
+	spill *spill
+	// space is closed, and replaced, whenever a message leaves memory.
+	space chan struct{}
+	// ready wakes the sender when a message is queued.
+	ready chan struct{}
+	// stop is closed to stop the sender, which closes done. Both are nil
+	// while disconnected.
+	stop chan struct{}
+	done chan struct{}
+}
+
+var _ client.MessageClient = (*Queue)(nil)
+
+// New returns a Queue configured by opts. It returns an error if SpillDir
+// cannot be opened.
+func New(opts Options) (*Queue, error) {
+	q := &Queue{
+		dest:          opts.Destination,
+		maxMessages:   opts.MaxMessages,
+		maxBytes:      opts.MaxBytes,
+		policy:        opts.Policy,
+		blockTimeout:  opts.BlockTimeout,
+		maxSpillBytes: opts.MaxSpillBytes,
+		requireAck:    opts.RequireAck,
+		chunkIDs:      opts.ChunkIDGenerator,
+		retryInterval: opts.RetryInterval,
+		onError:       opts.OnError,
+		space:         make(chan struct{}),
+		ready:         make(chan struct{}, 1),
+	}
+
+	q.SendFunc = q.Send
+
+	if q.maxMessages <= 0 {
+		q.maxMessages = DefaultMaxMessages
+	}
+
+	if q.maxBytes <= 0 {
+		q.maxBytes = DefaultMaxBytes
+	}
+
+	if q.retryInterval <= 0 {
+		q.retryInterval = DefaultRetryInterval
+	}
+
+	if q.policy == SpillToDisk {
+		if len(opts.SpillDir) == 0 {
+			return nil, errors.New("SpillToDisk requires a SpillDir")
+		}
+
+		s, err := openSpill(opts.SpillDir)
+		if err != nil {
+			return nil, err
+		}
+
+		q.spill = s
+	}
+
+	return q, nil
+}
+
+// Connect connects the destination and starts sending queued messages. If
+// the destination fails to connect, the error is returned and the sender
+// keeps reconnecting it.
+func (q *Queue) Connect() error {
+	err := q.dest.Connect()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.stop == nil {
+		q.stop, q.done = make(chan struct{}), make(chan struct{})
+		go q.run(q.stop, q.done)
+	}
+
+	return err
+}
+
+// Disconnect stops sending and disconnects the destination. Queued
+// messages are kept, and sent after the next Connect.
+func (q *Queue) Disconnect() error {
+	q.lock.Lock()
+	stop, done := q.stop, q.done
+	q.stop, q.done = nil, nil
+	q.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	return q.dest.Disconnect()
+}
+
+// Reconnect reconnects the destination.
+func (q *Queue) Reconnect() error {
+	return q.dest.Reconnect()
+}
+
+// Stats returns the contents and counters of the queue.
+func (q *Queue) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	s := q.stats
+	s.Messages = len(q.items)
+
+	if q.spill != nil {
+		s.SpillBytes = q.spill.size
+	}
+
+	return s
+}
+
+// Send encodes e and queues it.
+func (q *Queue) Send(e protocol.ChunkEncoder) error {
+	bits, err := protocol.EncodeAcked(e, q.requireAck, q.chunkIDs)
+	if err != nil {
+		return err
+	}
+
+	return q.enqueue(bits)
+}
+
+// SendRaw queues a copy of raw.
+func (q *Queue) SendRaw(raw []byte) error {
+	return q.enqueue(append([]byte(nil), raw...))
+}
+
+func (q *Queue) enqueue(msg []byte) error {
+	if len(msg) > q.maxBytes && q.policy != SpillToDisk {
+		return ErrTooLarge
+	}
+
+	var deadline <-chan time.Time
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for {
+		if q.spill != nil && (q.spill.size > 0 || !q.fits(msg)) {
+			return q.spillLocked(msg)
+		}
+
+		if q.fits(msg) {
+			q.items = append(q.items, msg)
+			q.stats.Bytes += len(msg)
+			q.wake()
+
+			return nil
+		}
+
+		switch q.policy {
+		case DropNewest:
+			q.stats.DroppedNewest++
+			return ErrFull
+		case DropOldest:
+			for !q.fits(msg) {
+				q.stats.Bytes -= len(q.items[0])
+				q.items[0] = nil
+				q.items = q.items[1:]
+				q.stats.DroppedOldest++
+			}
+
+			continue
+		}
+
+		if deadline == nil && q.blockTimeout > 0 {
+			timer := time.NewTimer(q.blockTimeout)
+			defer timer.Stop()
+
+			deadline = timer.C
+		}
+
+		space := q.space
+		q.lock.Unlock()
+
+		select {
+		case <-space:
+			q.lock.Lock()
+		case <-deadline:
+			q.lock.Lock()
+			q.stats.TimedOut++
+
+			return ErrFull
+		}
+	}
+}
+
+// fits must be called with the lock held.
+func (q *Queue) fits(msg []byte) bool {
+	return len(q.items) < q.maxMessages && q.stats.Bytes+len(msg) <= q.maxBytes
+}
+
+// spillLocked must be called with the lock held.
+func (q *Queue) spillLocked(msg []byte) error {
+	if q.maxSpillBytes > 0 && q.spill.size+int64(len(msg)) > q.maxSpillBytes {
+		q.stats.DroppedNewest++
+		return ErrFull
+	}
+
+	if err := q.spill.write(msg); err != nil {
+		return err
+	}
+
+	q.stats.Spilled++
+	q.wake()
+
+	return nil
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest message, from memory and then from SpillDir, or
+// false if the sender was stopped while waiting for one.
+func (q *Queue) next(stop <-chan struct{}) ([]byte, bool) {
+	for {
+		q.lock.Lock()
+
+		if len(q.items) > 0 {
+			msg := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.stats.Bytes -= len(msg)
+
+			close(q.space)
+			q.space = make(chan struct{})
+			q.lock.Unlock()
+
+			return msg, true
+		}
+
+		if q.spill != nil && q.spill.size > 0 {
+			msg, err := q.spill.read()
+			q.lock.Unlock()
+
+			if err != nil {
+				q.reportError(err)
+				continue
+			}
+
+			if msg != nil {
+				return msg, true
+			}
+
+			continue
+		}
+
+		q.lock.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+// requeue puts back a message that the sender was stopped before sending.
+func (q *Queue) requeue(msg []byte) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.items = append([][]byte{msg}, q.items...)
+	q.stats.Bytes += len(msg)
+}
+
+func (q *Queue) run(stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		msg, ok := q.next(stop)
+		if !ok {
+			return
+		}
+
+		for {
+			err := q.dest.Send(protocol.RawMessage(msg))
+			if err == nil {
+				break
+			}
+
+			q.reportError(err)
+
+			select {
+			case <-time.After(q.retryInterval):
+			case <-stop:
+				q.requeue(msg)
+				return
+			}
+
+			if err := client.ReconnectAndHandshake(q.dest); err != nil {
+				q.reportError(err)
+			}
+		}
+
+		q.lock.Lock()
+		q.stats.Sent++
+		q.lock.Unlock()
+	}
+}
+
+func (q *Queue) reportError(err error) {
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
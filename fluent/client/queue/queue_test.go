@@ -0,0 +1,374 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package queue_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/queue"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Queue", func() {
+	var (
+		dest    *clientfakes.FakeMessageClient
+		opts    queue.Options
+		q       *queue.Queue
+		lock    sync.Mutex
+		errs    []error
+		release chan struct{}
+		record  = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	// sent returns the tags of the messages the destination received.
+	sent := func() []string {
+		var tags []string
+
+		for i := 0; i < dest.SendCallCount(); i++ {
+			var msg protocol.Message
+
+			_, err := msg.UnmarshalMsg(dest.SendArgsForCall(i).(protocol.RawMessage))
+			Expect(err).ToNot(HaveOccurred())
+
+			tags = append(tags, msg.Tag)
+		}
+
+		return tags
+	}
+
+	send := func(tags ...string) {
+		for _, tag := range tags {
+			ExpectWithOffset(1, q.SendMessage(tag, record)).To(Succeed())
+		}
+	}
+
+	// fill blocks the destination in the send of "a" and queues "b" and
+	// "c", which fills the queue.
+	fill := func() {
+		ch := make(chan struct{})
+		release = ch
+		dest.SendStub = func(protocol.ChunkEncoder) error {
+			<-ch
+			return nil
+		}
+
+		send("a")
+		Eventually(dest.SendCallCount).Should(Equal(1))
+		send("b", "c")
+	}
+
+	unblock := func() {
+		close(release)
+		release = nil
+	}
+
+	BeforeEach(func() {
+		dest = &clientfakes.FakeMessageClient{}
+		errs = nil
+
+		opts = queue.Options{
+			Destination:   dest,
+			MaxMessages:   2,
+			RetryInterval: 10 * time.Millisecond,
+			OnError: func(err error) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				errs = append(errs, err)
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+
+		q, err = queue.New(opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.Connect()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if release != nil {
+			unblock()
+		}
+
+		Expect(q.Disconnect()).To(Succeed())
+	})
+
+	It("sends messages in order", func() {
+		send("a", "b")
+
+		Eventually(sent).Should(Equal([]string{"a", "b"}))
+		Eventually(q.Stats).Should(Equal(queue.Stats{Sent: 2}))
+	})
+
+	It("retries failed sends after reconnecting", func() {
+		dest.SendReturnsOnCall(0, errors.New("nope"))
+		send("a")
+
+		Eventually(q.Stats).Should(Equal(queue.Stats{Sent: 1}))
+		Expect(sent()).To(Equal([]string{"a", "a"}))
+		Expect(dest.ReconnectCallCount()).To(Equal(1))
+		Expect(errs).To(ConsistOf(MatchError("nope")))
+	})
+
+	It("copies raw messages", func() {
+		Expect(q.Disconnect()).To(Succeed())
+
+		raw, err := protocol.NewMessage("a", record).MarshalMsg(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.SendRaw(raw)).To(Succeed())
+		raw[0] = 0
+
+		Expect(q.Connect()).To(Succeed())
+		Eventually(sent).Should(Equal([]string{"a"}))
+	})
+
+	It("keeps queued messages when disconnected", func() {
+		dest.SendReturns(errors.New("nope"))
+		send("a", "b")
+
+		Eventually(dest.ReconnectCallCount).Should(BeNumerically(">", 0))
+		Expect(q.Disconnect()).To(Succeed())
+		Expect(q.Stats().Messages).To(Equal(2))
+
+		dest.SendReturns(nil)
+		Expect(q.Connect()).To(Succeed())
+
+		Eventually(q.Stats).Should(Equal(queue.Stats{Sent: 2}))
+
+		tags := sent()
+		Expect(tags[len(tags)-2:]).To(Equal([]string{"a", "b"}))
+	})
+
+	When("a message is larger than the queue", func() {
+		BeforeEach(func() {
+			opts.MaxBytes = 10
+		})
+
+		It("returns ErrTooLarge", func() {
+			Expect(q.SendMessage("a", record)).To(MatchError(queue.ErrTooLarge))
+		})
+	})
+
+	When("the policy is Block", func() {
+		It("waits for space", func() {
+			fill()
+
+			done := make(chan error)
+			go func() {
+				done <- q.SendMessage("d", record)
+			}()
+
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			unblock()
+			Eventually(done).Should(Receive(BeNil()))
+			Eventually(sent).Should(Equal([]string{"a", "b", "c", "d"}))
+		})
+
+		When("BlockTimeout is set", func() {
+			BeforeEach(func() {
+				opts.BlockTimeout = 20 * time.Millisecond
+			})
+
+			It("gives up when it expires", func() {
+				fill()
+
+				Expect(q.SendMessage("d", record)).To(MatchError(queue.ErrFull))
+				Expect(q.Stats().TimedOut).To(Equal(uint64(1)))
+			})
+		})
+	})
+
+	When("the policy is DropNewest", func() {
+		BeforeEach(func() {
+			opts.Policy = queue.DropNewest
+		})
+
+		It("drops the message", func() {
+			fill()
+
+			Expect(q.SendMessage("d", record)).To(MatchError(queue.ErrFull))
+			Expect(q.Stats().DroppedNewest).To(Equal(uint64(1)))
+
+			unblock()
+			Eventually(sent).Should(Equal([]string{"a", "b", "c"}))
+		})
+	})
+
+	When("the policy is DropOldest", func() {
+		BeforeEach(func() {
+			opts.Policy = queue.DropOldest
+		})
+
+		It("drops the oldest queued message", func() {
+			fill()
+
+			send("d")
+			Expect(q.Stats().DroppedOldest).To(Equal(uint64(1)))
+
+			unblock()
+			Eventually(sent).Should(Equal([]string{"a", "c", "d"}))
+		})
+	})
+
+	When("the policy is SpillToDisk", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			opts.Policy = queue.SpillToDisk
+			opts.SpillDir = dir
+		})
+
+		It("spills messages and sends them in order", func() {
+			fill()
+
+			send("d", "e")
+
+			stats := q.Stats()
+			Expect(stats.Messages).To(Equal(2))
+			Expect(stats.Spilled).To(Equal(uint64(2)))
+			Expect(stats.SpillBytes).To(BeNumerically(">", 0))
+
+			unblock()
+			Eventually(sent).Should(Equal([]string{"a", "b", "c", "d", "e"}))
+			Expect(q.Stats().SpillBytes).To(BeZero())
+
+			// files are removed on the read after their last message
+			send("f", "g", "h")
+			Eventually(sent).Should(HaveLen(8))
+		})
+
+		It("resumes messages left on disk", func() {
+			dest.SendReturns(errors.New("nope"))
+			send("a")
+			Eventually(dest.SendCallCount).Should(BeNumerically(">", 0))
+			send("b", "c", "d", "e")
+			Expect(q.Disconnect()).To(Succeed())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.spill"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+
+			dest = &clientfakes.FakeMessageClient{}
+			opts.Destination = dest
+
+			q, err = queue.New(opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(q.Connect()).To(Succeed())
+
+			Eventually(sent).Should(Equal([]string{"d", "e"}))
+		})
+
+		When("the disk is full", func() {
+			BeforeEach(func() {
+				opts.MaxSpillBytes = 1
+			})
+
+			It("drops the message", func() {
+				fill()
+
+				Expect(q.SendMessage("d", record)).To(MatchError(queue.ErrFull))
+				Expect(q.Stats().DroppedNewest).To(Equal(uint64(1)))
+			})
+		})
+	})
+
+	When("the destination is a Client", func() {
+		var svr *fluenttest.Server
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{})
+
+			opts.Destination = client.New(client.ConnectionOptions{
+				Factory:    svr.ConnFactory(),
+				RequireAck: true,
+			})
+			opts.RequireAck = true
+		})
+
+		AfterEach(func() {
+			svr.Close()
+		})
+
+		It("sends the messages with acks", func() {
+			send("foo", "foo")
+
+			events, err := svr.WaitForEvents("foo", 2, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(events).To(HaveLen(2))
+			Expect(errs).To(BeEmpty())
+		})
+	})
+	When("the destination is a Client with a shared key", func() {
+		var (
+			svr *fluenttest.Server
+			fc  *client.Client
+		)
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{
+				SharedKey: []byte("thisisasharedkey"),
+				DropAcks:  true,
+			})
+
+			fc = client.New(client.ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: 50 * time.Millisecond,
+				AuthInfo:          client.AuthInfo{SharedKey: []byte("thisisasharedkey")},
+			})
+			opts.Destination = fc
+			opts.RequireAck = true
+		})
+
+		JustBeforeEach(func() {
+			Expect(fc.Handshake()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			svr.Close()
+		})
+
+		It("performs the handshake again before redelivering", func() {
+			send("foo")
+
+			Eventually(svr.Messages).ShouldNot(BeEmpty())
+			svr.SetDropAcks(false)
+
+			Eventually(func() uint64 { return q.Stats().Sent }, time.Second).Should(Equal(uint64(1)))
+		})
+	})
+})
@@ -0,0 +1,208 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	spillExt = ".spill"
+	// segmentSize is the size at which a spill file is closed and a new
+	// one started, so that files can be removed as they are sent.
+	segmentSize = 4 << 20
+)
+
+// spill stores messages in a directory as a sequence of files, each
+// holding msgpack-encoded messages back to back. Messages are read in the
+// order they were written. A file is removed once all of its messages
+// have been read and the next read is requested, so a message that was
+// read but not sent survives a crash.
+type spill struct {
+	dir string
+	seq uint64
+	// segments are the paths of the files, oldest first. The last one is
+	// being written if w is set, and the first one read if r is set.
+	segments []string
+
+	w     *os.File
+	wSize int64
+
+	r    *os.File
+	rd   *msgp.Reader
+	rOff int64
+
+	// size is the number of bytes written and not yet read.
+	size int64
+}
+
+// openSpill opens dir, creating it if necessary, and resumes the files
+// left in it.
+func openSpill(dir string) (*spill, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spill{dir: dir}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spillExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spillExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		s.segments = append(s.segments, filepath.Join(dir, name))
+		s.size += info.Size()
+
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+
+	// names are zero-padded, so they sort by sequence
+	sort.Strings(s.segments)
+
+	return s, nil
+}
+
+func (s *spill) write(msg []byte) error {
+	if s.w == nil {
+		s.seq++
+
+		path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.seq, spillExt))
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+
+		s.w, s.wSize = f, 0
+		s.segments = append(s.segments, path)
+	}
+
+	n, err := s.w.Write(msg)
+	s.wSize += int64(n)
+	s.size += int64(n)
+
+	if err == nil && s.wSize >= segmentSize {
+		err = s.closeWriter()
+	}
+
+	return err
+}
+
+func (s *spill) closeWriter() error {
+	err := s.w.Close()
+	s.w = nil
+
+	return err
+}
+
+// read returns the next message, or nil if there are none.
+func (s *spill) read() ([]byte, error) {
+	for len(s.segments) > 0 {
+		if s.r == nil {
+			// never read the file being written
+			if s.w != nil && len(s.segments) == 1 {
+				if err := s.closeWriter(); err != nil {
+					return nil, err
+				}
+			}
+
+			f, err := os.Open(s.segments[0])
+			if err != nil {
+				// skip it rather than fail every read
+				s.segments = s.segments[1:]
+				return nil, err
+			}
+
+			s.r, s.rd, s.rOff = f, msgp.NewReader(f), 0
+		}
+
+		var buf bytes.Buffer
+
+		n, err := s.rd.CopyNext(&buf)
+		if err == nil {
+			s.rOff += n
+			s.size -= n
+			return buf.Bytes(), nil
+		}
+
+		if err := s.r.Close(); err != nil {
+			return nil, err
+		}
+
+		path := s.segments[0]
+		s.r, s.rd, s.segments = nil, nil, s.segments[1:]
+
+		if err == io.EOF {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		// keep the rest of a corrupt file for inspection, but never
+		// resume it
+		if info, serr := os.Stat(path); serr == nil {
+			s.size -= info.Size() - s.rOff
+		}
+
+		if rerr := os.Rename(path, path+".bad"); rerr != nil {
+			return nil, rerr
+		}
+
+		return nil, fmt.Errorf("spill file %s is corrupt: %w", path, err)
+	}
+
+	s.size = 0
+
+	return nil, nil
+}
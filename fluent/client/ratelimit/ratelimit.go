@@ -0,0 +1,394 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package ratelimit provides a client.MessageClient that limits the events
+// and bytes sent per second, globally and per tag pattern, so that a noisy
+// component cannot flood a shared aggregator.
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	DefaultSummaryInterval = time.Minute
+	DefaultMaxSummaryTags  = 100
+)
+
+// ErrLimited is returned for a message dropped because it is over quota.
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Action decides what happens to a message that is over quota.
+type Action int
+
+const (
+	// Drop drops the message and returns ErrLimited.
+	Drop Action = iota
+	// Delay waits until the message is within quota, then sends it.
+	Delay
+)
+
+// Limit is a pair of token buckets, one counting events and one bytes.
+type Limit struct {
+	// Events and Bytes are the sustained rates per second. Zero means no
+	// limit.
+	Events float64
+	Bytes  float64
+	// EventBurst and ByteBurst are the most that can be sent at once after
+	// a quiet period. They default to one second at the sustained rate. A
+	// message larger than the burst is let through when the bucket is full.
+	EventBurst float64
+	ByteBurst  float64
+}
+
+// Rule limits the messages whose tag matches Pattern.
+type Rule struct {
+	// Pattern matches tags like the pattern of a Fluentd <match>
+	// directive: "*" matches one part of the tag, "**" matches zero or
+	// more parts, and other parts must be equal.
+	Pattern string
+	Limit
+}
+
+// Options configures a Client.
+type Options struct {
+	Client client.MessageClient
+	// Limit applies to every message.
+	Limit Limit
+	// Rules apply to the messages with a matching tag. Only the first
+	// matching rule applies, in addition to Limit.
+	Rules  []Rule
+	Action Action
+	// MaxDelay is the longest a message waits with the Delay action. A
+	// message that would wait longer is dropped. Zero means no limit.
+	MaxDelay time.Duration
+	// RequireAck must be set if the client requires acks, since it fails
+	// every message that has no chunk ID. The ID is given by Send, before
+	// the message waits for its quota. Summaries are chunked by the client.
+	RequireAck bool
+	// ChunkIDGenerator generates the chunk IDs given by Send.
+	// protocol.DefaultChunkIDGenerator is used if nil.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	// SummaryTag, if set, is the tag of a record sent through Client every
+	// SummaryInterval in which messages were dropped. The record holds
+	// the number of messages, events, and bytes dropped and the events
+	// dropped per tag. It is not limited.
+	SummaryTag string
+	// SummaryInterval defaults to DefaultSummaryInterval.
+	SummaryInterval time.Duration
+	// MaxSummaryTags is the most tags a summary counts the dropped events
+	// of. The events of further tags are counted together under
+	// "other_tags". Defaults to DefaultMaxSummaryTags.
+	MaxSummaryTags int
+}
+
+// Stats counts the messages that were over quota.
+type Stats struct {
+	DroppedMessages uint64
+	DroppedEvents   uint64
+	DroppedBytes    uint64
+	DelayedMessages uint64
+	// Delay is the total time that messages were delayed.
+	Delay time.Duration
+}
+
+type limiter struct {
+	events, bytes *bucket
+}
+
+func newLimiter(l Limit) limiter {
+	return limiter{
+		events: newBucket(l.Events, l.EventBurst),
+		bytes:  newBucket(l.Bytes, l.ByteBurst),
+	}
+}
+
+type rule struct {
+	pattern []string
+	limiter
+}
+
+// Client is a client.MessageClient that sends messages within quota to
+// another MessageClient. Messages are encoded to count their bytes, and
+// their tag and number of events are read from the encoding.
+type Client struct {
+	client.SendFunc
+	client          client.MessageClient
+	action          Action
+	maxDelay        time.Duration
+	requireAck      bool
+	chunkIDs        protocol.ChunkIDGenerator
+	summaryTag      string
+	summaryInterval time.Duration
+	maxSummaryTags  int
+
+	lock   sync.Mutex
+	global limiter
+	rules  []rule
+	stats  Stats
+	// dropped counts the events dropped per tag since the last summary,
+	// droppedOther those of the tags over maxSummaryTags, and summary the
+	// totals.
+	dropped      map[string]uint64
+	droppedOther uint64
+	summary      Stats
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+var _ client.MessageClient = (*Client)(nil)
+
+// New returns a Client configured by opts.
+func New(opts Options) *Client {
+	c := &Client{
+		client:          opts.Client,
+		action:          opts.Action,
+		maxDelay:        opts.MaxDelay,
+		requireAck:      opts.RequireAck,
+		chunkIDs:        opts.ChunkIDGenerator,
+		summaryTag:      opts.SummaryTag,
+		summaryInterval: opts.SummaryInterval,
+		maxSummaryTags:  opts.MaxSummaryTags,
+		global:          newLimiter(opts.Limit),
+		dropped:         map[string]uint64{},
+	}
+
+	c.SendFunc = c.Send
+
+	if c.summaryInterval <= 0 {
+		c.summaryInterval = DefaultSummaryInterval
+	}
+
+	if c.maxSummaryTags <= 0 {
+		c.maxSummaryTags = DefaultMaxSummaryTags
+	}
+
+	for _, r := range opts.Rules {
+		c.rules = append(c.rules, rule{
+			pattern: splitTag(r.Pattern),
+			limiter: newLimiter(r.Limit),
+		})
+	}
+
+	return c
+}
+
+// Connect connects the client and, if SummaryTag is set, starts sending
+// summaries.
+func (c *Client) Connect() error {
+	err := c.client.Connect()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.summaryTag) > 0 && c.stop == nil {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.summarize(c.stop, c.done)
+	}
+
+	return err
+}
+
+// Disconnect sends the pending summary, if any, and disconnects the
+// client.
+func (c *Client) Disconnect() error {
+	c.lock.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	return c.client.Disconnect()
+}
+
+func (c *Client) Reconnect() error {
+	return c.client.Reconnect()
+}
+
+// Stats returns the counters of the messages that were over quota.
+func (c *Client) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.stats
+}
+
+// Send encodes e and sends it once it is within quota.
+func (c *Client) Send(e protocol.ChunkEncoder) error {
+	bits, err := protocol.EncodeAcked(e, c.requireAck, c.chunkIDs)
+	if err != nil {
+		return err
+	}
+
+	if err := c.admit(bits); err != nil {
+		return err
+	}
+
+	return c.client.Send(protocol.RawMessage(bits))
+}
+
+// SendRaw sends raw once it is within quota. Messages that cannot be
+// decoded count as a single event without a tag.
+func (c *Client) SendRaw(raw []byte) error {
+	if err := c.admit(raw); err != nil {
+		return err
+	}
+
+	return c.client.SendRaw(raw)
+}
+
+// admit waits until the message is within quota, or returns ErrLimited.
+func (c *Client) admit(bits []byte) error {
+	tag, events := inspect(bits)
+	size := len(bits)
+
+	c.lock.Lock()
+
+	limiters := []limiter{c.global}
+
+	for _, r := range c.rules {
+		if matchTag(r.pattern, tag) {
+			limiters = append(limiters, r.limiter)
+			break
+		}
+	}
+
+	var (
+		now  = time.Now()
+		wait time.Duration
+	)
+
+	for _, l := range limiters {
+		wait = maxDuration(wait, l.events.wait(now, float64(events)))
+		wait = maxDuration(wait, l.bytes.wait(now, float64(size)))
+	}
+
+	if wait > 0 && (c.action == Drop || (c.maxDelay > 0 && wait > c.maxDelay)) {
+		c.stats.DroppedMessages++
+		c.stats.DroppedEvents += uint64(events)
+		c.stats.DroppedBytes += uint64(size)
+		c.countDropped(tag, events)
+		c.lock.Unlock()
+
+		return ErrLimited
+	}
+
+	for _, l := range limiters {
+		l.events.take(float64(events))
+		l.bytes.take(float64(size))
+	}
+
+	if wait > 0 {
+		c.stats.DelayedMessages++
+		c.stats.Delay += wait
+	}
+
+	c.lock.Unlock()
+
+	time.Sleep(wait)
+
+	return nil
+}
+
+// countDropped counts the dropped events of tag for the next summary, if
+// summaries are sent. c.lock must be held.
+func (c *Client) countDropped(tag string, events int) {
+	if len(c.summaryTag) == 0 {
+		return
+	}
+
+	if _, ok := c.dropped[tag]; ok || len(c.dropped) < c.maxSummaryTags {
+		c.dropped[tag] += uint64(events)
+	} else {
+		c.droppedOther += uint64(events)
+	}
+}
+
+func (c *Client) summarize(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(c.summaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sendSummary()
+		case <-stop:
+			c.sendSummary()
+			return
+		}
+	}
+}
+
+func (c *Client) sendSummary() {
+	c.lock.Lock()
+
+	if len(c.dropped) == 0 {
+		c.lock.Unlock()
+		return
+	}
+
+	tags := make(map[string]interface{}, len(c.dropped))
+	for tag, n := range c.dropped {
+		tags[tag] = n
+	}
+
+	record := map[string]interface{}{
+		"dropped_messages": c.stats.DroppedMessages - c.summary.DroppedMessages,
+		"dropped_events":   c.stats.DroppedEvents - c.summary.DroppedEvents,
+		"dropped_bytes":    c.stats.DroppedBytes - c.summary.DroppedBytes,
+		"tags":             tags,
+	}
+
+	if c.droppedOther > 0 {
+		record["other_tags"] = c.droppedOther
+	}
+
+	c.dropped = map[string]uint64{}
+	c.droppedOther = 0
+	c.summary = c.stats
+	c.lock.Unlock()
+
+	// there is nobody to return the error to; the counts are still in
+	// Stats
+	_ = c.client.SendMessageExt(c.summaryTag, record)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if b > a {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,311 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ratelimit_test
+
+import (
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/ratelimit"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		mc      *clientfakes.FakeMessageClient
+		opts    ratelimit.Options
+		c       *ratelimit.Client
+		record  = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+		entries = protocol.EntryList{
+			{Timestamp: protocol.EventTimeNow(), Record: record},
+			{Timestamp: protocol.EventTimeNow(), Record: record},
+		}
+	)
+
+	BeforeEach(func() {
+		mc = &clientfakes.FakeMessageClient{}
+		opts = ratelimit.Options{
+			Client: mc,
+		}
+	})
+
+	JustBeforeEach(func() {
+		c = ratelimit.New(opts)
+		Expect(c.Connect()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(c.Disconnect()).To(Succeed())
+	})
+
+	It("sends everything without limits", func() {
+		for i := 0; i < 100; i++ {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+		}
+
+		Expect(mc.SendCallCount()).To(Equal(100))
+		Expect(c.Stats()).To(Equal(ratelimit.Stats{}))
+	})
+
+	When("the events are limited", func() {
+		BeforeEach(func() {
+			opts.Limit = ratelimit.Limit{Events: 10, EventBurst: 3}
+		})
+
+		It("drops the events over the burst", func() {
+			for i := 0; i < 3; i++ {
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+			}
+
+			Expect(c.SendMessage("foo", record)).To(MatchError(ratelimit.ErrLimited))
+			Expect(mc.SendCallCount()).To(Equal(3))
+
+			stats := c.Stats()
+			Expect(stats.DroppedMessages).To(Equal(uint64(1)))
+			Expect(stats.DroppedEvents).To(Equal(uint64(1)))
+			Expect(stats.DroppedBytes).To(BeNumerically(">", 0))
+		})
+
+		It("refills at the sustained rate", func() {
+			for i := 0; i < 3; i++ {
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+			}
+
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+			Eventually(func() error {
+				return c.SendMessage("foo", record)
+			}).WithTimeout(time.Second).WithPolling(20 * time.Millisecond).Should(Succeed())
+		})
+
+		DescribeTable("counts the events of every mode",
+			func(send func() error) {
+				Expect(send()).To(Succeed())
+				Expect(send()).To(MatchError(ratelimit.ErrLimited))
+				Expect(c.Stats().DroppedEvents).To(Equal(uint64(len(entries))))
+			},
+			Entry("Forward", func() error { return c.SendForward("foo", entries) }),
+			Entry("PackedForward", func() error { return c.SendPacked("foo", entries) }),
+			Entry("CompressedPackedForward", func() error { return c.SendCompressed("foo", entries) }),
+		)
+
+		It("counts the events of compressed messages from their size option", func() {
+			msg, err := protocol.NewCompressedPackedForwardMessage("foo", entries)
+			Expect(err).ToNot(HaveOccurred())
+
+			size := 5
+			msg.Options.Size = &size
+
+			Expect(c.Send(msg)).To(Succeed())
+			Expect(c.Send(msg)).To(MatchError(ratelimit.ErrLimited))
+			Expect(c.Stats().DroppedEvents).To(Equal(uint64(size)))
+		})
+
+		When("the action is Delay", func() {
+			BeforeEach(func() {
+				opts.Limit.EventBurst = 1
+				opts.Action = ratelimit.Delay
+			})
+
+			It("waits for the quota", func() {
+				start := time.Now()
+
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+
+				Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+				Expect(mc.SendCallCount()).To(Equal(2))
+
+				stats := c.Stats()
+				Expect(stats.DelayedMessages).To(Equal(uint64(1)))
+				Expect(stats.Delay).To(BeNumerically("~", 100*time.Millisecond, 10*time.Millisecond))
+			})
+
+			When("MaxDelay is set", func() {
+				BeforeEach(func() {
+					opts.MaxDelay = 10 * time.Millisecond
+				})
+
+				It("drops messages that would wait longer", func() {
+					Expect(c.SendMessage("foo", record)).To(Succeed())
+					Expect(c.SendMessage("foo", record)).To(MatchError(ratelimit.ErrLimited))
+				})
+			})
+		})
+	})
+
+	When("the bytes are limited", func() {
+		BeforeEach(func() {
+			opts.Limit = ratelimit.Limit{Bytes: 10, ByteBurst: 50}
+		})
+
+		It("lets a message larger than the burst through when the bucket is full", func() {
+			Expect(c.SendForward("foo", entries)).To(Succeed())
+			Expect(c.SendMessage("foo", record)).To(MatchError(ratelimit.ErrLimited))
+		})
+
+		It("limits raw messages too", func() {
+			raw, err := protocol.NewMessage("foo", record).MarshalMsg(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(c.SendRaw(raw)).To(Succeed())
+			Expect(c.SendRaw(raw)).To(MatchError(ratelimit.ErrLimited))
+			Expect(mc.SendRawCallCount()).To(Equal(1))
+		})
+	})
+
+	When("a rule matches the tag", func() {
+		BeforeEach(func() {
+			opts.Rules = []ratelimit.Rule{{
+				Pattern: "noisy.**",
+				Limit:   ratelimit.Limit{Events: 1},
+			}}
+		})
+
+		It("limits the matching tags only", func() {
+			Expect(c.SendMessage("noisy.db", record)).To(Succeed())
+			Expect(c.SendMessage("noisy.http", record)).To(MatchError(ratelimit.ErrLimited))
+
+			for i := 0; i < 10; i++ {
+				Expect(c.SendMessage("quiet", record)).To(Succeed())
+			}
+		})
+	})
+
+	DescribeTable("matching tags",
+		func(pattern, tag string, match bool) {
+			c = ratelimit.New(ratelimit.Options{
+				Client: mc,
+				Rules: []ratelimit.Rule{{
+					Pattern: pattern,
+					Limit:   ratelimit.Limit{Events: 1},
+				}},
+			})
+
+			Expect(c.SendMessage(tag, record)).To(Succeed())
+
+			if match {
+				Expect(c.SendMessage(tag, record)).To(MatchError(ratelimit.ErrLimited))
+			} else {
+				Expect(c.SendMessage(tag, record)).To(Succeed())
+			}
+		},
+		Entry("equal", "a.b", "a.b", true),
+		Entry("different", "a.b", "a.c", false),
+		Entry("star", "a.*", "a.b", true),
+		Entry("star with more parts", "a.*", "a.b.c", false),
+		Entry("star without a part", "a.*", "a", false),
+		Entry("double star", "a.**", "a.b.c", true),
+		Entry("double star without parts", "a.**", "a", true),
+		Entry("double star in the middle", "a.**.z", "a.b.c.z", true),
+		Entry("double star alone", "**", "anything.at.all", true),
+	)
+
+	When("SummaryTag is set", func() {
+		BeforeEach(func() {
+			opts.Limit = ratelimit.Limit{Events: 1}
+			opts.SummaryTag = "ratelimit"
+			opts.SummaryInterval = 20 * time.Millisecond
+		})
+
+		It("sends a summary of the dropped events", func() {
+			Expect(c.SendMessage("a", record)).To(Succeed())
+			Expect(c.SendForward("a", entries)).ToNot(Succeed())
+			Expect(c.SendMessage("b", record)).ToNot(Succeed())
+
+			Eventually(mc.SendMessageExtCallCount).Should(Equal(1))
+
+			tag, summary := mc.SendMessageExtArgsForCall(0)
+			Expect(tag).To(Equal("ratelimit"))
+			Expect(summary).To(Equal(map[string]interface{}{
+				"dropped_messages": uint64(2),
+				"dropped_events":   uint64(3),
+				"dropped_bytes":    c.Stats().DroppedBytes,
+				"tags": map[string]interface{}{
+					"a": uint64(2),
+					"b": uint64(1),
+				},
+			}))
+
+			Consistently(mc.SendMessageExtCallCount, 60*time.Millisecond).Should(Equal(1))
+		})
+
+		When("more tags than MaxSummaryTags drop events", func() {
+			BeforeEach(func() {
+				opts.MaxSummaryTags = 1
+			})
+
+			It("counts the events of the others together", func() {
+				Expect(c.SendMessage("a", record)).To(Succeed())
+				Expect(c.SendMessage("a", record)).ToNot(Succeed())
+				Expect(c.SendMessage("b", record)).ToNot(Succeed())
+				Expect(c.SendForward("c", entries)).ToNot(Succeed())
+				Expect(c.Disconnect()).To(Succeed())
+
+				Expect(mc.SendMessageExtCallCount()).To(Equal(1))
+
+				_, summary := mc.SendMessageExtArgsForCall(0)
+				Expect(summary).To(HaveKeyWithValue("tags", map[string]interface{}{"a": uint64(1)}))
+				Expect(summary).To(HaveKeyWithValue("other_tags", uint64(3)))
+			})
+		})
+
+		It("sends the pending summary on Disconnect", func() {
+			Expect(c.SendMessage("a", record)).To(Succeed())
+			Expect(c.SendMessage("a", record)).ToNot(Succeed())
+			Expect(c.Disconnect()).To(Succeed())
+
+			Expect(mc.SendMessageExtCallCount()).To(Equal(1))
+		})
+	})
+
+	When("the client requires acks", func() {
+		var svr *fluenttest.Server
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{})
+
+			opts.Client = client.New(client.ConnectionOptions{
+				Factory:    svr.ConnFactory(),
+				RequireAck: true,
+			})
+			opts.RequireAck = true
+		})
+
+		AfterEach(func() {
+			svr.Close()
+		})
+
+		It("sends the messages with a chunk", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			_, err := svr.WaitForEvents("foo", 1, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,172 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ratelimit
+
+import (
+	"strings"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func splitTag(tag string) []string {
+	return strings.Split(tag, ".")
+}
+
+// matchTag reports whether the parts of a tag match the parts of a
+// pattern.
+func matchTag(pattern []string, tag string) bool {
+	return matchParts(pattern, splitTag(tag))
+}
+
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "**":
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case "*":
+			if len(parts) == 0 {
+				return false
+			}
+		default:
+			if len(parts) == 0 || parts[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern, parts = pattern[1:], parts[1:]
+	}
+
+	return len(parts) == 0
+}
+
+// inspect returns the tag and number of events of an encoded message,
+// without decoding the records. The events of a PackedForward or
+// CompressedPackedForward message are counted from its size option; only
+// compressed messages without one are decompressed. A message that cannot
+// be read counts as a single event without a tag.
+func inspect(bits []byte) (string, int) {
+	mode := protocol.PeekMode(bits)
+	if mode == protocol.ModeUnknown {
+		return "", 1
+	}
+
+	sz, b, err := msgp.ReadArrayHeaderBytes(bits)
+	if err != nil {
+		return "", 1
+	}
+
+	tag, b, err := msgp.ReadStringBytes(b)
+	if err != nil {
+		return "", 1
+	}
+
+	switch mode {
+	case protocol.ModeForward:
+		if n, _, err := msgp.ReadArrayHeaderBytes(b); err == nil {
+			return tag, int(n)
+		}
+	case protocol.ModePackedForward:
+		var entries []byte
+
+		if msgp.NextType(b) == msgp.StrType {
+			entries, b, err = msgp.ReadStringZC(b)
+		} else {
+			entries, b, err = msgp.ReadBytesZC(b)
+		}
+
+		if err != nil {
+			break
+		}
+
+		if n, ok := sizeOption(sz, b); ok {
+			return tag, n
+		}
+
+		n := 0
+		for len(entries) > 0 {
+			if entries, err = msgp.Skip(entries); err != nil {
+				break
+			}
+
+			n++
+		}
+
+		return tag, n
+	case protocol.ModeCompressedPackedForward:
+		if b, err = msgp.Skip(b); err != nil {
+			break
+		}
+
+		if n, ok := sizeOption(sz, b); ok {
+			return tag, n
+		}
+
+		var dm protocol.DecodedMessage
+		if _, err := dm.UnmarshalMsg(bits); err == nil {
+			return tag, len(dm.Entries)
+		}
+	}
+
+	return tag, 1
+}
+
+// sizeOption returns the size option of a message of sz elements, whose
+// options, if any, are at the start of b.
+func sizeOption(sz uint32, b []byte) (int, bool) {
+	if sz < 3 {
+		return 0, false
+	}
+
+	n, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return 0, false
+	}
+
+	for ; n > 0; n-- {
+		var key []byte
+
+		if key, b, err = msgp.ReadMapKeyZC(b); err != nil {
+			return 0, false
+		}
+
+		if string(key) == "size" {
+			size, _, err := msgp.ReadIntBytes(b)
+			return size, err == nil && size >= 0
+		}
+
+		if b, err = msgp.Skip(b); err != nil {
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,234 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package retry provides a client.MessageClient that resends a message
+// whose send or ack failed, with the same bytes and chunk ID, so that a
+// server that received the first copy can recognize the second one, for
+// example with a server.Deduplicator.
+package retry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = 100 * time.Millisecond
+	DefaultMaxBackoff  = 5 * time.Second
+)
+
+// Options configures a Client.
+type Options struct {
+	// Client receives the first attempt of every message. It should
+	// require acks, otherwise only errors writing the message are retried.
+	Client client.MessageClient
+	// Fallbacks, if set, receive the resends in turn with Client, for
+	// example other servers that forward to the same destination.
+	Fallbacks []client.MessageClient
+	// MaxAttempts is the number of sends of a message, including the
+	// first one. Defaults to DefaultMaxAttempts.
+	MaxAttempts int
+	// Backoff is the wait before the first resend. It doubles for every
+	// resend, up to MaxBackoff. They default to DefaultBackoff and
+	// DefaultMaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable decides which errors are retried. By default, all are.
+	Retryable func(error) bool
+	// OnRetry, if set, is called before every resend with the chunk ID,
+	// the number of the attempt about to be made, and the error of the
+	// previous one.
+	OnRetry func(chunk string, attempt int, err error)
+	// Metrics, if set, is told of every resend.
+	Metrics client.Metrics
+	// ChunkIDGenerator generates the chunk IDs of messages that have none.
+	// protocol.DefaultChunkIDGenerator is used if nil. With a
+	// protocol.ContentHashChunkIDGenerator, a message sent again after a
+	// restart also keeps its chunk ID.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+}
+
+// Error is returned when every attempt to send a message failed.
+type Error struct {
+	Chunk    string
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("chunk %s failed after %d attempts: %v", e.Chunk, e.Attempts, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Client is a client.MessageClient that gives every message a chunk ID,
+// encodes it once, and sends the same bytes again when an attempt fails,
+// after reconnecting the client that receives the resend.
+type Client struct {
+	client.SendFunc
+	clients     []client.MessageClient
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	retryable   func(error) bool
+	onRetry     func(chunk string, attempt int, err error)
+	metrics     client.Metrics
+	chunkIDs    protocol.ChunkIDGenerator
+}
+
+var _ client.MessageClient = (*Client)(nil)
+
+// New returns a Client configured by opts.
+func New(opts Options) *Client {
+	c := &Client{
+		clients:     append([]client.MessageClient{opts.Client}, opts.Fallbacks...),
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+		maxBackoff:  opts.MaxBackoff,
+		retryable:   opts.Retryable,
+		onRetry:     opts.OnRetry,
+		metrics:     opts.Metrics,
+		chunkIDs:    opts.ChunkIDGenerator,
+	}
+
+	c.SendFunc = c.Send
+
+	if c.maxAttempts <= 0 {
+		c.maxAttempts = DefaultMaxAttempts
+	}
+
+	if c.backoff <= 0 {
+		c.backoff = DefaultBackoff
+	}
+
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = DefaultMaxBackoff
+	}
+
+	return c
+}
+
+// Connect connects Client and the fallbacks, and returns the error of
+// Client. Fallbacks that fail to connect are reconnected before they are
+// used.
+func (c *Client) Connect() error {
+	err := c.clients[0].Connect()
+
+	for _, fb := range c.clients[1:] {
+		_ = fb.Connect()
+	}
+
+	return err
+}
+
+// Disconnect disconnects Client and the fallbacks, and returns the first
+// error.
+func (c *Client) Disconnect() error {
+	var err error
+
+	for _, mc := range c.clients {
+		if derr := mc.Disconnect(); err == nil {
+			err = derr
+		}
+	}
+
+	return err
+}
+
+// Reconnect reconnects Client.
+func (c *Client) Reconnect() error {
+	return c.clients[0].Reconnect()
+}
+
+// Send gives e a chunk ID, if it has none, and sends its encoding until
+// an attempt succeeds.
+func (c *Client) Send(e protocol.ChunkEncoder) error {
+	chunk, bits, err := protocol.EncodeChunked(e, c.chunkIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.retry(chunk, func(mc client.MessageClient) error {
+		return mc.Send(protocol.RawMessage(bits))
+	})
+}
+
+// SendRaw sends raw until an attempt succeeds. raw should carry a chunk
+// option, otherwise the server cannot recognize a resend.
+func (c *Client) SendRaw(raw []byte) error {
+	chunk, _ := protocol.GetChunk(raw)
+
+	return c.retry(chunk, func(mc client.MessageClient) error {
+		return mc.SendRaw(raw)
+	})
+}
+
+func (c *Client) retry(chunk string, send func(client.MessageClient) error) error {
+	var (
+		err     error
+		backoff = c.backoff
+	)
+
+	for attempt := 1; attempt <= c.maxAttempts; attempt++ {
+		mc := c.clients[(attempt-1)%len(c.clients)]
+
+		if attempt > 1 {
+			if c.metrics != nil {
+				c.metrics.ObserveRetry(chunk, attempt, err)
+			}
+
+			if c.onRetry != nil {
+				c.onRetry(chunk, attempt, err)
+			}
+
+			time.Sleep(backoff)
+
+			if backoff *= 2; backoff > c.maxBackoff {
+				backoff = c.maxBackoff
+			}
+
+			// after an ack timeout, the state of the connection is unknown
+			if err = client.ReconnectAndHandshake(mc); err != nil {
+				continue
+			}
+		}
+
+		if err = send(mc); err == nil {
+			return nil
+		}
+
+		if c.retryable != nil && !c.retryable(err) {
+			return &Error{Chunk: chunk, Attempts: attempt, Err: err}
+		}
+	}
+
+	return &Error{Chunk: chunk, Attempts: c.maxAttempts, Err: err}
+}
@@ -0,0 +1,289 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package retry_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/retry"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		primary  *clientfakes.FakeMessageClient
+		fallback *clientfakes.FakeMessageClient
+		opts     retry.Options
+		c        *retry.Client
+		errDown  = errors.New("down")
+		record   = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	sent := func(mc *clientfakes.FakeMessageClient, i int) []byte {
+		bits, ok := mc.SendArgsForCall(i).(protocol.RawMessage)
+		ExpectWithOffset(1, ok).To(BeTrue())
+
+		return bits
+	}
+
+	BeforeEach(func() {
+		primary = &clientfakes.FakeMessageClient{}
+		fallback = &clientfakes.FakeMessageClient{}
+
+		opts = retry.Options{
+			Client:  primary,
+			Backoff: time.Millisecond,
+		}
+	})
+
+	JustBeforeEach(func() {
+		c = retry.New(opts)
+		Expect(c.Connect()).To(Succeed())
+	})
+
+	It("sends once when the first attempt succeeds", func() {
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+		Expect(primary.SendCallCount()).To(Equal(1))
+		Expect(primary.ReconnectCallCount()).To(BeZero())
+
+		chunk, err := protocol.GetChunk(sent(primary, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(chunk).ToNot(BeEmpty())
+	})
+
+	It("resends the same bytes after a failure", func() {
+		primary.SendReturnsOnCall(0, errDown)
+
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+		Expect(primary.SendCallCount()).To(Equal(2))
+		Expect(primary.ReconnectCallCount()).To(Equal(1))
+		Expect(sent(primary, 1)).To(Equal(sent(primary, 0)))
+	})
+
+	It("keeps the chunk ID of the message", func() {
+		msg := &protocol.Message{
+			Tag:     "foo",
+			Record:  record,
+			Options: &protocol.MessageOptions{Chunk: "Z2F3YWlu"},
+		}
+
+		Expect(c.Send(msg)).To(Succeed())
+
+		chunk, err := protocol.GetChunk(sent(primary, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(chunk).To(Equal("Z2F3YWlu"))
+	})
+
+	When("ChunkIDGenerator is set", func() {
+		BeforeEach(func() {
+			opts.ChunkIDGenerator = protocol.ContentHashChunkIDGenerator{}
+		})
+
+		It("gives the same message the same chunk ID", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			chunk, err := protocol.GetChunk(sent(primary, 0))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protocol.GetChunk(sent(primary, 1))).To(Equal(chunk))
+		})
+	})
+
+	It("returns an Error after MaxAttempts", func() {
+		primary.SendReturns(errDown)
+
+		err := c.SendMessage("foo", record)
+
+		var rerr *retry.Error
+		Expect(errors.As(err, &rerr)).To(BeTrue())
+		Expect(rerr.Attempts).To(Equal(retry.DefaultMaxAttempts))
+		Expect(rerr.Chunk).ToNot(BeEmpty())
+		Expect(err).To(MatchError(errDown))
+		Expect(primary.SendCallCount()).To(Equal(retry.DefaultMaxAttempts))
+	})
+
+	It("counts a failed reconnect as a failed attempt", func() {
+		primary.SendReturns(errDown)
+		primary.ReconnectReturns(errors.New("unreachable"))
+
+		Expect(c.SendMessage("foo", record)).To(MatchError(ContainSubstring("unreachable")))
+		Expect(primary.SendCallCount()).To(Equal(1))
+		Expect(primary.ReconnectCallCount()).To(Equal(2))
+	})
+
+	It("resends raw messages unchanged", func() {
+		primary.SendRawReturnsOnCall(0, errDown)
+
+		raw := []byte{0x92, 0xa3, 'f', 'o', 'o', 0x90}
+		Expect(c.SendRaw(raw)).To(Succeed())
+		Expect(primary.SendRawCallCount()).To(Equal(2))
+		Expect(primary.SendRawArgsForCall(1)).To(Equal(raw))
+	})
+
+	When("there are fallbacks", func() {
+		BeforeEach(func() {
+			opts.Fallbacks = []client.MessageClient{fallback}
+		})
+
+		It("connects and disconnects them", func() {
+			Expect(fallback.ConnectCallCount()).To(Equal(1))
+			Expect(c.Disconnect()).To(Succeed())
+			Expect(primary.DisconnectCallCount()).To(Equal(1))
+			Expect(fallback.DisconnectCallCount()).To(Equal(1))
+		})
+
+		It("alternates the resends between the clients", func() {
+			primary.SendReturns(errDown)
+			fallback.SendReturns(errDown)
+
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+			Expect(primary.SendCallCount()).To(Equal(2))
+			Expect(fallback.SendCallCount()).To(Equal(1))
+			Expect(sent(fallback, 0)).To(Equal(sent(primary, 0)))
+		})
+	})
+
+	When("Retryable is set", func() {
+		BeforeEach(func() {
+			opts.Retryable = func(err error) bool {
+				return !errors.Is(err, errDown)
+			}
+		})
+
+		It("does not retry other errors", func() {
+			primary.SendReturns(errDown)
+
+			var rerr *retry.Error
+			Expect(errors.As(c.SendMessage("foo", record), &rerr)).To(BeTrue())
+			Expect(rerr.Attempts).To(Equal(1))
+			Expect(primary.SendCallCount()).To(Equal(1))
+		})
+	})
+
+	When("OnRetry is set", func() {
+		var attempts []int
+
+		BeforeEach(func() {
+			attempts = nil
+			opts.OnRetry = func(chunk string, attempt int, err error) {
+				Expect(chunk).ToNot(BeEmpty())
+				Expect(err).To(MatchError(errDown))
+				attempts = append(attempts, attempt)
+			}
+		})
+
+		It("is called before every resend", func() {
+			primary.SendReturns(errDown)
+
+			Expect(c.SendMessage("foo", record)).ToNot(Succeed())
+			Expect(attempts).To(Equal([]int{2, 3}))
+		})
+	})
+
+	When("an ack times out", func() {
+		var svr *fluenttest.Server
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{AckDelay: 200 * time.Millisecond})
+			DeferCleanup(svr.Close)
+
+			fc := client.New(client.ConnectionOptions{
+				Factory:    svr.ConnFactory(),
+				RequireAck: true,
+			})
+			fc.Timeout = 50 * time.Millisecond
+			opts.Client = fc
+		})
+
+		AfterEach(func() {
+			_ = c.Disconnect()
+		})
+
+		It("resends the message with the same chunk ID", func() {
+			// the first ack is late, the second is not
+			go func() {
+				defer GinkgoRecover()
+				Eventually(svr.Messages).ShouldNot(BeEmpty())
+				svr.SetAckDelay(0)
+			}()
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			msgs := svr.Messages()
+			Expect(msgs).To(HaveLen(2))
+			Expect(msgs[0].Options.Chunk).ToNot(BeEmpty())
+			Expect(msgs[1].Options.Chunk).To(Equal(msgs[0].Options.Chunk))
+			Expect(msgs[1].Raw).To(Equal(msgs[0].Raw))
+		})
+	})
+
+	When("the server requires a handshake", func() {
+		var (
+			svr *fluenttest.Server
+			fc  *client.Client
+		)
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{
+				SharedKey: []byte("thisisasharedkey"),
+				DropAcks:  true,
+			})
+			DeferCleanup(svr.Close)
+
+			fc = client.New(client.ConnectionOptions{
+				Factory:    svr.ConnFactory(),
+				RequireAck: true,
+				AuthInfo:   client.AuthInfo{SharedKey: []byte("thisisasharedkey")},
+			})
+			fc.Timeout = 50 * time.Millisecond
+			opts.Client = fc
+		})
+
+		JustBeforeEach(func() {
+			Expect(fc.Handshake()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			_ = c.Disconnect()
+		})
+
+		It("performs it again before resending", func() {
+			go func() {
+				defer GinkgoRecover()
+				Eventually(svr.Messages).ShouldNot(BeEmpty())
+				svr.SetDropAcks(false)
+			}()
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(svr.Events("foo")).ToNot(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,329 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package secondary provides a client.MessageClient that falls back to a
+// secondary destination when the primary destination fails, like the
+// <secondary> section of a Fluentd output.
+package secondary
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/breaker"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+// Path identifies the destination that accepted a message.
+type Path int
+
+const (
+	// PathPrimary means the primary accepted the message.
+	PathPrimary Path = iota
+	// PathSecondary means the primary failed and the secondary accepted
+	// the message.
+	PathSecondary
+	// PathNone means both destinations failed.
+	PathNone
+)
+
+func (p Path) String() string {
+	switch p {
+	case PathPrimary:
+		return "primary"
+	case PathSecondary:
+		return "secondary"
+	case PathNone:
+		return "none"
+	}
+
+	return fmt.Sprintf("Path(%d)", int(p))
+}
+
+// Route records the path a message took.
+type Route struct {
+	// Chunk is the chunk option of the message, or empty if it has none.
+	Chunk string
+	Path  Path
+	// Attempts is the number of sends to the primary, including retries.
+	Attempts int
+	// PrimaryErr is the last error from the primary, and SecondaryErr the
+	// error from the secondary. Both are nil for PathPrimary.
+	PrimaryErr   error
+	SecondaryErr error
+}
+
+// Error is returned when both destinations fail.
+type Error struct {
+	Primary   error
+	Secondary error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("primary: %v; secondary: %v", e.Primary, e.Secondary)
+}
+
+// Is reports whether the error of either destination matches target.
+func (e *Error) Is(target error) bool {
+	return client.IsAny(target, e.Primary, e.Secondary)
+}
+
+// As finds the first error of the destinations that matches target.
+func (e *Error) As(target interface{}) bool {
+	return client.AsAny(target, e.Primary, e.Secondary)
+}
+
+// Stats counts the messages sent on each path.
+type Stats struct {
+	Primary   uint64
+	Secondary uint64
+	None      uint64
+}
+
+// Options configures a Client.
+type Options struct {
+	Primary   client.MessageClient
+	Secondary client.MessageClient
+	// Retries is the number of times a failed send is retried on the
+	// primary, after reconnecting it, before the message is routed to the
+	// secondary.
+	Retries int
+	// RetryInterval is the time to wait before each retry.
+	RetryInterval time.Duration
+	// Retryable decides which errors of the primary are retried. By
+	// default, all are. Messages that fail with other errors go straight
+	// to the secondary, and so do those refused by an open
+	// breaker.Breaker, whatever Retryable returns.
+	Retryable func(error) bool
+	// RequireAck must be set if the primary or the secondary requires
+	// acks, since such a client fails every message that has no chunk ID.
+	// A message keeps the same ID on both paths and across retries.
+	RequireAck bool
+	// ChunkIDGenerator generates the chunk IDs of messages sent with
+	// RequireAck. protocol.DefaultChunkIDGenerator is used if nil; a
+	// protocol.ContentHashChunkIDGenerator lets a server drop a message
+	// that it received through both paths.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	// OnRoute, if set, is called with the route of every message.
+	OnRoute func(Route)
+	// Metrics, if set, is told of every retry on the primary.
+	Metrics client.Metrics
+}
+
+// Client is a client.MessageClient that sends every message to a primary
+// destination and, once the primary has failed and exhausted its retries
+// or its circuit is open, to a secondary destination such as a
+// filesink.Sink.
+//
+// Attempts to send to the primary are serialized, but the waits between
+// retries are not. After a failure, the primary is reconnected before it
+// is sent the next message.
+type Client struct {
+	client.SendFunc
+	primary       client.MessageClient
+	secondary     client.MessageClient
+	retries       int
+	retryInterval time.Duration
+	retryable     func(error) bool
+	requireAck    bool
+	chunkIDs      protocol.ChunkIDGenerator
+	onRoute       func(Route)
+	metrics       client.Metrics
+
+	primaryLock sync.Mutex
+	// primaryDown is set when the last send to the primary failed. It is
+	// guarded by primaryLock.
+	primaryDown bool
+
+	stats [PathNone + 1]uint64
+}
+
+var _ client.MessageClient = (*Client)(nil)
+
+// New returns a Client configured by opts.
+func New(opts Options) *Client {
+	c := &Client{
+		primary:       opts.Primary,
+		secondary:     opts.Secondary,
+		retries:       opts.Retries,
+		retryInterval: opts.RetryInterval,
+		retryable:     opts.Retryable,
+		requireAck:    opts.RequireAck,
+		chunkIDs:      opts.ChunkIDGenerator,
+		onRoute:       opts.OnRoute,
+		metrics:       opts.Metrics,
+	}
+
+	c.SendFunc = c.Send
+
+	return c
+}
+
+// Connect connects both destinations. A primary that fails to connect is
+// reconnected on the first send, so only an error from the secondary is
+// returned.
+func (c *Client) Connect() error {
+	c.primaryLock.Lock()
+	c.primaryDown = c.primary.Connect() != nil
+	c.primaryLock.Unlock()
+
+	return c.secondary.Connect()
+}
+
+// Disconnect disconnects both destinations and returns the first error.
+func (c *Client) Disconnect() error {
+	perr := c.primary.Disconnect()
+	serr := c.secondary.Disconnect()
+
+	if perr != nil {
+		return perr
+	}
+
+	return serr
+}
+
+// Reconnect reconnects both destinations. Like Connect, it only returns
+// an error from the secondary.
+func (c *Client) Reconnect() error {
+	c.primaryLock.Lock()
+	c.primaryDown = client.ReconnectAndHandshake(c.primary) != nil
+	c.primaryLock.Unlock()
+
+	return client.ReconnectAndHandshake(c.secondary)
+}
+
+// Stats returns the number of messages sent on each path.
+func (c *Client) Stats() Stats {
+	return Stats{
+		Primary:   atomic.LoadUint64(&c.stats[PathPrimary]),
+		Secondary: atomic.LoadUint64(&c.stats[PathSecondary]),
+		None:      atomic.LoadUint64(&c.stats[PathNone]),
+	}
+}
+
+// Send encodes e once and sends the same bytes to the primary and, if
+// necessary, the secondary.
+func (c *Client) Send(e protocol.ChunkEncoder) error {
+	bits, err := protocol.EncodeAcked(e, c.requireAck, c.chunkIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.route(bits, func(mc client.MessageClient) error {
+		return mc.Send(protocol.RawMessage(bits))
+	})
+}
+
+func (c *Client) SendRaw(raw []byte) error {
+	return c.route(raw, func(mc client.MessageClient) error {
+		return mc.SendRaw(raw)
+	})
+}
+
+func (c *Client) route(bits []byte, send func(client.MessageClient) error) error {
+	r := Route{Path: PathPrimary}
+	r.Chunk, _ = protocol.GetChunk(bits)
+
+	r.Attempts, r.PrimaryErr = c.sendPrimary(r.Chunk, send)
+	if r.PrimaryErr != nil {
+		r.Path = PathSecondary
+
+		if r.SecondaryErr = send(c.secondary); r.SecondaryErr != nil {
+			r.Path = PathNone
+		}
+	}
+
+	atomic.AddUint64(&c.stats[r.Path], 1)
+
+	if c.onRoute != nil {
+		c.onRoute(r)
+	}
+
+	if r.Path == PathNone {
+		return &Error{Primary: r.PrimaryErr, Secondary: r.SecondaryErr}
+	}
+
+	return nil
+}
+
+func (c *Client) sendPrimary(chunk string, send func(client.MessageClient) error) (int, error) {
+	var (
+		attempts int
+		err      error
+	)
+
+	for attempts <= c.retries {
+		if attempts > 0 {
+			if c.metrics != nil {
+				c.metrics.ObserveRetry(chunk, attempts+1, err)
+			}
+
+			if c.retryInterval > 0 {
+				time.Sleep(c.retryInterval)
+			}
+		}
+
+		attempts++
+
+		if err = c.attemptPrimary(send); err == nil || !c.isRetryable(err) {
+			break
+		}
+	}
+
+	return attempts, err
+}
+
+// attemptPrimary reconnects the primary, if it is down, and sends to it.
+func (c *Client) attemptPrimary(send func(client.MessageClient) error) error {
+	c.primaryLock.Lock()
+	defer c.primaryLock.Unlock()
+
+	// An open breaker refuses reconnects and sends without attempting
+	// them, and reconnects the primary itself before its trial sends.
+	if c.primaryDown {
+		if err := client.ReconnectAndHandshake(c.primary); err != nil {
+			c.primaryDown = !errors.Is(err, breaker.ErrOpen)
+			return err
+		}
+
+		c.primaryDown = false
+	}
+
+	err := send(c.primary)
+	c.primaryDown = err != nil && !errors.Is(err, breaker.ErrOpen)
+
+	return err
+}
+
+func (c *Client) isRetryable(err error) bool {
+	if errors.Is(err, breaker.ErrOpen) {
+		return false
+	}
+
+	return c.retryable == nil || c.retryable(err)
+}
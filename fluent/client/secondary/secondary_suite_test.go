@@ -0,0 +1,13 @@
+package secondary_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSecondary(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Secondary Suite")
+}
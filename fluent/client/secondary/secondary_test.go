@@ -0,0 +1,357 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package secondary_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/breaker"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/client/metrics"
+	"github.com/aanujj/fluent-forward-go/fluent/client/secondary"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		primary, second *clientfakes.FakeMessageClient
+		opts            secondary.Options
+		c               *secondary.Client
+		lock            sync.Mutex
+		routes          []secondary.Route
+		errPrimary      = errors.New("primary down")
+		errSecondary    = errors.New("disk full")
+		record          = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	BeforeEach(func() {
+		primary = &clientfakes.FakeMessageClient{}
+		second = &clientfakes.FakeMessageClient{}
+		routes = nil
+
+		opts = secondary.Options{
+			Primary:   primary,
+			Secondary: second,
+			OnRoute: func(r secondary.Route) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				routes = append(routes, r)
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		c = secondary.New(opts)
+		Expect(c.Connect()).To(Succeed())
+	})
+
+	It("sends to the primary", func() {
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+
+		Expect(primary.SendCallCount()).To(Equal(1))
+		Expect(second.SendCallCount()).To(BeZero())
+		Expect(routes).To(Equal([]secondary.Route{{Path: secondary.PathPrimary, Attempts: 1}}))
+		Expect(c.Stats()).To(Equal(secondary.Stats{Primary: 1}))
+	})
+
+	When("the primary fails", func() {
+		BeforeEach(func() {
+			primary.SendReturns(errPrimary)
+			opts.Retries = 2
+		})
+
+		It("retries and then sends the same bytes to the secondary", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			Expect(primary.SendCallCount()).To(Equal(3))
+			Expect(primary.ReconnectCallCount()).To(Equal(2))
+			Expect(second.SendArgsForCall(0)).To(Equal(primary.SendArgsForCall(0)))
+
+			Expect(routes).To(HaveLen(1))
+			Expect(routes[0].Path).To(Equal(secondary.PathSecondary))
+			Expect(routes[0].Attempts).To(Equal(3))
+			Expect(routes[0].PrimaryErr).To(MatchError(errPrimary))
+			Expect(c.Stats()).To(Equal(secondary.Stats{Secondary: 1}))
+		})
+
+		It("reports the retries to Metrics", func() {
+			collector := metrics.New(metrics.Options{})
+			c = secondary.New(secondary.Options{
+				Primary:   primary,
+				Secondary: second,
+				Retries:   opts.Retries,
+				Metrics:   collector,
+			})
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(collector.Stats().Retries).To(Equal(uint64(2)))
+		})
+
+		It("reconnects the primary before the next message", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			primary.SendReturns(nil)
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(primary.ReconnectCallCount()).To(Equal(3))
+			Expect(routes[1].Path).To(Equal(secondary.PathPrimary))
+		})
+
+		When("the secondary fails too", func() {
+			BeforeEach(func() {
+				second.SendReturns(errSecondary)
+			})
+
+			It("returns both errors", func() {
+				err := c.SendMessage("foo", record)
+
+				var se *secondary.Error
+				Expect(errors.As(err, &se)).To(BeTrue())
+				Expect(se.Primary).To(MatchError(errPrimary))
+				Expect(se.Secondary).To(MatchError(errSecondary))
+				Expect(errors.Is(err, errPrimary)).To(BeTrue())
+				Expect(errors.Is(err, errSecondary)).To(BeTrue())
+				Expect(err).To(MatchError("primary: primary down; secondary: disk full"))
+
+				Expect(routes[0].Path).To(Equal(secondary.PathNone))
+				Expect(c.Stats()).To(Equal(secondary.Stats{None: 1}))
+			})
+		})
+	})
+
+	When("the primary is behind a circuit breaker", func() {
+		var openTimeout time.Duration
+
+		BeforeEach(func() {
+			openTimeout = time.Hour
+			primary.SendReturns(errPrimary)
+			opts.Retries = 3
+		})
+
+		JustBeforeEach(func() {
+			c = secondary.New(secondary.Options{
+				Primary: breaker.New(breaker.Options{
+					Client:              primary,
+					ConsecutiveFailures: 1,
+					OpenTimeout:         openTimeout,
+				}),
+				Secondary: second,
+				Retries:   opts.Retries,
+				OnRoute:   opts.OnRoute,
+			})
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		It("sends to the secondary without reconnecting while it is open", func() {
+			// the failure opens the breaker, which refuses the reconnect of
+			// the retry
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(routes[0].Attempts).To(Equal(2))
+			Expect(routes[0].PrimaryErr).To(MatchError(breaker.ErrOpen))
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(routes[1].Path).To(Equal(secondary.PathSecondary))
+			Expect(routes[1].Attempts).To(Equal(1))
+			Expect(routes[1].PrimaryErr).To(MatchError(breaker.ErrOpen))
+
+			Expect(primary.SendCallCount()).To(Equal(1))
+			Expect(primary.ReconnectCallCount()).To(BeZero())
+			Expect(second.SendCallCount()).To(Equal(2))
+		})
+
+		When("the breaker lets a trial through", func() {
+			BeforeEach(func() {
+				openTimeout = 20 * time.Millisecond
+			})
+
+			It("sends to the primary again", func() {
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+
+				primary.SendReturns(nil)
+				time.Sleep(2 * openTimeout)
+
+				Expect(c.SendMessage("foo", record)).To(Succeed())
+				Expect(routes[1].Path).To(Equal(secondary.PathPrimary))
+				Expect(primary.ReconnectCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	When("an error is not retryable", func() {
+		BeforeEach(func() {
+			primary.SendReturns(errPrimary)
+			opts.Retries = 3
+			opts.RetryInterval = time.Hour
+			opts.Retryable = func(err error) bool {
+				return !errors.Is(err, errPrimary)
+			}
+		})
+
+		It("sends to the secondary without retrying", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			Expect(primary.SendCallCount()).To(Equal(1))
+			Expect(routes[0].Path).To(Equal(secondary.PathSecondary))
+			Expect(routes[0].Attempts).To(Equal(1))
+		})
+	})
+
+	When("a send waits to retry", func() {
+		BeforeEach(func() {
+			primary.SendReturnsOnCall(0, errPrimary)
+			opts.Retries = 1
+			opts.RetryInterval = 500 * time.Millisecond
+		})
+
+		It("does not hold up other sends", func() {
+			done := make(chan error, 1)
+
+			go func() {
+				done <- c.SendMessage("foo", record)
+			}()
+
+			Eventually(primary.SendCallCount).Should(Equal(1))
+
+			start := time.Now()
+			Expect(c.SendMessage("bar", record)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", opts.RetryInterval/2))
+
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+
+	When("a retry succeeds", func() {
+		BeforeEach(func() {
+			primary.SendReturnsOnCall(0, errPrimary)
+			opts.Retries = 1
+		})
+
+		It("does not use the secondary", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			Expect(second.SendCallCount()).To(BeZero())
+			Expect(routes[0].Path).To(Equal(secondary.PathPrimary))
+			Expect(routes[0].Attempts).To(Equal(2))
+		})
+	})
+
+	When("the primary cannot connect", func() {
+		BeforeEach(func() {
+			primary.ConnectReturns(errPrimary)
+		})
+
+		It("reconnects it on the first send", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			Expect(primary.ReconnectCallCount()).To(Equal(1))
+			Expect(primary.SendCallCount()).To(Equal(1))
+		})
+	})
+
+	When("the primary requires acks", func() {
+		var svr *fluenttest.Server
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{DropAcks: true})
+
+			opts.Primary = client.New(client.ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: 50 * time.Millisecond,
+			})
+			opts.RequireAck = true
+			opts.ChunkIDGenerator = &protocol.SequentialChunkIDGenerator{Prefix: "secondary-"}
+		})
+
+		AfterEach(func() {
+			Expect(c.Disconnect()).To(Succeed())
+			svr.Close()
+		})
+
+		It("records the chunk of the message", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			raw, ok := second.SendArgsForCall(0).(protocol.RawMessage)
+			Expect(ok).To(BeTrue())
+
+			chunk, err := raw.Chunk()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chunk).To(Equal("secondary-1"))
+			Expect(routes[0].Chunk).To(Equal(chunk))
+			Expect(routes[0].Path).To(Equal(secondary.PathSecondary))
+			Expect(svr.Messages()).To(HaveLen(1))
+		})
+	})
+
+	When("the primary requires a handshake", func() {
+		var (
+			svr *fluenttest.Server
+			fc  *client.Client
+		)
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{
+				SharedKey: []byte("thisisasharedkey"),
+				DropAcks:  true,
+			})
+
+			fc = client.New(client.ConnectionOptions{
+				Factory:           svr.ConnFactory(),
+				RequireAck:        true,
+				ConnectionTimeout: 50 * time.Millisecond,
+				AuthInfo:          client.AuthInfo{SharedKey: []byte("thisisasharedkey")},
+			})
+			opts.Primary = fc
+			opts.RequireAck = true
+		})
+
+		JustBeforeEach(func() {
+			Expect(fc.Handshake()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(c.Disconnect()).To(Succeed())
+			svr.Close()
+		})
+
+		It("performs it again when the primary recovers", func() {
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(routes[0].Path).To(Equal(secondary.PathSecondary))
+
+			svr.SetDropAcks(false)
+
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+			Expect(routes[1].Path).To(Equal(secondary.PathPrimary))
+			Expect(c.Stats()).To(Equal(secondary.Stats{Primary: 1, Secondary: 1}))
+		})
+	})
+})
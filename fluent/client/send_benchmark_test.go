@@ -0,0 +1,326 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+	"github.com/tinylib/msgp/msgp"
+)
+
+var discardAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 24224}
+
+// discardConn is a connection whose writes succeed without doing anything,
+// so that the benchmarks measure only the client.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+func (discardConn) Close() error                { return nil }
+func (discardConn) RemoteAddr() net.Addr        { return discardAddr }
+
+type discardFactory struct{}
+
+func (discardFactory) New() (net.Conn, error) { return discardConn{}, nil }
+
+// ackConn is a discardConn that acks every message with a chunk option
+// written to it from a reused buffer, so that the benchmarks with acks
+// measure only the client.
+type ackConn struct {
+	discardConn
+	ack    []byte
+	unread []byte
+}
+
+func (c *ackConn) Write(b []byte) (int, error) {
+	chunk, err := readChunk(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(chunk) == 0 {
+		return len(b), nil
+	}
+
+	c.ack = msgp.AppendMapHeader(c.ack[:0], 1)
+	c.ack = msgp.AppendString(c.ack, "ack")
+	c.ack = msgp.AppendStringFromBytes(c.ack, chunk)
+	c.unread = c.ack
+
+	return len(b), nil
+}
+
+func (c *ackConn) Read(b []byte) (int, error) {
+	if len(c.unread) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, c.unread)
+	c.unread = c.unread[n:]
+
+	return n, nil
+}
+
+func (*ackConn) SetDeadline(time.Time) error     { return nil }
+func (*ackConn) SetReadDeadline(time.Time) error { return nil }
+
+type ackFactory struct{}
+
+func (ackFactory) New() (net.Conn, error) { return &ackConn{}, nil }
+
+// readChunk returns the chunk option of the message in b, if any, without
+// allocating. The options are the last element of every mode.
+func readChunk(b []byte) ([]byte, error) {
+	sz, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(1); i < sz; i++ {
+		if b, err = msgp.Skip(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if msgp.IsNil(b) {
+		return nil, nil
+	}
+
+	n, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		var key, chunk []byte
+
+		if key, b, err = msgp.ReadMapKeyZC(b); err != nil {
+			return nil, err
+		}
+
+		if string(key) != "chunk" {
+			if b, err = msgp.Skip(b); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		chunk, _, err = msgp.ReadStringZC(b)
+
+		return chunk, err
+	}
+
+	return nil, nil
+}
+
+// benchmarkServer returns a server that acks every message without keeping
+// it, unlike fluenttest.Server, whose memory would grow with b.N.
+func benchmarkServer(b *testing.B) *server.Server {
+	svr := server.New(server.Options{
+		Handler: func(*server.Message) error { return nil },
+	})
+
+	b.Cleanup(func() {
+		_ = svr.Close()
+	})
+
+	return svr
+}
+
+// sender is implemented by Client and WSClient.
+type sender interface {
+	Send(e protocol.ChunkEncoder) error
+	SendRaw(raw []byte) error
+}
+
+type sendCase struct {
+	name string
+	send func(c sender) error
+	// helper is set for the Send* helpers, which only Client has.
+	helper bool
+}
+
+func sendCases(b *testing.B) []sendCase {
+	// the record only holds types that msgp encodes without reflection
+	record := map[string]interface{}{
+		"first": "Sir",
+		"last":  "Gawain",
+		"equipment": []interface{}{
+			"sword",
+			"lance",
+			"full plate",
+		},
+	}
+
+	entries := protocol.EntryList{
+		{Timestamp: protocol.EventTimeNow(), Record: record},
+		{Timestamp: protocol.EventTimeNow(), Record: record},
+	}
+
+	packed, err := protocol.NewPackedForwardMessage("foo", entries)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	compressed, err := protocol.NewCompressedPackedForwardMessage("foo", entries)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	stream, err := entries.MarshalPacked()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var (
+		message    = protocol.NewMessage("foo", record)
+		messageExt = protocol.NewMessageExt("foo", record)
+		forward    = protocol.NewForwardMessage("foo", entries)
+		raw        protocol.ChunkEncoder
+	)
+
+	// SendRaw does not wait for acks, so only the RawMessage carries a
+	// chunk
+	bits, err := message.MarshalMsg(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	chunked := protocol.NewMessage("foo", record)
+	chunked.Options = &protocol.MessageOptions{Chunk: "Z2F3YWlu"}
+
+	chunkedBits, err := chunked.MarshalMsg(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	raw = protocol.RawMessage(chunkedBits)
+
+	return []sendCase{
+		{"Message", func(c sender) error { return c.Send(message) }, false},
+		{"MessageExt", func(c sender) error { return c.Send(messageExt) }, false},
+		{"Forward", func(c sender) error { return c.Send(forward) }, false},
+		{"Packed", func(c sender) error { return c.Send(packed) }, false},
+		{"Compressed", func(c sender) error { return c.Send(compressed) }, false},
+		{"RawMessage", func(c sender) error { return c.Send(raw) }, false},
+		{"SendRaw", func(c sender) error { return c.SendRaw(bits) }, false},
+		{"SendMessage", func(c sender) error { return c.(*Client).SendMessage("foo", record) }, true},
+		{"SendMessageExt", func(c sender) error { return c.(*Client).SendMessageExt("foo", record) }, true},
+		{"SendForward", func(c sender) error { return c.(*Client).SendForward("foo", entries) }, true},
+		{"SendPacked", func(c sender) error { return c.(*Client).SendPacked("foo", entries) }, true},
+		{"SendPackedFromBytes", func(c sender) error { return c.(*Client).SendPackedFromBytes("foo", stream) }, true},
+		{"SendCompressed", func(c sender) error { return c.(*Client).SendCompressed("foo", entries) }, true},
+		{"SendCompressedFromBytes", func(c sender) error { return c.(*Client).SendCompressedFromBytes("foo", stream) }, true},
+	}
+}
+
+func benchmarkSend(b *testing.B, opts ConnectionOptions) {
+	if opts.Factory == nil {
+		opts.Factory = discardFactory{}
+	}
+
+	c := New(opts)
+	if err := c.Connect(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Cleanup(func() {
+		_ = c.Disconnect()
+	})
+
+	runSendCases(b, c, true)
+}
+
+// runSendCases runs a benchmark for every send case, skipping the Send*
+// helpers unless helpers is set.
+func runSendCases(b *testing.B, c sender, helpers bool) {
+	for _, sc := range sendCases(b) {
+		if sc.helper && !helpers {
+			continue
+		}
+
+		send := sc.send
+
+		b.Run(sc.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if err := send(c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// The steady state of every send mode is expected to report 0 allocs/op
+// without acks. With acks, the chunk ID and the ack are allocated.
+
+func BenchmarkSend(b *testing.B) {
+	benchmarkSend(b, ConnectionOptions{})
+}
+
+func BenchmarkSendCoalesced(b *testing.B) {
+	benchmarkSend(b, ConnectionOptions{
+		Coalesce: &CoalesceOptions{},
+	})
+}
+
+func BenchmarkSendRequireAck(b *testing.B) {
+	benchmarkSend(b, ConnectionOptions{
+		Factory:    ackFactory{},
+		RequireAck: true,
+	})
+}
+
+func BenchmarkWSSend(b *testing.B) {
+	httpSvr := httptest.NewServer(benchmarkServer(b))
+	b.Cleanup(httpSvr.Close)
+
+	c := NewWS(WSConnectionOptions{
+		Factory: &DefaultWSConnectionFactory{
+			URL: "ws" + strings.TrimPrefix(httpSvr.URL, "http"),
+		},
+	})
+	if err := c.Connect(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Cleanup(func() {
+		_ = c.Disconnect()
+	})
+
+	runSendCases(b, c, false)
+}
@@ -0,0 +1,86 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+// SendFunc implements the Send* helpers of MessageClient by building the
+// message and passing it to the function. MessageClient wrappers embed a
+// SendFunc set to their own Send method, so that every helper goes
+// through it.
+type SendFunc func(e protocol.ChunkEncoder) error
+
+func (f SendFunc) SendPacked(tag string, entries protocol.EntryList) error {
+	msg, err := protocol.NewPackedForwardMessage(tag, entries)
+	if err == nil {
+		err = f(msg)
+	}
+
+	return err
+}
+
+func (f SendFunc) SendPackedFromBytes(tag string, entries []byte) error {
+	msg := protocol.NewPackedForwardMessageFromBytes(tag, entries)
+
+	return f(msg)
+}
+
+func (f SendFunc) SendMessage(tag string, record interface{}) error {
+	msg := protocol.NewMessage(tag, record)
+
+	return f(msg)
+}
+
+func (f SendFunc) SendMessageExt(tag string, record interface{}) error {
+	msg := protocol.NewMessageExt(tag, record)
+
+	return f(msg)
+}
+
+func (f SendFunc) SendForward(tag string, entries protocol.EntryList) error {
+	msg := protocol.NewForwardMessage(tag, entries)
+
+	return f(msg)
+}
+
+func (f SendFunc) SendCompressed(tag string, entries protocol.EntryList) error {
+	msg, err := protocol.NewCompressedPackedForwardMessage(tag, entries)
+	if err == nil {
+		err = f(msg)
+	}
+
+	return err
+}
+
+func (f SendFunc) SendCompressedFromBytes(tag string, entries []byte) error {
+	msg, err := protocol.NewCompressedPackedForwardMessageFromBytes(tag, entries)
+	if err == nil {
+		err = f(msg)
+	}
+
+	return err
+}
@@ -0,0 +1,151 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrShutdown is returned by sends made during or after Shutdown.
+var ErrShutdown = errors.New("client is shut down")
+
+// ShutdownError is returned by Shutdown when its context is done before
+// the sends in progress complete. Those sends are aborted, so their
+// messages may or may not have been received.
+type ShutdownError struct {
+	// Err is the error of the context.
+	Err error
+	// InFlight is the number of sends that were aborted.
+	InFlight int
+	// Chunks are the chunk IDs of the aborted sends that were written and
+	// were waiting for their ack.
+	Chunks []string
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown: %d sends not confirmed (%d waiting for acks): %v",
+		e.InFlight, len(e.Chunks), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// flights tracks the sends in progress, so that Shutdown can wait for
+// them. The zero value is ready to use.
+type flights struct {
+	lock    sync.Mutex
+	closing bool
+	count   int
+	// chunks holds the chunk IDs of the sends waiting for acks.
+	chunks  map[string]struct{}
+	drained chan struct{}
+}
+
+// begin must be called before a send, and end after it if begin succeeded.
+func (f *flights) begin() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closing {
+		return ErrShutdown
+	}
+
+	f.count++
+
+	return nil
+}
+
+// awaitAck records that the send of chunk was written and waits for its
+// ack.
+func (f *flights) awaitAck(chunk string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.chunks == nil {
+		f.chunks = map[string]struct{}{}
+	}
+
+	f.chunks[chunk] = struct{}{}
+}
+
+// end records the end of a send and, if it was waiting for an ack, of
+// chunk.
+func (f *flights) end(chunk string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(chunk) > 0 {
+		delete(f.chunks, chunk)
+	}
+
+	f.count--
+
+	if f.count == 0 && f.drained != nil {
+		close(f.drained)
+		f.drained = nil
+	}
+}
+
+// close rejects new sends and returns a channel that is closed once the
+// sends in progress have ended.
+func (f *flights) close() <-chan struct{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closing = true
+
+	if f.count == 0 {
+		done := make(chan struct{})
+		close(done)
+
+		return done
+	}
+
+	if f.drained == nil {
+		f.drained = make(chan struct{})
+	}
+
+	return f.drained
+}
+
+// shutdownError describes the sends in progress.
+func (f *flights) shutdownError(err error) *ShutdownError {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	e := &ShutdownError{Err: err, InFlight: f.count}
+
+	for chunk := range f.chunks {
+		e.Chunks = append(e.Chunks, chunk)
+	}
+
+	sort.Strings(e.Chunks)
+
+	return e
+}
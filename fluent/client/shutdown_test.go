@@ -0,0 +1,249 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// stallingConn stalls after every write, so that sends are still writing
+// when Shutdown aborts them.
+type stallingConn struct {
+	net.Conn
+	stall time.Duration
+}
+
+func (c stallingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	time.Sleep(c.stall)
+
+	return n, err
+}
+
+type stallingFactory struct {
+	ConnectionFactory
+	stall time.Duration
+}
+
+func (f stallingFactory) New() (net.Conn, error) {
+	conn, err := f.ConnectionFactory.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return stallingConn{Conn: conn, stall: f.stall}, nil
+}
+
+var _ = Describe("Shutdown", func() {
+	var (
+		svr    *fluenttest.Server
+		record = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	)
+
+	AfterEach(func() {
+		svr.Close()
+	})
+
+	Describe("Client", func() {
+		var (
+			c       *Client
+			factory ConnectionFactory
+			timeout time.Duration
+		)
+
+		BeforeEach(func() {
+			factory = nil
+			timeout = 5 * time.Second
+		})
+
+		JustBeforeEach(func() {
+			if factory == nil {
+				factory = svr.ConnFactory()
+			}
+
+			c = New(ConnectionOptions{
+				Factory:           factory,
+				RequireAck:        true,
+				ConnectionTimeout: timeout,
+			})
+
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		When("acks arrive before the deadline", func() {
+			BeforeEach(func() {
+				svr = fluenttest.NewServer(fluenttest.Options{AckDelay: 100 * time.Millisecond})
+			})
+
+			It("waits for them and disconnects", func() {
+				sent := make(chan error)
+				go func() {
+					sent <- c.SendMessage("foo", record)
+				}()
+
+				Eventually(svr.Messages).Should(HaveLen(1))
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+
+				Expect(c.Shutdown(ctx)).To(Succeed())
+				Expect(sent).To(Receive(BeNil()))
+				Expect(c.TransportPhase()).To(BeFalse())
+			})
+
+			It("rejects new sends", func() {
+				Expect(c.Shutdown(context.Background())).To(Succeed())
+
+				Expect(c.SendMessage("foo", record)).To(MatchError(ErrShutdown))
+				Expect(c.SendRaw([]byte{0x90})).To(MatchError(ErrShutdown))
+			})
+		})
+
+		When("acks do not arrive before the deadline", func() {
+			BeforeEach(func() {
+				svr = fluenttest.NewServer(fluenttest.Options{DropAcks: true})
+			})
+
+			It("aborts the sends and reports their chunks", func() {
+				msg := protocol.NewMessage("foo", record)
+				chunk, err := msg.Chunk()
+				Expect(err).ToNot(HaveOccurred())
+
+				sent := make(chan error)
+				go func() {
+					sent <- c.Send(msg)
+				}()
+
+				Eventually(svr.Messages).Should(HaveLen(1))
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				err = c.Shutdown(ctx)
+				Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+				var serr *ShutdownError
+				Expect(errors.As(err, &serr)).To(BeTrue())
+				Expect(serr.InFlight).To(Equal(1))
+				Expect(serr.Chunks).To(Equal([]string{chunk}))
+
+				Expect(sent).To(Receive(HaveOccurred()))
+			})
+
+			When("a send is still writing when it is aborted", func() {
+				BeforeEach(func() {
+					factory = stallingFactory{ConnectionFactory: svr.ConnFactory(), stall: 200 * time.Millisecond}
+					timeout = time.Minute
+				})
+
+				It("does not wait for the ack timeout", func() {
+					sent := make(chan error)
+					go func() {
+						sent <- c.SendMessage("foo", record)
+					}()
+
+					Eventually(svr.Messages).Should(HaveLen(1))
+
+					ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+					defer cancel()
+
+					start := time.Now()
+					Expect(c.Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+					Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+					Expect(sent).To(Receive(HaveOccurred()))
+				})
+			})
+
+			It("returns promptly while a reconnect waits for the sends", func() {
+				sent := make(chan error)
+				go func() {
+					sent <- c.SendMessage("foo", record)
+				}()
+
+				Eventually(svr.Messages).Should(HaveLen(1))
+
+				reconnected := make(chan error)
+				go func() {
+					reconnected <- c.Reconnect()
+				}()
+
+				// let the reconnect wait for the session lock
+				time.Sleep(20 * time.Millisecond)
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				start := time.Now()
+				Expect(c.Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+				Expect(sent).To(Receive(HaveOccurred()))
+				Eventually(reconnected).Should(Receive())
+			})
+		})
+	})
+
+	Describe("WSClient", func() {
+		var c *WSClient
+
+		BeforeEach(func() {
+			svr = fluenttest.NewServer(fluenttest.Options{})
+
+			c = NewWS(WSConnectionOptions{
+				Factory: svr.WSConnectionFactory(),
+			})
+
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		It("waits for the writes and disconnects", func() {
+			Expect(c.Send(protocol.NewMessage("foo", record))).To(Succeed())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			Expect(c.Shutdown(ctx)).To(Succeed())
+			Expect(c.Session()).To(BeNil())
+			Eventually(svr.Messages).Should(HaveLen(1))
+		})
+
+		It("rejects new sends", func() {
+			Expect(c.Shutdown(context.Background())).To(Succeed())
+
+			Expect(c.Send(protocol.NewMessage("foo", record))).To(MatchError(ErrShutdown))
+			Expect(c.SendRaw([]byte{0x90})).To(MatchError(ErrShutdown))
+		})
+	})
+})
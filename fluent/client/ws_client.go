@@ -25,20 +25,20 @@ SOFTWARE.
 package client
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
 	"github.com/aanujj/fluent-forward-go/fluent/client/ws/ext"
 	"github.com/aanujj/fluent-forward-go/fluent/protocol"
 	"github.com/gorilla/websocket"
-
-	"github.com/tinylib/msgp/msgp"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
@@ -143,16 +143,33 @@ func (wcf *DefaultWSConnectionFactory) NewSession(connection ws.Connection) *WSS
 type WSConnectionOptions struct {
 	ws.ConnectionOptions
 	Factory WSConnectionFactory
+	// Metrics, if set, receives measurements of connections and sends.
+	Metrics Metrics
+	// Logger, if set, receives debug events for the connection and sends.
+	// Unless ConnectionOptions.Logger is set, the debug output of the
+	// websocket connection goes to it too, through NewWSLogger.
+	Logger Logger
+	// ChunkIDGenerator, if set, gives a chunk ID to the messages sent
+	// without one. WSClient does not wait for acks, so messages are
+	// otherwise sent as they are.
+	ChunkIDGenerator protocol.ChunkIDGenerator
+	Callbacks
 }
 
 // WSClient manages the lifetime of a single websocket connection.
 type WSClient struct {
+	Callbacks
 	ConnectionFactory WSConnectionFactory
 	ConnectionOptions ws.ConnectionOptions
+	Metrics           Metrics
+	Logger            Logger
+	ChunkIDGenerator  protocol.ChunkIDGenerator
 	session           *WSSession
 	errLock           sync.RWMutex
 	sessionLock       sync.RWMutex
 	err               error
+	flights           flights
+	encoders          encoderPool
 }
 
 func NewWS(opts WSConnectionOptions) *WSClient {
@@ -162,12 +179,34 @@ func NewWS(opts WSConnectionOptions) *WSClient {
 		}
 	}
 
+	if opts.Logger != nil && opts.ConnectionOptions.Logger == nil {
+		opts.ConnectionOptions.Logger = NewWSLogger(opts.Logger)
+	}
+
 	return &WSClient{
 		ConnectionOptions: opts.ConnectionOptions,
 		ConnectionFactory: opts.Factory,
+		Metrics:           opts.Metrics,
+		Logger:            opts.Logger,
+		ChunkIDGenerator:  opts.ChunkIDGenerator,
+		Callbacks:         opts.Callbacks,
 	}
 }
 
+func (c *WSClient) log() Logger {
+	if c.Logger == nil {
+		return noopLogger{}
+	}
+
+	return c.Logger
+}
+
+// debugEnabled reports whether debug events are logged, so that the sends
+// can skip boxing their arguments, which allocates.
+func (c *WSClient) debugEnabled() bool {
+	return c.Logger != nil && debugEnabled(c.Logger)
+}
+
 func (c *WSClient) setErr(err error) {
 	c.errLock.Lock()
 	defer c.errLock.Unlock()
@@ -190,6 +229,18 @@ func (c *WSClient) Session() *WSSession {
 	return c.session
 }
 
+func (c *WSClient) remoteAddr() net.Addr {
+	return sessionAddr(c.Session())
+}
+
+func sessionAddr(session *WSSession) net.Addr {
+	if session == nil || session.Connection == nil {
+		return nil
+	}
+
+	return session.Connection.RemoteAddr()
+}
+
 // connect is for internal use and should be called within
 // the scope of an acquired 'c.sessionLock.Lock()'
 //
@@ -197,16 +248,20 @@ func (c *WSClient) Session() *WSSession {
 func (c *WSClient) connect() error {
 	conn, err := c.ConnectionFactory.New()
 	if err != nil {
+		c.log().Error("dial failed", "err", err)
 		return err
 	}
 
 	connection, err := ws.NewConnection(conn, c.ConnectionOptions)
 	if err != nil {
+		c.log().Error("dial failed", "err", err)
 		return err
 	}
 
 	c.session = c.ConnectionFactory.NewSession(connection)
 
+	c.log().Debug("connected", "remote", connection.RemoteAddr())
+
 	go func() {
 		// There is a race condition where session is set to nil before
 		// Listen is called. This check resolves segfaults during tests,
@@ -225,6 +280,16 @@ func (c *WSClient) connect() error {
 		// custom ReadHandler that will receive the error synchronously.
 		if err := c.session.Connection.Listen(); err != nil {
 			c.setErr(err)
+
+			c.log().Error("read failed", "err", err)
+
+			if c.Metrics != nil {
+				c.Metrics.ObserveError(err)
+			}
+
+			if c.OnError != nil {
+				c.OnError(Event{Op: OpRead, RemoteAddr: connection.RemoteAddr(), Err: err})
+			}
 		}
 	}()
 
@@ -236,6 +301,20 @@ func (c *WSClient) connect() error {
 // will be passed via the "Authentication" header during the initial
 // HTTP call.
 func (c *WSClient) Connect() error {
+	err := c.lockedConnect()
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveConnect(false, err)
+	}
+
+	if c.OnConnect != nil {
+		c.OnConnect(Event{Op: OpConnect, RemoteAddr: c.remoteAddr(), Err: err})
+	}
+
+	return err
+}
+
+func (c *WSClient) lockedConnect() error {
 	c.sessionLock.Lock()
 	defer c.sessionLock.Unlock()
 
@@ -247,10 +326,27 @@ func (c *WSClient) Connect() error {
 }
 
 // Disconnect ends the current Session and terminates its websocket connection.
-func (c *WSClient) Disconnect() (err error) {
+func (c *WSClient) Disconnect() error {
+	session, err := c.disconnect()
+
+	if session != nil {
+		c.log().Debug("disconnected", "remote", sessionAddr(session), "op", OpDisconnect)
+	}
+
+	if session != nil && c.OnDisconnect != nil {
+		c.OnDisconnect(Event{Op: OpDisconnect, RemoteAddr: sessionAddr(session), Err: err})
+	}
+
+	return err
+}
+
+// disconnect returns the session it ended, if any.
+func (c *WSClient) disconnect() (session *WSSession, err error) {
 	c.sessionLock.Lock()
 	defer c.sessionLock.Unlock()
 
+	session = c.session
+
 	if c.session != nil && !c.session.Connection.Closed() {
 		err = c.session.Connection.Close()
 	}
@@ -260,13 +356,74 @@ func (c *WSClient) Disconnect() (err error) {
 	return
 }
 
+// Shutdown stops accepting sends, which then fail with ErrShutdown, waits
+// for the writes in progress, and disconnects, which sends a close message
+// and waits for the peer to acknowledge it. If ctx is done first, the
+// writes in progress are aborted and a *ShutdownError describes them. The
+// WSClient cannot send after Shutdown.
+func (c *WSClient) Shutdown(ctx context.Context) error {
+	c.log().Debug("shutting down")
+
+	var serr *ShutdownError
+
+	drained := c.flights.close()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		serr = c.flights.shutdownError(ctx.Err())
+		c.log().Warn("aborting sends", "in_flight", serr.InFlight)
+
+		if session := c.Session(); session != nil {
+			_ = session.Connection.SetWriteDeadline(time.Now())
+		}
+
+		<-drained
+	}
+
+	err := c.Disconnect()
+	if serr != nil {
+		return serr
+	}
+
+	return err
+}
+
 // Reconnect terminates the existing Session and creates a new one.
-func (c *WSClient) Reconnect() (err error) {
+func (c *WSClient) Reconnect() error {
+	c.log().Debug("reconnecting")
+
+	old, closeErr, err := c.reconnect()
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveConnect(true, err)
+	}
+
+	if old != nil && c.OnDisconnect != nil {
+		c.OnDisconnect(Event{Op: OpReconnect, RemoteAddr: sessionAddr(old), Err: closeErr})
+	}
+
+	if c.OnConnect != nil {
+		c.OnConnect(Event{Op: OpReconnect, RemoteAddr: c.remoteAddr(), Err: err})
+	}
+
+	return err
+}
+
+// reconnect returns the session it ended, if any, and the error from
+// closing it.
+func (c *WSClient) reconnect() (old *WSSession, closeErr, err error) {
 	c.sessionLock.Lock()
 	defer c.sessionLock.Unlock()
 
+	old = c.session
+
 	if c.session != nil && !c.session.Connection.Closed() {
-		_ = c.session.Connection.Close()
+		closeErr = c.session.Connection.Close()
+	}
+
+	if old != nil {
+		c.log().Debug("disconnected", "remote", sessionAddr(old), "op", OpReconnect)
 	}
 
 	if err = c.connect(); err != nil {
@@ -280,38 +437,97 @@ func (c *WSClient) Reconnect() (err error) {
 
 // Send sends a single msgp.Encodable across the wire.
 func (c *WSClient) Send(e protocol.ChunkEncoder) error {
-	var (
-		err            error
-		rawMessageData bytes.Buffer
-	)
+	enc := c.encoders.get()
+	defer c.encoders.put(enc)
+
+	bytesData, err := c.send(enc, e)
+
+	if c.Metrics != nil {
+		observeRaw(c.Metrics, bytesData, err)
+	}
+
+	if err != nil {
+		c.log().Error("send failed", "err", err)
+
+		if c.OnError != nil {
+			c.OnError(Event{Op: OpSend, RemoteAddr: c.remoteAddr(), Err: err})
+		}
+	} else if c.debugEnabled() {
+		c.Logger.Debug("sent message", "bytes", len(bytesData))
+	}
+
+	return err
+}
+
+// send encodes e with enc and returns the encoded message, or nil if it
+// failed before encoding.
+func (c *WSClient) send(enc *encoder, e protocol.ChunkEncoder) ([]byte, error) {
+	if err := c.flights.begin(); err != nil {
+		return nil, err
+	}
+
+	defer c.flights.end("")
+
+	var err error
 	// Check for an async connection error and return it here.
 	// In most cases, the client will not care about reading from
 	// the connection, so checking for the error here is sufficient.
 	if err = c.getErr(); err != nil {
-		return err // TODO: wrap this
+		return nil, err // TODO: wrap this
 	}
 
 	// prevent this from raise conditions by copy the session pointer
 	session := c.Session()
 	if session == nil || session.Connection.Closed() {
-		return errors.New("no active session")
+		return nil, errors.New("no active session")
 	}
 
-	err = msgp.Encode(&rawMessageData, e)
+	if c.ChunkIDGenerator != nil {
+		if _, err = protocol.ChunkWith(e, c.ChunkIDGenerator); err != nil {
+			return nil, err
+		}
+	}
+
+	bytesData, err := enc.encode(e)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	bytesData := rawMessageData.Bytes()
 	// Write function does not accurately return the number of bytes written
 	// so it would be ineffective to compare
 	_, err = c.session.Connection.Write(bytesData)
 
-	return err
+	return bytesData, err
 }
 
 // SendRaw sends an array of bytes across the wire.
 func (c *WSClient) SendRaw(m []byte) error {
+	err := c.sendRaw(m)
+
+	if c.Metrics != nil {
+		observeRaw(c.Metrics, m, err)
+	}
+
+	if err != nil {
+		c.log().Error("send failed", "err", err)
+
+		if c.OnError != nil {
+			c.OnError(Event{Op: OpSend, RemoteAddr: c.remoteAddr(), Err: err})
+		}
+	} else if c.debugEnabled() {
+		c.Logger.Debug("sent raw message", "bytes", len(m))
+	}
+
+	return err
+}
+
+func (c *WSClient) sendRaw(m []byte) error {
+	if err := c.flights.begin(); err != nil {
+		return err
+	}
+
+	defer c.flights.end("")
+
 	// Check for an async connection error and return it here.
 	// In most cases, the client will not care about reading from
 	// the connection, so checking for the error here is sufficient.
@@ -331,6 +331,20 @@ var _ = Describe("WSClient", func() {
 			Expect(bytes.Equal(msgBytes, writtenBytes)).To(BeTrue())
 		})
 
+		When("ChunkIDGenerator is set", func() {
+			BeforeEach(func() {
+				client.ChunkIDGenerator = &protocol.SequentialChunkIDGenerator{Prefix: "ws-"}
+			})
+
+			It("gives the message a chunk ID", func() {
+				Expect(client.Send(&msg)).ToNot(HaveOccurred())
+
+				chunk, err := protocol.GetChunk(conn.WriteArgsForCall(0))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(chunk).To(Equal("ws-1"))
+			})
+		})
+
 		When("The message is large", func() {
 			const charset = "abcdefghijklmnopqrstuvwxyz" + "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
@@ -0,0 +1,15 @@
+//go:build go1.21
+
+package fluentslog_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFluentslog(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fluentslog Suite")
+}
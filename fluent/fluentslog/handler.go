@@ -0,0 +1,376 @@
+//go:build go1.21
+
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package fluentslog provides a log/slog Handler that sends records to a
+// Fluent endpoint through a client.MessageClient.
+package fluentslog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// TagKey is the attribute key that sets the tag of the records of a
+// logger. The attribute is not included in the records.
+const TagKey = "fluent.tag"
+
+// Tag returns an attribute that sets the tag of the records of a logger:
+//
+//	dbLogger := logger.With(fluentslog.Tag("app.db"))
+//
+// It only takes effect outside of groups.
+func Tag(tag string) slog.Attr {
+	return slog.String(TagKey, tag)
+}
+
+// Options configures a Handler.
+type Options struct {
+	// Client sends the records. It must be connected.
+	Client client.MessageClient
+	// Tag is the tag of the records of loggers without a Tag attribute.
+	Tag string
+	// Level is the minimum level to send. Defaults to slog.LevelInfo.
+	Level slog.Leveler
+	// AddSource adds the source file, line, and function of the log call
+	// to records under slog.SourceKey.
+	AddSource bool
+}
+
+// field is an attribute added with WithAttrs, converted once and stored
+// with the groups that were open when it was added.
+type field struct {
+	groups []string
+	key    string
+	value  interface{}
+}
+
+// Handler is a slog.Handler that converts every record to a map with the
+// level under slog.LevelKey, the message under slog.MessageKey, and the
+// attributes, and sends it as a MessageExt with a nanosecond EventTime.
+// Groups become nested maps.
+type Handler struct {
+	client    client.MessageClient
+	tag       string
+	level     slog.Leveler
+	addSource bool
+	fields    []field
+	groups    []string
+}
+
+var _ slog.Handler = (*Handler)(nil)
+
+// New returns a Handler configured by opts.
+func New(opts Options) *Handler {
+	h := &Handler{
+		client:    opts.Client,
+		tag:       opts.Tag,
+		level:     opts.Level,
+		addSource: opts.AddSource,
+	}
+
+	if h.level == nil {
+		h.level = slog.LevelInfo
+	}
+
+	return h
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle sends the record and returns the error from the client, if any.
+// A record without a time is given the current time, as every Fluent event
+// needs one.
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	m := map[string]interface{}{
+		slog.LevelKey:   r.Level.String(),
+		slog.MessageKey: r.Message,
+	}
+
+	if h.addSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+
+		m[slog.SourceKey] = map[string]interface{}{
+			"function": frame.Function,
+			"file":     frame.File,
+			"line":     frame.Line,
+		}
+	}
+
+	for _, f := range h.fields {
+		group(m, f.groups)[f.key] = copyGroups(f.value)
+	}
+
+	tag := h.tag
+	attrs := group(m, h.groups)
+
+	r.Attrs(func(a slog.Attr) bool {
+		if len(h.groups) == 0 && a.Key == TagKey {
+			if v := a.Value.Resolve(); v.Kind() == slog.KindString {
+				tag = v.String()
+				return true
+			}
+		}
+
+		addAttr(attrs, a)
+
+		return true
+	})
+
+	// drop the group if the record had no attributes for it
+	if len(h.groups) > 0 && len(attrs) == 0 {
+		pruneEmpty(m, h.groups)
+	}
+
+	ts := r.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	return h.client.Send(&protocol.MessageExt{
+		Tag:       tag,
+		Timestamp: protocol.EventTime{Time: ts.UTC()},
+		Record:    m,
+	})
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.fields = append([]field(nil), h.fields...)
+
+	scratch := map[string]interface{}{}
+
+	for _, a := range attrs {
+		if len(h.groups) == 0 && a.Key == TagKey {
+			if v := a.Value.Resolve(); v.Kind() == slog.KindString {
+				h2.tag = v.String()
+				continue
+			}
+		}
+
+		addAttr(scratch, a)
+	}
+
+	for k, v := range scratch {
+		h2.fields = append(h2.fields, field{groups: h.groups, key: k, value: v})
+	}
+
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.groups = append(append([]string(nil), h.groups...), name)
+
+	return &h2
+}
+
+// group returns the map for the nested groups, creating it if necessary.
+func group(m map[string]interface{}, groups []string) map[string]interface{} {
+	for _, name := range groups {
+		next, ok := m[name].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[name] = next
+		}
+
+		m = next
+	}
+
+	return m
+}
+
+// copyGroups copies the maps made from groups, which are shared by every
+// record of a logger, so that record attributes can be added to them.
+func copyGroups(v interface{}) interface{} {
+	g, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	c := make(map[string]interface{}, len(g))
+	for k, e := range g {
+		c[k] = copyGroups(e)
+	}
+
+	return c
+}
+
+func pruneEmpty(m map[string]interface{}, groups []string) {
+	if len(groups) == 0 {
+		return
+	}
+
+	next, ok := m[groups[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	pruneEmpty(next, groups[1:])
+
+	if len(next) == 0 {
+		delete(m, groups[0])
+	}
+}
+
+// addAttr adds a to m following the rules of slog.Handler: empty
+// attributes are ignored, and groups without a key are inlined.
+func addAttr(m map[string]interface{}, a slog.Attr) {
+	v := a.Value.Resolve()
+
+	if v.Kind() == slog.KindGroup {
+		attrs := v.Group()
+		if len(attrs) == 0 {
+			return
+		}
+
+		if len(a.Key) == 0 {
+			for _, ga := range attrs {
+				addAttr(m, ga)
+			}
+
+			return
+		}
+
+		g, ok := m[a.Key].(map[string]interface{})
+		if !ok {
+			g = make(map[string]interface{}, len(attrs))
+		}
+
+		for _, ga := range attrs {
+			addAttr(g, ga)
+		}
+
+		if len(g) > 0 {
+			m[a.Key] = g
+		}
+
+		return
+	}
+
+	if len(a.Key) == 0 && v.Equal(slog.Value{}) {
+		return
+	}
+
+	m[a.Key] = value(v)
+}
+
+// value converts a resolved, non-group slog.Value to a value msgp can
+// encode.
+func value(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindUint64:
+		return v.Uint64()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	}
+
+	switch a := v.Any().(type) {
+	case nil:
+		return nil
+	case error:
+		return a.Error()
+	case []byte:
+		return a
+	default:
+		if encodable(a) {
+			return a
+		}
+
+		return fmt.Sprintf("%+v", a)
+	}
+}
+
+// encodable reports whether msgp.Writer.WriteIntf can encode a, without
+// encoding it. WriteIntf matches the exact types below first, then falls
+// back to reflection for pointers, slices and maps with string keys, which
+// hold values it must be able to encode in turn.
+func encodable(a interface{}) bool {
+	switch a.(type) {
+	case nil, msgp.Encodable, msgp.Extension,
+		bool, float32, float64, complex64, complex128,
+		uint8, uint16, uint32, uint64, uint, int8, int16, int32, int64, int,
+		string, []byte, map[string]string, time.Time, time.Duration:
+		return true
+	}
+
+	v := reflect.ValueOf(a)
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		return v.IsNil() || encodable(v.Elem().Interface())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if !encodable(v.Index(i).Interface()) {
+				return false
+			}
+		}
+
+		return true
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return false
+		}
+
+		for iter := v.MapRange(); iter.Next(); {
+			if !encodable(iter.Value().Interface()) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,210 @@
+//go:build go1.21
+
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package fluentslog_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing/slogtest"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/fluentslog"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		mc   *clientfakes.FakeMessageClient
+		opts fluentslog.Options
+	)
+
+	sent := func() []*protocol.MessageExt {
+		msgs := make([]*protocol.MessageExt, mc.SendCallCount())
+		for i := range msgs {
+			msgs[i] = mc.SendArgsForCall(i).(*protocol.MessageExt)
+		}
+
+		return msgs
+	}
+
+	BeforeEach(func() {
+		mc = &clientfakes.FakeMessageClient{}
+		opts = fluentslog.Options{
+			Client: mc,
+			Tag:    "app",
+		}
+	})
+
+	It("passes testing/slogtest", func() {
+		h := fluentslog.New(opts)
+
+		err := slogtest.TestHandler(h, func() []map[string]any {
+			var results []map[string]any
+
+			for _, msg := range sent() {
+				m := msg.Record.(map[string]interface{})
+				m[slog.TimeKey] = msg.Timestamp.Time
+				results = append(results, m)
+			}
+
+			return results
+		})
+
+		// every forward event needs a time, so a zero time is replaced
+		// with the current time
+		if err != nil {
+			for _, line := range strings.Split(err.Error(), "\n") {
+				Expect(line).To(ContainSubstring("zero Record.Time"))
+			}
+		}
+	})
+
+	It("sends a MessageExt with a nanosecond timestamp", func() {
+		logger := slog.New(fluentslog.New(opts))
+		logger.Warn("disk low", "free", 0.1, "err", errors.New("ENOSPC"), "after", time.Second)
+
+		msgs := sent()
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Tag).To(Equal("app"))
+		Expect(time.Since(msgs[0].Timestamp.Time)).To(BeNumerically("<", time.Second))
+		Expect(msgs[0].Timestamp.Nanosecond()).ToNot(BeZero())
+		Expect(msgs[0].Record).To(Equal(map[string]interface{}{
+			"level": "WARN",
+			"msg":   "disk low",
+			"free":  0.1,
+			"err":   "ENOSPC",
+			"after": "1s",
+		}))
+	})
+
+	It("maps loggers to tags", func() {
+		logger := slog.New(fluentslog.New(opts))
+		logger.With(fluentslog.Tag("app.db")).Info("query", "rows", 3)
+		logger.Info("request", fluentslog.Tag("app.http"))
+		logger.WithGroup("g").Info("grouped", fluentslog.Tag("ignored"))
+
+		msgs := sent()
+		Expect(msgs[0].Tag).To(Equal("app.db"))
+		Expect(msgs[0].Record).ToNot(HaveKey(fluentslog.TagKey))
+		Expect(msgs[1].Tag).To(Equal("app.http"))
+		Expect(msgs[2].Tag).To(Equal("app"))
+		Expect(msgs[2].Record).To(HaveKeyWithValue("g", HaveKeyWithValue(fluentslog.TagKey, "ignored")))
+	})
+
+	It("does not share groups between records", func() {
+		logger := slog.New(fluentslog.New(opts)).With(slog.Group("req", "id", 1)).WithGroup("req")
+		logger.Info("first", "path", "/a")
+		logger.Info("second", "status", 200)
+
+		msgs := sent()
+		Expect(msgs[0].Record).To(HaveKeyWithValue("req", Equal(map[string]interface{}{"id": int64(1), "path": "/a"})))
+		Expect(msgs[1].Record).To(HaveKeyWithValue("req", Equal(map[string]interface{}{"id": int64(1), "status": int64(200)})))
+	})
+
+	It("filters by level", func() {
+		opts.Level = slog.LevelWarn
+		logger := slog.New(fluentslog.New(opts))
+		logger.Info("skipped")
+		logger.Error("sent")
+
+		Expect(sent()).To(HaveLen(1))
+	})
+
+	It("adds the source", func() {
+		opts.AddSource = true
+		slog.New(fluentslog.New(opts)).Info("here")
+
+		source := sent()[0].Record.(map[string]interface{})[slog.SourceKey]
+		Expect(source).To(HaveKeyWithValue("file", HaveSuffix("handler_test.go")))
+		Expect(source).To(HaveKeyWithValue("function", ContainSubstring("fluentslog_test")))
+	})
+
+	It("converts values msgpack cannot encode to strings", func() {
+		type point struct{ X, Y int }
+
+		slog.New(fluentslog.New(opts)).Info("moved", "to", point{1, 2})
+
+		Expect(sent()[0].Record).To(HaveKeyWithValue("to", "{X:1 Y:2}"))
+	})
+
+	It("keeps values msgpack encodes through reflection", func() {
+		type level int
+
+		counts := map[string]int{"knights": 12}
+		slog.New(fluentslog.New(opts)).Info("counted",
+			"counts", counts,
+			"names", []string{"Gawain"},
+			"level", level(3),
+			"points", []interface{}{struct{ X int }{1}},
+		)
+
+		record := sent()[0].Record
+		Expect(record).To(HaveKeyWithValue("counts", counts))
+		Expect(record).To(HaveKeyWithValue("names", []string{"Gawain"}))
+		Expect(record).To(HaveKeyWithValue("level", "3"))
+		Expect(record).To(HaveKeyWithValue("points", "[{X:1}]"))
+
+		_, err := protocol.Encode(sent()[0])
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("returns send errors", func() {
+		mc.SendReturns(errors.New("no active session"))
+
+		h := fluentslog.New(opts)
+		r := slog.NewRecord(time.Now(), slog.LevelInfo, "lost", 0)
+
+		Expect(h.Handle(context.Background(), r)).To(MatchError("no active session"))
+	})
+
+	It("ships records to a forward server", func() {
+		svr := fluenttest.NewServer(fluenttest.Options{})
+		defer svr.Close()
+
+		c := client.New(client.ConnectionOptions{
+			Factory:    svr.ConnFactory(),
+			RequireAck: true,
+		})
+		Expect(c.Connect()).To(Succeed())
+
+		defer c.Disconnect()
+
+		opts.Client = c
+		slog.New(fluentslog.New(opts)).Info("hello", "user", "gawain")
+
+		events := svr.Events("app")
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Record).To(HaveKeyWithValue("user", "gawain"))
+	})
+})
@@ -0,0 +1,267 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package fluenttest provides an in-process forward server for tests of
+// code that uses client.Client or client.WSClient.
+package fluenttest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+)
+
+var errAckDropped = errors.New("ack dropped")
+
+// Options configures a Server.
+type Options struct {
+	// SharedKey requires clients to complete the handshake.
+	SharedKey []byte
+	// Users requires clients to authenticate with one of the usernames
+	// and passwords during the handshake. It requires SharedKey.
+	Users map[string]string
+	// AckDelay delays every ack.
+	AckDelay time.Duration
+	// DropAcks prevents the server from acking any message.
+	DropAcks bool
+}
+
+// Server is a forward server listening on a loopback TCP port and, for
+// websocket clients, on a loopback HTTP port. It records every message
+// it receives. Clients can also connect in memory with PipeFactory.
+type Server struct {
+	// Addr is the TCP address, in the form "127.0.0.1:port".
+	Addr string
+	// URL is the websocket URL, in the form "ws://127.0.0.1:port".
+	URL string
+
+	svr     *server.Server
+	httpSvr *httptest.Server
+
+	lock     sync.Mutex
+	messages []*server.Message
+	changed  chan struct{}
+	ackDelay time.Duration
+	dropAcks bool
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer(opts Options) *Server {
+	s := &Server{
+		changed:  make(chan struct{}),
+		ackDelay: opts.AckDelay,
+		dropAcks: opts.DropAcks,
+	}
+
+	s.svr = server.New(server.Options{
+		Handler:   s.handle,
+		SharedKey: opts.SharedKey,
+		Users:     opts.Users,
+		Hostname:  "fluenttest",
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("fluenttest: failed to listen on a port: %v", err))
+	}
+
+	s.Addr = l.Addr().String()
+
+	go func() {
+		_ = s.svr.Serve(l)
+	}()
+
+	s.httpSvr = httptest.NewServer(s.svr)
+	s.URL = "ws" + strings.TrimPrefix(s.httpSvr.URL, "http")
+
+	return s
+}
+
+// Close shuts down the server and closes all connections.
+func (s *Server) Close() {
+	_ = s.svr.Close()
+	s.httpSvr.Close()
+}
+
+func (s *Server) handle(msg *server.Message) error {
+	s.lock.Lock()
+	s.messages = append(s.messages, msg)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	delay, drop := s.ackDelay, s.dropAcks
+	s.lock.Unlock()
+
+	if drop {
+		return errAckDropped
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return nil
+}
+
+// SetAckDelay changes the delay applied to subsequent acks.
+func (s *Server) SetAckDelay(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.ackDelay = d
+}
+
+// SetDropAcks enables or disables dropping subsequent acks.
+func (s *Server) SetDropAcks(drop bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.dropAcks = drop
+}
+
+// ConnFactory returns a factory for client.Client that dials the server.
+func (s *Server) ConnFactory() *client.ConnFactory {
+	return &client.ConnFactory{
+		Network: "tcp",
+		Address: s.Addr,
+	}
+}
+
+// WSConnectionFactory returns a factory for client.WSClient that dials
+// the server.
+func (s *Server) WSConnectionFactory() *client.DefaultWSConnectionFactory {
+	return &client.DefaultWSConnectionFactory{
+		URL: s.URL,
+	}
+}
+
+// PipeFactory returns a factory for client.Client whose connections are
+// served in memory through net.Pipe, without a network round trip.
+func (s *Server) PipeFactory() client.ConnectionFactory {
+	return &pipeFactory{svr: s.svr}
+}
+
+type pipeFactory struct {
+	svr *server.Server
+}
+
+func (f *pipeFactory) New() (net.Conn, error) {
+	clientSide, serverSide := net.Pipe()
+
+	go func() {
+		_ = f.svr.ServeConn(serverSide)
+	}()
+
+	return clientSide, nil
+}
+
+// Messages returns every message received so far, in order of receipt.
+func (s *Server) Messages() []*server.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]*server.Message(nil), s.messages...)
+}
+
+// Tags returns the tags of all messages received so far, without
+// duplicates, in order of first receipt.
+func (s *Server) Tags() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		tags []string
+		seen = map[string]bool{}
+	)
+
+	for _, msg := range s.messages {
+		if !seen[msg.Tag] {
+			seen[msg.Tag] = true
+			tags = append(tags, msg.Tag)
+		}
+	}
+
+	return tags
+}
+
+// Events returns all events received with the tag, across messages.
+func (s *Server) Events(tag string) protocol.EntryList {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.events(tag)
+}
+
+func (s *Server) events(tag string) protocol.EntryList {
+	var el protocol.EntryList
+
+	for _, msg := range s.messages {
+		if msg.Tag == tag {
+			el = append(el, msg.Entries...)
+		}
+	}
+
+	return el
+}
+
+// WaitForEvents waits until at least n events with the tag have been
+// received and returns them. It returns an error with the events received
+// so far if the timeout expires first.
+func (s *Server) WaitForEvents(tag string, n int, timeout time.Duration) (protocol.EntryList, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.lock.Lock()
+		el := s.events(tag)
+		changed := s.changed
+		s.lock.Unlock()
+
+		if len(el) >= n {
+			return el, nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return el, fmt.Errorf("timed out waiting for %d events with tag %q, got %d", n, tag, len(el))
+		}
+	}
+}
+
+// Reset discards all received messages.
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.messages = nil
+}
@@ -0,0 +1,185 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package fluenttest_test
+
+import (
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/fluenttest"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		opts    fluenttest.Options
+		svr     *fluenttest.Server
+		c       *client.Client
+		factory client.ConnectionFactory
+		record  map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		opts = fluenttest.Options{}
+		factory = nil
+		record = map[string]interface{}{"first": "Sir", "last": "Gawain"}
+	})
+
+	JustBeforeEach(func() {
+		svr = fluenttest.NewServer(opts)
+
+		if factory == nil {
+			factory = svr.ConnFactory()
+		}
+
+		c = client.New(client.ConnectionOptions{
+			Factory:           factory,
+			ConnectionTimeout: time.Second,
+			AuthInfo:          client.AuthInfo{SharedKey: opts.SharedKey},
+		})
+
+		Expect(c.Connect()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		_ = c.Disconnect()
+		svr.Close()
+	})
+
+	It("records events by tag", func() {
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+		Expect(c.SendForward("bar", protocol.EntryList{
+			{Timestamp: protocol.EventTimeNow(), Record: record},
+			{Timestamp: protocol.EventTimeNow(), Record: record},
+		})).To(Succeed())
+
+		el, err := svr.WaitForEvents("bar", 2, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(el).To(HaveLen(2))
+
+		el, err = svr.WaitForEvents("foo", 1, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(el[0].Record).To(HaveKeyWithValue("last", "Gawain"))
+
+		Expect(svr.Tags()).To(Equal([]string{"foo", "bar"}))
+		Expect(svr.Messages()).To(HaveLen(2))
+
+		svr.Reset()
+		Expect(svr.Events("foo")).To(BeEmpty())
+	})
+
+	It("times out waiting for events", func() {
+		Expect(c.SendMessage("foo", record)).To(Succeed())
+
+		el, err := svr.WaitForEvents("foo", 2, 50*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("timed out")))
+		Expect(el).To(HaveLen(1))
+	})
+
+	It("acks messages", func() {
+		c.RequireAck = true
+		Expect(c.SendMessageExt("foo", record)).To(Succeed())
+	})
+
+	When("acks are dropped", func() {
+		BeforeEach(func() {
+			opts.DropAcks = true
+		})
+
+		It("records the message but never acks", func() {
+			c.RequireAck = true
+			c.Timeout = 50 * time.Millisecond
+			Expect(c.SendMessageExt("foo", record)).ToNot(Succeed())
+			Expect(svr.Events("foo")).To(HaveLen(1))
+
+			svr.SetDropAcks(false)
+			Expect(c.Reconnect()).To(Succeed())
+			Expect(c.SendMessageExt("foo", record)).To(Succeed())
+		})
+	})
+
+	When("acks are delayed", func() {
+		BeforeEach(func() {
+			opts.AckDelay = 100 * time.Millisecond
+		})
+
+		It("delays the ack", func() {
+			c.RequireAck = true
+			start := time.Now()
+			Expect(c.SendMessageExt("foo", record)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+		})
+	})
+
+	When("the handshake is required", func() {
+		BeforeEach(func() {
+			opts.SharedKey = []byte("thisisasharedkey")
+		})
+
+		It("completes the handshake", func() {
+			Expect(c.TransportPhase()).To(BeFalse())
+			Expect(c.Handshake()).To(Succeed())
+			Expect(c.SendMessage("foo", record)).To(Succeed())
+
+			_, err := svr.WaitForEvents("foo", 1, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	When("connecting in memory", func() {
+		JustBeforeEach(func() {
+			_ = c.Disconnect()
+			c.ConnectionFactory = svr.PipeFactory()
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		It("receives events", func() {
+			c.RequireAck = true
+			Expect(c.SendPacked("foo", protocol.EntryList{
+				{Timestamp: protocol.EventTimeNow(), Record: record},
+			})).To(Succeed())
+
+			Expect(svr.Events("foo")).To(HaveLen(1))
+		})
+	})
+
+	Describe("websocket clients", func() {
+		It("receives events", func() {
+			wsc := client.NewWS(client.WSConnectionOptions{
+				Factory: svr.WSConnectionFactory(),
+			})
+			Expect(wsc.Connect()).To(Succeed())
+
+			defer wsc.Disconnect()
+
+			Expect(wsc.Send(protocol.NewMessage("ws", record))).To(Succeed())
+
+			_, err := svr.WaitForEvents("ws", 1, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
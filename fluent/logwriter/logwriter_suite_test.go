@@ -0,0 +1,13 @@
+package logwriter_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLogwriter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logwriter Suite")
+}
@@ -0,0 +1,337 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package logwriter provides an io.Writer that sends the lines written to
+// it as Fluent records, for libraries that only log to an io.Writer.
+package logwriter
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+const (
+	// DefaultKey is the record key of the text of an event.
+	DefaultKey = "log"
+	// DefaultFlushTimeout is how long an incomplete event is held for
+	// more lines.
+	DefaultFlushTimeout = time.Second
+	// DefaultMaxLines is the maximum number of lines in an event.
+	DefaultMaxLines = 1000
+)
+
+// Multiline decides which lines are aggregated into a single event. A
+// line continues the current event if it matches Continuation, when set,
+// and does not match FirstLine, when set.
+type Multiline struct {
+	// FirstLine matches the first line of an event.
+	FirstLine *regexp.Regexp
+	// Continuation matches the lines that continue an event.
+	Continuation *regexp.Regexp
+}
+
+var (
+	// GoPanic joins the goroutine dumps of Go panics and fatal errors to
+	// the "panic:" or "fatal error:" line that starts them.
+	GoPanic = &Multiline{
+		Continuation: regexp.MustCompile(`^(?:\s|$|goroutine \d+ \[|\S+\(.*\)$|created by |\[signal |exit status )`),
+	}
+	// JavaStackTrace joins Java stack traces, including their causes, to
+	// the exception line that starts them.
+	JavaStackTrace = &Multiline{
+		Continuation: regexp.MustCompile(`^(?:\s|Caused by: |Suppressed: )`),
+	}
+)
+
+func (m *Multiline) continues(line []byte) bool {
+	if m.FirstLine != nil && m.FirstLine.Match(line) {
+		return false
+	}
+
+	if m.Continuation != nil {
+		return m.Continuation.Match(line)
+	}
+
+	return m.FirstLine != nil
+}
+
+// Options configures a Writer.
+type Options struct {
+	// Client sends the records. It must be connected.
+	Client client.MessageClient
+	Tag    string
+	// Key is the record key of the text. Defaults to DefaultKey.
+	Key string
+	// Fields are added to every record.
+	Fields map[string]interface{}
+	// Multiline, if set, aggregates lines into events. Otherwise every
+	// line is an event.
+	Multiline *Multiline
+	// FlushTimeout is how long an event is held for more lines, or a line
+	// for its end, before it is sent. Defaults to DefaultFlushTimeout.
+	FlushTimeout time.Duration
+	// MaxLines limits the number of lines in an event. Defaults to
+	// DefaultMaxLines.
+	MaxLines int
+	// ParseJSON sends events that are JSON objects as structured records.
+	// Fields are added to them unless the object has the same keys.
+	ParseJSON bool
+	// OnError, if set, is called with the errors of events sent when the
+	// flush timeout expires, which cannot be returned by Write.
+	OnError func(error)
+}
+
+// Writer is an io.Writer that turns each line, or each multiline event,
+// into a record such as {"log": "line"} and sends it as a MessageExt.
+// The time of an event is the time its first line was written.
+type Writer struct {
+	client       client.MessageClient
+	tag          string
+	key          string
+	fields       map[string]interface{}
+	multiline    *Multiline
+	flushTimeout time.Duration
+	maxLines     int
+	parseJSON    bool
+	onError      func(error)
+
+	lock sync.Mutex
+	// partial is a line without its newline yet.
+	partial   []byte
+	partialAt time.Time
+	// event holds the complete lines of the pending event.
+	event   bytes.Buffer
+	lines   int
+	started time.Time
+	timer   *time.Timer
+}
+
+// New returns a Writer configured by opts.
+func New(opts Options) *Writer {
+	w := &Writer{
+		client:       opts.Client,
+		tag:          opts.Tag,
+		key:          opts.Key,
+		fields:       opts.Fields,
+		multiline:    opts.Multiline,
+		flushTimeout: opts.FlushTimeout,
+		maxLines:     opts.MaxLines,
+		parseJSON:    opts.ParseJSON,
+		onError:      opts.OnError,
+	}
+
+	if len(w.key) == 0 {
+		w.key = DefaultKey
+	}
+
+	if w.flushTimeout <= 0 {
+		w.flushTimeout = DefaultFlushTimeout
+	}
+
+	if w.maxLines <= 0 {
+		w.maxLines = DefaultMaxLines
+	}
+
+	return w
+}
+
+// Write sends the events completed by p and holds the rest until more
+// is written, Flush is called, or the flush timeout expires. It returns
+// the first error from the client.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var (
+		n   = len(p)
+		now = time.Now()
+		err error
+	)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			if len(w.partial) == 0 {
+				w.partialAt = now
+			}
+
+			w.partial = append(w.partial, p...)
+
+			break
+		}
+
+		line, at := p[:i], now
+		if len(w.partial) > 0 {
+			line, at = append(w.partial, line...), w.partialAt
+			w.partial = w.partial[:0]
+		}
+
+		if lerr := w.addLine(line, at); err == nil {
+			err = lerr
+		}
+
+		p = p[i+1:]
+	}
+
+	w.resetTimer()
+
+	return n, err
+}
+
+// addLine adds a complete line written at the given time, sending the
+// pending event first if the line does not continue it. It must be called
+// with the lock held.
+func (w *Writer) addLine(line []byte, at time.Time) error {
+	var err error
+
+	line = bytes.TrimSuffix(line, []byte("\r"))
+
+	if w.lines > 0 && (w.multiline == nil || !w.multiline.continues(line)) {
+		err = w.send()
+	}
+
+	if w.lines == 0 {
+		w.started = at
+	} else {
+		w.event.WriteByte('\n')
+	}
+
+	w.event.Write(line)
+	w.lines++
+
+	if w.multiline == nil || w.lines >= w.maxLines {
+		if serr := w.send(); err == nil {
+			err = serr
+		}
+	}
+
+	return err
+}
+
+// send sends the pending event. It must be called with the lock held.
+func (w *Writer) send() error {
+	if w.lines == 0 {
+		return nil
+	}
+
+	text := bytes.TrimRight(w.event.Bytes(), "\n")
+	record := w.record(text)
+
+	w.event.Reset()
+	w.lines = 0
+
+	return w.client.Send(&protocol.MessageExt{
+		Tag:       w.tag,
+		Timestamp: protocol.EventTime{Time: w.started.UTC()},
+		Record:    record,
+	})
+}
+
+func (w *Writer) record(text []byte) map[string]interface{} {
+	var record map[string]interface{}
+
+	if w.parseJSON && bytes.HasPrefix(bytes.TrimSpace(text), []byte("{")) {
+		if err := json.Unmarshal(text, &record); err != nil {
+			record = nil
+		}
+	}
+
+	if record == nil {
+		record = map[string]interface{}{w.key: string(text)}
+	}
+
+	for k, v := range w.fields {
+		if _, ok := record[k]; !ok {
+			record[k] = v
+		}
+	}
+
+	return record
+}
+
+// resetTimer must be called with the lock held.
+func (w *Writer) resetTimer() {
+	if w.lines == 0 && len(w.partial) == 0 {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+
+		return
+	}
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.flushTimeout, w.timeout)
+		return
+	}
+
+	w.timer.Reset(w.flushTimeout)
+}
+
+func (w *Writer) timeout() {
+	if err := w.Flush(); err != nil && w.onError != nil {
+		w.onError(err)
+	}
+}
+
+// Flush sends the pending event, including a line that has not ended.
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var err error
+
+	if len(w.partial) > 0 {
+		err = w.addLine(w.partial, w.partialAt)
+		w.partial = w.partial[:0]
+	}
+
+	if serr := w.send(); err == nil {
+		err = serr
+	}
+
+	w.resetTimer()
+
+	return err
+}
+
+// Close flushes the pending event and stops the flush timer. It does not
+// disconnect the client.
+func (w *Writer) Close() error {
+	err := w.Flush()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	return err
+}
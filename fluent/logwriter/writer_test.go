@@ -0,0 +1,241 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package logwriter_test
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/aanujj/fluent-forward-go/fluent/logwriter"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		mc   *clientfakes.FakeMessageClient
+		opts logwriter.Options
+	)
+
+	sent := func() []*protocol.MessageExt {
+		msgs := make([]*protocol.MessageExt, mc.SendCallCount())
+		for i := range msgs {
+			msgs[i] = mc.SendArgsForCall(i).(*protocol.MessageExt)
+		}
+
+		return msgs
+	}
+
+	logs := func() []interface{} {
+		var lines []interface{}
+		for _, msg := range sent() {
+			lines = append(lines, msg.Record.(map[string]interface{})["log"])
+		}
+
+		return lines
+	}
+
+	BeforeEach(func() {
+		mc = &clientfakes.FakeMessageClient{}
+		opts = logwriter.Options{
+			Client:       mc,
+			Tag:          "app",
+			FlushTimeout: time.Hour,
+		}
+	})
+
+	It("sends every line as a record with the static fields", func() {
+		opts.Fields = map[string]interface{}{"host": "h1"}
+		w := logwriter.New(opts)
+
+		n, err := w.Write([]byte("first\r\nsecond\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(14))
+
+		msgs := sent()
+		Expect(msgs).To(HaveLen(2))
+		Expect(msgs[0].Tag).To(Equal("app"))
+		Expect(time.Since(msgs[0].Timestamp.Time)).To(BeNumerically("<", time.Second))
+		Expect(msgs[0].Record).To(Equal(map[string]interface{}{"log": "first", "host": "h1"}))
+		Expect(msgs[1].Record).To(Equal(map[string]interface{}{"log": "second", "host": "h1"}))
+	})
+
+	It("holds a line until it ends", func() {
+		w := logwriter.New(opts)
+
+		_, _ = w.Write([]byte("hel"))
+		Expect(mc.SendCallCount()).To(Equal(0))
+
+		_, _ = w.Write([]byte("lo\nwor"))
+		Expect(logs()).To(Equal([]interface{}{"hello"}))
+
+		Expect(w.Flush()).To(Succeed())
+		Expect(logs()).To(Equal([]interface{}{"hello", "wor"}))
+	})
+
+	It("uses the configured key", func() {
+		opts.Key = "message"
+		w := logwriter.New(opts)
+
+		_, _ = w.Write([]byte("hello\n"))
+		Expect(sent()[0].Record).To(Equal(map[string]interface{}{"message": "hello"}))
+	})
+
+	It("works as the output of a log.Logger", func() {
+		logger := log.New(logwriter.New(opts), "", 0)
+		logger.Printf("count=%d", 3)
+
+		Expect(logs()).To(Equal([]interface{}{"count=3"}))
+	})
+
+	It("returns errors from the client", func() {
+		mc.SendReturns(errors.New("nope"))
+		w := logwriter.New(opts)
+
+		n, err := w.Write([]byte("a\nb\n"))
+		Expect(err).To(MatchError("nope"))
+		Expect(n).To(Equal(4))
+		Expect(mc.SendCallCount()).To(Equal(2))
+	})
+
+	When("parsing JSON", func() {
+		BeforeEach(func() {
+			opts.ParseJSON = true
+			opts.Fields = map[string]interface{}{"host": "h1", "level": "info"}
+		})
+
+		It("sends JSON objects as structured records", func() {
+			w := logwriter.New(opts)
+
+			_, _ = w.Write([]byte(`{"level":"error","msg":"boom","n":2}` + "\n"))
+			_, _ = w.Write([]byte("{not json\n"))
+
+			msgs := sent()
+			Expect(msgs[0].Record).To(Equal(map[string]interface{}{
+				"level": "error",
+				"msg":   "boom",
+				"n":     2.0,
+				"host":  "h1",
+			}))
+			Expect(msgs[1].Record).To(Equal(map[string]interface{}{
+				"log":   "{not json",
+				"host":  "h1",
+				"level": "info",
+			}))
+		})
+	})
+
+	When("aggregating multiline events", func() {
+		It("starts events at lines that match FirstLine", func() {
+			opts.Multiline = &logwriter.Multiline{
+				FirstLine: regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `),
+			}
+			w := logwriter.New(opts)
+
+			_, _ = w.Write([]byte("2024-01-02 one\n  detail\n2024-01-02 two\n"))
+			Expect(logs()).To(Equal([]interface{}{"2024-01-02 one\n  detail"}))
+
+			Expect(w.Close()).To(Succeed())
+			Expect(logs()).To(Equal([]interface{}{"2024-01-02 one\n  detail", "2024-01-02 two"}))
+		})
+
+		It("aggregates Go panics", func() {
+			opts.Multiline = logwriter.GoPanic
+			w := logwriter.New(opts)
+
+			panicked := "panic: boom\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.(*T).run(...)\n" +
+				"\t/src/main.go:12\n" +
+				"main.main()\n" +
+				"\t/src/main.go:20 +0x25\n" +
+				"exit status 2"
+
+			_, _ = fmt.Fprintf(w, "starting\n%s\nrestarted\n", panicked)
+			Expect(w.Flush()).To(Succeed())
+
+			Expect(logs()).To(Equal([]interface{}{"starting", panicked, "restarted"}))
+		})
+
+		It("aggregates Java stack traces", func() {
+			opts.Multiline = logwriter.JavaStackTrace
+			w := logwriter.New(opts)
+
+			trace := "Exception in thread \"main\" java.lang.IllegalStateException: outer\n" +
+				"\tat com.example.App.run(App.java:10)\n" +
+				"Caused by: java.lang.NullPointerException\n" +
+				"\tat com.example.App.load(App.java:20)\n" +
+				"\t... 1 more"
+
+			_, _ = fmt.Fprintf(w, "%s\nINFO done\n", trace)
+			Expect(w.Flush()).To(Succeed())
+
+			Expect(logs()).To(Equal([]interface{}{trace, "INFO done"}))
+		})
+
+		It("sends events that reach MaxLines", func() {
+			opts.Multiline = logwriter.JavaStackTrace
+			opts.MaxLines = 2
+			w := logwriter.New(opts)
+
+			_, _ = w.Write([]byte("a\n b\n c\n"))
+			Expect(logs()).To(Equal([]interface{}{"a\n b"}))
+		})
+
+		It("sends pending events when the flush timeout expires", func() {
+			var (
+				lock sync.Mutex
+				errs []error
+			)
+
+			mc.SendReturns(errors.New("nope"))
+			opts.Multiline = logwriter.JavaStackTrace
+			opts.FlushTimeout = 20 * time.Millisecond
+			opts.OnError = func(err error) {
+				lock.Lock()
+				defer lock.Unlock()
+				errs = append(errs, err)
+			}
+			w := logwriter.New(opts)
+
+			_, _ = w.Write([]byte("a\n b\npartial"))
+			Expect(mc.SendCallCount()).To(Equal(0))
+
+			Eventually(mc.SendCallCount).Should(Equal(2))
+			Expect(logs()).To(Equal([]interface{}{"a\n b", "partial"}))
+			Eventually(func() []error {
+				lock.Lock()
+				defer lock.Unlock()
+				return errs
+			}).Should(ConsistOf(MatchError("nope")))
+		})
+	})
+})
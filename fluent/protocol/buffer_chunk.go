@@ -0,0 +1,181 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// bufferMetaHeader prefixes the metadata files written by Fluentd v1.
+// It is followed by the length of the metadata as a big-endian uint32.
+var bufferMetaHeader = []byte{0xc1, 0x00}
+
+// BufferChunkMetadata is the content of a Fluentd file buffer ".meta" file.
+type BufferChunkMetadata struct {
+	// ID is the unique ID of the chunk, also encoded in its file name.
+	ID []byte
+	// Size is the number of events in the chunk.
+	Size       int
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+	// Timekey, Tag, and Variables are the chunk keys. Tag is empty unless
+	// the buffer is keyed by tag.
+	Timekey   int64
+	Tag       string
+	Variables map[string]interface{}
+	Seq       int64
+}
+
+// UnmarshalMsg decodes the metadata. It accepts the current format, which
+// is prefixed with a header and length, as well as the bare msgpack map
+// written by earlier Fluentd versions.
+func (m *BufferChunkMetadata) UnmarshalMsg(bits []byte) ([]byte, error) {
+	if len(bits) > 6 && bytes.Equal(bits[:2], bufferMetaHeader) {
+		sz := binary.BigEndian.Uint32(bits[2:6])
+		if uint64(len(bits)-6) < uint64(sz) {
+			return bits, msgp.ErrShortBytes
+		}
+
+		left, err := m.unmarshalMap(bits[6 : 6+sz])
+		if err != nil {
+			return left, err
+		}
+
+		return bits[6+sz:], nil
+	}
+
+	return m.unmarshalMap(bits)
+}
+
+func (m *BufferChunkMetadata) unmarshalMap(bits []byte) ([]byte, error) {
+	sz, bits, err := msgp.ReadMapHeaderBytes(bits)
+	if err != nil {
+		return bits, msgp.WrapError(err, "Metadata")
+	}
+
+	var key []byte
+
+	for i := uint32(0); i < sz; i++ {
+		if key, bits, err = msgp.ReadMapKeyZC(bits); err != nil {
+			return bits, msgp.WrapError(err, "Metadata")
+		}
+
+		if msgp.NextType(bits) == msgp.NilType {
+			bits, err = msgp.ReadNilBytes(bits)
+			continue
+		}
+
+		switch string(key) {
+		case "id":
+			m.ID, bits, err = msgp.ReadBytesBytes(bits, nil)
+		case "s":
+			m.Size, bits, err = msgp.ReadIntBytes(bits)
+		case "c":
+			m.CreatedAt, bits, err = unmarshalUnixTime(bits)
+		case "m":
+			m.ModifiedAt, bits, err = unmarshalUnixTime(bits)
+		case "timekey":
+			m.Timekey, bits, err = msgp.ReadInt64Bytes(bits)
+		case "tag":
+			m.Tag, bits, err = msgp.ReadStringBytes(bits)
+		case "variables":
+			m.Variables, bits, err = msgp.ReadMapStrIntfBytes(bits, nil)
+		case "seq":
+			m.Seq, bits, err = msgp.ReadInt64Bytes(bits)
+		default:
+			bits, err = msgp.Skip(bits)
+		}
+
+		if err != nil {
+			return bits, msgp.WrapError(err, "Metadata", string(key))
+		}
+	}
+
+	return bits, nil
+}
+
+func unmarshalUnixTime(bits []byte) (time.Time, []byte, error) {
+	et, bits, err := unmarshalTimestamp(bits)
+
+	return et.Time, bits, err
+}
+
+// BufferChunk is a chunk recovered from a Fluentd file buffer.
+type BufferChunk struct {
+	// Tag is the tag from the metadata, if any.
+	Tag      string
+	Entries  EntryList
+	Metadata BufferChunkMetadata
+}
+
+// ReadBufferChunk decodes a Fluentd file buffer chunk: the event stream
+// from a ".log" file and, unless meta is nil, the metadata from its
+// ".log.meta" file. Chunks compressed with gzip are decompressed.
+func ReadBufferChunk(chunk, meta io.Reader) (*BufferChunk, error) {
+	bc := &BufferChunk{}
+
+	if meta != nil {
+		bits, err := io.ReadAll(meta)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = bc.Metadata.UnmarshalMsg(bits); err != nil {
+			return nil, fmt.Errorf("metadata: %w", err)
+		}
+
+		bc.Tag = bc.Metadata.Tag
+	}
+
+	stream, err := io.ReadAll(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stream) > 1 && stream[0] == 0x1f && stream[1] == 0x8b {
+		if stream, err = gunzip(stream); err != nil {
+			return nil, fmt.Errorf("chunk: %w", err)
+		}
+	}
+
+	bc.Entries, err = UnmarshalEventStream(stream)
+	if err != nil {
+		// a crash can leave a partially written event at the end
+		if errors.Is(err, msgp.ErrShortBytes) {
+			return bc, fmt.Errorf("chunk: truncated after %d events: %w", len(bc.Entries), err)
+		}
+
+		return nil, fmt.Errorf("chunk: %w", err)
+	}
+
+	return bc, nil
+}
@@ -0,0 +1,135 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package protocol_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+var _ = Describe("ReadBufferChunk", func() {
+	var (
+		meta   []byte
+		stream []byte
+		ts     time.Time
+	)
+
+	BeforeEach(func() {
+		ts = time.Unix(1700000000, 0)
+
+		meta = msgp.AppendMapHeader(nil, 6)
+		meta = msgp.AppendString(meta, "id")
+		meta = msgp.AppendBytes(meta, []byte{0xde, 0xad, 0xbe, 0xef})
+		meta = msgp.AppendString(meta, "s")
+		meta = msgp.AppendInt(meta, 2)
+		meta = msgp.AppendString(meta, "c")
+		meta = msgp.AppendInt64(meta, ts.Unix())
+		meta = msgp.AppendString(meta, "m")
+		meta = msgp.AppendInt64(meta, ts.Unix()+5)
+		meta = msgp.AppendString(meta, "tag")
+		meta = msgp.AppendString(meta, "foo.bar")
+		meta = msgp.AppendString(meta, "timekey")
+		meta = msgp.AppendNil(meta)
+
+		entries := protocol.EntryList{
+			{Timestamp: protocol.EventTime{Time: ts}, Record: map[string]interface{}{"first": "Sir"}},
+			{Timestamp: protocol.EventTime{Time: ts.Add(time.Second)}, Record: map[string]interface{}{"last": "Gawain"}},
+		}
+
+		var err error
+		stream, err = entries.MarshalPacked()
+		Expect(err).ToNot(HaveOccurred())
+
+		// Fluentd writes integer timestamps unless time_as_integer is false
+		stream = msgp.AppendArrayHeader(stream, 2)
+		stream = msgp.AppendInt64(stream, ts.Unix()+2)
+		stream = msgp.AppendMapStrStr(stream, map[string]string{"third": "Knight"})
+	})
+
+	withHeader := func(bits []byte) []byte {
+		out := []byte{0xc1, 0x00, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(out[2:], uint32(len(bits)))
+
+		return append(out, bits...)
+	}
+
+	It("reads the chunk and current metadata", func() {
+		bc, err := protocol.ReadBufferChunk(bytes.NewReader(stream), bytes.NewReader(withHeader(meta)))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(bc.Tag).To(Equal("foo.bar"))
+		Expect(bc.Metadata.ID).To(Equal([]byte{0xde, 0xad, 0xbe, 0xef}))
+		Expect(bc.Metadata.Size).To(Equal(2))
+		Expect(bc.Metadata.CreatedAt.Equal(ts)).To(BeTrue())
+		Expect(bc.Metadata.ModifiedAt.Equal(ts.Add(5 * time.Second))).To(BeTrue())
+
+		Expect(bc.Entries).To(HaveLen(3))
+		Expect(bc.Entries[0].Record).To(Equal(map[string]interface{}{"first": "Sir"}))
+		Expect(bc.Entries[2].Timestamp.Equal(ts.Add(2 * time.Second))).To(BeTrue())
+	})
+
+	It("reads metadata written by earlier versions", func() {
+		bc, err := protocol.ReadBufferChunk(bytes.NewReader(stream), bytes.NewReader(meta))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bc.Tag).To(Equal("foo.bar"))
+	})
+
+	It("reads chunks without metadata", func() {
+		bc, err := protocol.ReadBufferChunk(bytes.NewReader(stream), nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bc.Tag).To(BeEmpty())
+		Expect(bc.Entries).To(HaveLen(3))
+	})
+
+	It("reads gzipped chunks", func() {
+		var buf bytes.Buffer
+
+		// Fluentd appends a gzip member per write
+		for _, part := range [][]byte{stream[:len(stream)/2], stream[len(stream)/2:]} {
+			zw := gzip.NewWriter(&buf)
+			_, err := zw.Write(part)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zw.Close()).To(Succeed())
+		}
+
+		bc, err := protocol.ReadBufferChunk(&buf, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bc.Entries).To(HaveLen(3))
+	})
+
+	It("returns the complete events of a truncated chunk", func() {
+		bc, err := protocol.ReadBufferChunk(bytes.NewReader(stream[:len(stream)-3]), nil)
+		Expect(err).To(MatchError(ContainSubstring("truncated after 2 events")))
+		Expect(bc.Entries).To(HaveLen(2))
+	})
+})
@@ -26,9 +26,13 @@ package protocol
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	"github.com/tinylib/msgp/msgp"
@@ -43,7 +47,20 @@ type ChunkEncoder interface {
 	EncodeMsg(*msgp.Writer) error
 }
 
-func makeChunkID() (string, error) {
+// ChunkIDGenerator generates the chunk IDs of messages that have none.
+type ChunkIDGenerator interface {
+	// ChunkID returns a chunk ID for msg, which does not have one yet.
+	ChunkID(msg msgp.Encodable) (string, error)
+}
+
+// DefaultChunkIDGenerator is used by Chunk and by ChunkWith when no
+// generator is given.
+var DefaultChunkIDGenerator ChunkIDGenerator = RandomChunkIDGenerator{}
+
+// RandomChunkIDGenerator generates the base64 encoding of a random UUID.
+type RandomChunkIDGenerator struct{}
+
+func (RandomChunkIDGenerator) ChunkID(msgp.Encodable) (string, error) {
 	b, err := uuid.New().MarshalBinary()
 	if err != nil {
 		return "", err
@@ -52,6 +69,217 @@ func makeChunkID() (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
+// SequentialChunkIDGenerator generates Prefix followed by 1, 2, 3 and so on,
+// which is mostly useful in tests. It is safe for concurrent use.
+type SequentialChunkIDGenerator struct {
+	Prefix string
+	n      uint64
+}
+
+func (g *SequentialChunkIDGenerator) ChunkID(msgp.Encodable) (string, error) {
+	return g.Prefix + strconv.FormatUint(atomic.AddUint64(&g.n, 1), 10), nil
+}
+
+// ContentHashChunkIDGenerator generates the base64 encoding of the first 16
+// bytes of the SHA-256 of the encoded message, which covers its tag,
+// timestamps and records. Map entries are hashed in the order of their
+// encoded keys, so the ID does not depend on map iteration order; the event
+// stream of a PackedForwardMessage is hashed as is. The same message sent
+// again, even by another process, gets the same chunk ID, so that a server
+// can drop the copy. Distinct messages with identical content get the same
+// ID too, and may be dropped as well. Empty options are hashed like missing
+// ones.
+type ContentHashChunkIDGenerator struct{}
+
+func (ContentHashChunkIDGenerator) ChunkID(msg msgp.Encodable) (string, error) {
+	bits, err := Encode(withoutEmptyOptions(msg))
+	if err != nil {
+		return "", err
+	}
+
+	canonical, _, err := appendCanonical(nil, bits)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+
+	return base64.StdEncoding.EncodeToString(sum[:16]), nil
+}
+
+// withoutEmptyOptions returns a copy of msg without its options if they are
+// empty, since they are encoded differently from missing ones. Other
+// messages are returned as is.
+func withoutEmptyOptions(msg msgp.Encodable) msgp.Encodable {
+	empty := func(opts *MessageOptions) bool {
+		return opts != nil && *opts == (MessageOptions{})
+	}
+
+	switch m := msg.(type) {
+	case *Message:
+		if empty(m.Options) {
+			c := *m
+			c.Options = nil
+
+			return &c
+		}
+	case *MessageExt:
+		if empty(m.Options) {
+			c := *m
+			c.Options = nil
+
+			return &c
+		}
+	case *ForwardMessage:
+		if empty(m.Options) {
+			c := *m
+			c.Options = nil
+
+			return &c
+		}
+	case *PackedForwardMessage:
+		if empty(m.Options) {
+			c := *m
+			c.Options = nil
+
+			return &c
+		}
+	}
+
+	return msg
+}
+
+// appendCanonical appends the object at the start of b to dst, with the
+// entries of every map sorted by their encoded key, and returns the rest of
+// b.
+func appendCanonical(dst, b []byte) ([]byte, []byte, error) {
+	switch msgp.NextType(b) {
+	case msgp.MapType:
+		sz, rest, err := msgp.ReadMapHeaderBytes(b)
+		if err != nil {
+			return dst, b, err
+		}
+
+		entries := make([][2][]byte, sz)
+		for i := range entries {
+			for j := range entries[i] {
+				if entries[i][j], rest, err = appendCanonical(nil, rest); err != nil {
+					return dst, b, err
+				}
+			}
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i][0], entries[j][0]) < 0
+		})
+
+		dst = msgp.AppendMapHeader(dst, sz)
+		for _, e := range entries {
+			dst = append(append(dst, e[0]...), e[1]...)
+		}
+
+		return dst, rest, nil
+	case msgp.ArrayType:
+		sz, rest, err := msgp.ReadArrayHeaderBytes(b)
+		if err != nil {
+			return dst, b, err
+		}
+
+		dst = msgp.AppendArrayHeader(dst, sz)
+		for i := uint32(0); i < sz; i++ {
+			if dst, rest, err = appendCanonical(dst, rest); err != nil {
+				return dst, b, err
+			}
+		}
+
+		return dst, rest, nil
+	}
+
+	rest, err := msgp.Skip(b)
+	if err != nil {
+		return dst, b, err
+	}
+
+	return append(dst, b[:len(b)-len(rest)]...), rest, nil
+}
+
+// ChunkWith returns the chunk ID of e like e.Chunk, except that a missing ID
+// is generated with gen if e supports it. All the message types of this
+// package do, except RawMessage, whose chunk is read-only.
+func ChunkWith(e ChunkEncoder, gen ChunkIDGenerator) (string, error) {
+	if c, ok := e.(interface {
+		ChunkWith(ChunkIDGenerator) (string, error)
+	}); ok {
+		return c.ChunkWith(gen)
+	}
+
+	return e.Chunk()
+}
+
+// Encode returns the encoding of e, for clients that send the same bytes
+// to several destinations or more than once.
+func Encode(e msgp.Encodable) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := msgp.Encode(&buf, e); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EncodeChunked is like Encode, but first gives e a chunk ID with
+// ChunkWith, so that every copy of the bytes carries the same ID.
+func EncodeChunked(e ChunkEncoder, gen ChunkIDGenerator) (chunk string, bits []byte, err error) {
+	if chunk, err = ChunkWith(e, gen); err != nil {
+		return "", nil, err
+	}
+
+	if bits, err = Encode(e); err != nil {
+		return "", nil, err
+	}
+
+	return chunk, bits, nil
+}
+
+// EncodeAcked is EncodeChunked if requireAck is set and Encode otherwise,
+// for clients that send the bytes to a destination that may require acks.
+// Such a destination fails the bytes of a message without a chunk ID.
+func EncodeAcked(e ChunkEncoder, requireAck bool, gen ChunkIDGenerator) ([]byte, error) {
+	if !requireAck {
+		return Encode(e)
+	}
+
+	_, bits, err := EncodeChunked(e, gen)
+
+	return bits, err
+}
+
+// chunkWith returns the chunk ID in *opts or, if there is none, generates
+// it for msg with gen and stores it in *opts.
+func chunkWith(opts **MessageOptions, msg msgp.Encodable, gen ChunkIDGenerator) (string, error) {
+	if *opts != nil && (*opts).Chunk != "" {
+		return (*opts).Chunk, nil
+	}
+
+	if gen == nil {
+		gen = DefaultChunkIDGenerator
+	}
+
+	// generate before adding options, so that msg is encoded as it was
+	chunk, err := gen.ChunkID(msg)
+	if err != nil {
+		return "", err
+	}
+
+	if *opts == nil {
+		*opts = &MessageOptions{}
+	}
+
+	(*opts).Chunk = chunk
+
+	return chunk, nil
+}
+
 //msgp:ignore GzipCompressor
 type ChunkReader struct {
 	br *bytes.Reader
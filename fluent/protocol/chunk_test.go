@@ -26,6 +26,8 @@ package protocol_test
 
 import (
 	"encoding/base64"
+	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -83,4 +85,117 @@ var _ = Describe("Chunk", func() {
 			})
 		})
 	})
+
+	Describe("ChunkIDGenerator", func() {
+		var record map[string]interface{}
+
+		BeforeEach(func() {
+			record = map[string]interface{}{}
+			for _, k := range "abcdefghij" {
+				record[string(k)] = int(k)
+			}
+		})
+
+		It("is used by ChunkWith for every message type", func() {
+			gen := &protocol.SequentialChunkIDGenerator{Prefix: "seq-"}
+
+			for i, ce := range []protocol.ChunkEncoder{&protocol.Message{}, &protocol.MessageExt{}, &protocol.ForwardMessage{}, &protocol.PackedForwardMessage{}} {
+				chunk, err := protocol.ChunkWith(ce, gen)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(chunk).To(Equal(fmt.Sprintf("seq-%d", i+1)))
+
+				// an existing chunk is kept
+				chunk, err = protocol.ChunkWith(ce, gen)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(chunk).To(Equal(fmt.Sprintf("seq-%d", i+1)))
+			}
+		})
+
+		It("reads the chunk of a RawMessage", func() {
+			msg := &protocol.Message{Options: &protocol.MessageOptions{Chunk: "Z2F3YWlu"}}
+			bits, err := msg.MarshalMsg(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			chunk, err := protocol.ChunkWith(protocol.RawMessage(bits), &protocol.SequentialChunkIDGenerator{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chunk).To(Equal("Z2F3YWlu"))
+		})
+
+		It("is used by EncodeChunked", func() {
+			gen := &protocol.SequentialChunkIDGenerator{Prefix: "seq-"}
+			msg := protocol.NewMessage("foo", record)
+
+			chunk, bits, err := protocol.EncodeChunked(msg, gen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chunk).To(Equal("seq-1"))
+
+			var decoded protocol.Message
+			_, err = decoded.UnmarshalMsg(bits)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded.Tag).To(Equal("foo"))
+			Expect(decoded.Record).To(HaveLen(len(record)))
+			Expect(decoded.Options.Chunk).To(Equal("seq-1"))
+		})
+
+		It("is used by EncodeAcked only if acks are required", func() {
+			gen := &protocol.SequentialChunkIDGenerator{Prefix: "seq-"}
+
+			bits, err := protocol.EncodeAcked(protocol.NewMessage("foo", record), false, gen)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = protocol.GetChunk(bits)
+			Expect(err).To(HaveOccurred())
+
+			bits, err = protocol.EncodeAcked(protocol.NewMessage("foo", record), true, gen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protocol.GetChunk(bits)).To(Equal("seq-1"))
+		})
+
+		Describe("ContentHashChunkIDGenerator", func() {
+			gen := protocol.ContentHashChunkIDGenerator{}
+
+			chunkOf := func(msg *protocol.Message) string {
+				chunk, err := msg.ChunkWith(gen)
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+				return chunk
+			}
+
+			It("generates the same ID for the same content", func() {
+				chunk := chunkOf(&protocol.Message{Tag: "foo", Timestamp: 1, Record: record})
+
+				for i := 0; i < 10; i++ {
+					Expect(chunkOf(&protocol.Message{Tag: "foo", Timestamp: 1, Record: record})).To(Equal(chunk))
+				}
+
+				b, err := base64.StdEncoding.DecodeString(chunk)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(HaveLen(16))
+			})
+
+			It("generates different IDs for different content", func() {
+				chunk := chunkOf(&protocol.Message{Tag: "foo", Timestamp: 1, Record: record})
+
+				Expect(chunkOf(&protocol.Message{Tag: "bar", Timestamp: 1, Record: record})).ToNot(Equal(chunk))
+				Expect(chunkOf(&protocol.Message{Tag: "foo", Timestamp: 2, Record: record})).ToNot(Equal(chunk))
+
+				record["a"] = "changed"
+				Expect(chunkOf(&protocol.Message{Tag: "foo", Timestamp: 1, Record: record})).ToNot(Equal(chunk))
+			})
+
+			It("generates the same ID for empty and missing options", func() {
+				chunk := chunkOf(&protocol.Message{Tag: "foo", Timestamp: 1, Record: record})
+
+				msg := &protocol.Message{Tag: "foo", Timestamp: 1, Record: record, Options: &protocol.MessageOptions{}}
+				Expect(chunkOf(msg)).To(Equal(chunk))
+				Expect(msg.Options.Chunk).To(Equal(chunk))
+
+				fm := &protocol.ForwardMessage{Tag: "foo", Entries: protocol.EntryList{{Timestamp: protocol.EventTime{Time: time.Unix(1, 0)}, Record: record}}}
+				fmChunk, err := gen.ChunkID(fm)
+				Expect(err).ToNot(HaveOccurred())
+
+				fm.Options = &protocol.MessageOptions{}
+				Expect(gen.ChunkID(fm)).To(Equal(fmChunk))
+			})
+		})
+	})
 })
@@ -0,0 +1,366 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// MessageMode identifies one of the Fluent message modes. See
+// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1#message-modes
+type MessageMode uint8
+
+const (
+	ModeUnknown MessageMode = iota
+	ModeMessage
+	ModeForward
+	ModePackedForward
+	ModeCompressedPackedForward
+)
+
+func (m MessageMode) String() string {
+	switch m {
+	case ModeMessage:
+		return "Message"
+	case ModeForward:
+		return "Forward"
+	case ModePackedForward:
+		return "PackedForward"
+	case ModeCompressedPackedForward:
+		return "CompressedPackedForward"
+	}
+
+	return "Unknown"
+}
+
+// PeekMode returns the mode of an encoded message without decoding its
+// entries. It returns ModeUnknown if bits does not hold a message.
+func PeekMode(bits []byte) MessageMode {
+	sz, bits, err := msgp.ReadArrayHeaderBytes(bits)
+	if err != nil || sz < 2 || sz > 4 {
+		return ModeUnknown
+	}
+
+	if bits, err = msgp.Skip(bits); err != nil {
+		return ModeUnknown
+	}
+
+	switch msgp.NextType(bits) {
+	case msgp.ArrayType:
+		return ModeForward
+	case msgp.BinType, msgp.StrType:
+	case msgp.InvalidType:
+		return ModeUnknown
+	default:
+		return ModeMessage
+	}
+
+	if sz == 2 {
+		return ModePackedForward
+	}
+
+	if bits, err = msgp.Skip(bits); err != nil {
+		return ModeUnknown
+	}
+
+	var opts MessageOptions
+	if msgp.NextType(bits) == msgp.MapType {
+		if _, err = opts.UnmarshalMsg(bits); err == nil && opts.Compressed == OptValGZIP {
+			return ModeCompressedPackedForward
+		}
+	}
+
+	return ModePackedForward
+}
+
+// DecodedMessage is the mode-agnostic representation of a message as
+// received by a server. The entries of every mode are decoded into an
+// EntryList; Message and MessageExt produce a single entry, and packed
+// streams are decompressed when necessary.
+type DecodedMessage struct {
+	Mode    MessageMode
+	Tag     string
+	Entries EntryList
+	Options *MessageOptions
+}
+
+// UnmarshalMsg decodes a message of any mode. Timestamps may be encoded
+// as EventTime extensions, integers, or floats.
+func (dm *DecodedMessage) UnmarshalMsg(bits []byte) ([]byte, error) {
+	var (
+		sz  uint32
+		err error
+	)
+
+	if sz, bits, err = msgp.ReadArrayHeaderBytes(bits); err != nil {
+		return bits, msgp.WrapError(err, "Array Header")
+	}
+
+	if sz < 2 || sz > 4 {
+		return bits, fmt.Errorf("unexpected array size %d", sz)
+	}
+
+	if dm.Tag, bits, err = msgp.ReadStringBytes(bits); err != nil {
+		return bits, msgp.WrapError(err, "Tag")
+	}
+
+	dm.Entries = dm.Entries[:0]
+	dm.Options = nil
+	optionsIndex := uint32(2)
+
+	var stream []byte
+
+	switch msgp.NextType(bits) {
+	case msgp.ArrayType:
+		dm.Mode = ModeForward
+		bits, err = dm.unmarshalForward(bits)
+	case msgp.BinType:
+		dm.Mode = ModePackedForward
+
+		if stream, bits, err = msgp.ReadBytesZC(bits); err != nil {
+			return bits, msgp.WrapError(err, "EventStream")
+		}
+	case msgp.StrType:
+		dm.Mode = ModePackedForward
+
+		if stream, bits, err = msgp.ReadStringZC(bits); err != nil {
+			return bits, msgp.WrapError(err, "EventStream")
+		}
+	default:
+		dm.Mode = ModeMessage
+		optionsIndex = 3
+
+		var entry EntryExt
+		if entry.Timestamp, bits, err = unmarshalTimestamp(bits); err != nil {
+			return bits, msgp.WrapError(err, "Timestamp")
+		}
+
+		if entry.Record, bits, err = msgp.ReadIntfBytes(bits); err != nil {
+			return bits, msgp.WrapError(err, "Record")
+		}
+
+		dm.Entries = append(dm.Entries, entry)
+	}
+
+	if err != nil {
+		return bits, err
+	}
+
+	if sz > optionsIndex {
+		if msgp.NextType(bits) == msgp.NilType {
+			bits, err = msgp.ReadNilBytes(bits)
+		} else {
+			dm.Options = &MessageOptions{}
+			bits, err = dm.Options.UnmarshalMsg(bits)
+		}
+
+		if err != nil {
+			return bits, msgp.WrapError(err, "Options")
+		}
+	}
+
+	if dm.Mode == ModePackedForward {
+		// the options determine whether the stream is compressed
+		if dm.Options != nil && dm.Options.Compressed == OptValGZIP {
+			dm.Mode = ModeCompressedPackedForward
+		}
+
+		err = dm.unmarshalStream(stream)
+	}
+
+	return bits, err
+}
+
+func (dm *DecodedMessage) unmarshalForward(bits []byte) ([]byte, error) {
+	sz, bits, err := msgp.ReadArrayHeaderBytes(bits)
+	if err != nil {
+		return bits, msgp.WrapError(err, "Entries")
+	}
+
+	for i := uint32(0); i < sz; i++ {
+		var entry EntryExt
+
+		if entry, bits, err = unmarshalEntry(bits); err != nil {
+			return bits, msgp.WrapError(err, "Entries", i)
+		}
+
+		dm.Entries = append(dm.Entries, entry)
+	}
+
+	return bits, nil
+}
+
+func (dm *DecodedMessage) unmarshalStream(stream []byte) error {
+	if dm.Mode == ModeCompressedPackedForward {
+		var err error
+		if stream, err = gunzip(stream); err != nil {
+			return msgp.WrapError(err, "EventStream")
+		}
+	}
+
+	entries, err := UnmarshalEventStream(stream)
+	if err != nil {
+		return msgp.WrapError(err, "EventStream")
+	}
+
+	dm.Entries = append(dm.Entries, entries...)
+
+	return nil
+}
+
+// MaxDecompressedSize limits the size of the event stream of a
+// CompressedPackedForward message once it is decompressed, so that a small
+// message cannot exhaust memory.
+var MaxDecompressedSize int64 = 256 << 20
+
+// ErrDecompressedTooLarge is returned for compressed event streams that
+// exceed MaxDecompressedSize.
+var ErrDecompressedTooLarge = errors.New("decompressed event stream is too large")
+
+func gunzip(bits []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(bits))
+	if err != nil {
+		return nil, err
+	}
+
+	defer zr.Close()
+
+	stream, err := io.ReadAll(io.LimitReader(zr, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(stream)) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return stream, nil
+}
+
+// UnmarshalEventStream decodes a msgpack event stream, such as the payload
+// of a PackedForwardMessage, into an EntryList. Unlike
+// EntryList.UnmarshalPacked, it accepts integer and float timestamps as
+// well as the [timestamp, metadata] pairs written by newer Fluent Bit
+// versions, whose metadata is discarded.
+func UnmarshalEventStream(bits []byte) (EntryList, error) {
+	var (
+		entries EntryList
+		entry   EntryExt
+		err     error
+	)
+
+	for len(bits) > 0 {
+		if entry, bits, err = unmarshalEntry(bits); err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func unmarshalEntry(bits []byte) (EntryExt, []byte, error) {
+	var entry EntryExt
+
+	sz, bits, err := msgp.ReadArrayHeaderBytes(bits)
+	if err != nil {
+		return entry, bits, err
+	}
+
+	if sz < 2 {
+		return entry, bits, fmt.Errorf("unexpected entry size %d", sz)
+	}
+
+	if entry.Timestamp, bits, err = unmarshalTimestamp(bits); err != nil {
+		return entry, bits, msgp.WrapError(err, "Timestamp")
+	}
+
+	if entry.Record, bits, err = msgp.ReadIntfBytes(bits); err != nil {
+		return entry, bits, msgp.WrapError(err, "Record")
+	}
+
+	for i := uint32(2); i < sz && err == nil; i++ {
+		bits, err = msgp.Skip(bits)
+	}
+
+	return entry, bits, err
+}
+
+func unmarshalTimestamp(bits []byte) (EventTime, []byte, error) {
+	var (
+		et  EventTime
+		err error
+	)
+
+	switch msgp.NextType(bits) {
+	case msgp.ExtensionType:
+		bits, err = msgp.ReadExtensionBytes(bits, &et)
+	case msgp.IntType, msgp.UintType:
+		var secs int64
+
+		if secs, bits, err = msgp.ReadInt64Bytes(bits); err == nil {
+			et.Time = time.Unix(secs, 0)
+		}
+	case msgp.Float64Type, msgp.Float32Type:
+		var f float64
+
+		if f, bits, err = msgp.ReadFloat64Bytes(bits); err == nil {
+			secs, frac := math.Modf(f)
+			et.Time = time.Unix(int64(secs), int64(frac*1e9))
+		}
+	case msgp.ArrayType:
+		// Fluent Bit v2 format: [[timestamp, metadata], record]
+		var sz uint32
+
+		if sz, bits, err = msgp.ReadArrayHeaderBytes(bits); err != nil {
+			return et, bits, err
+		}
+
+		if sz == 0 {
+			return et, bits, errors.New("empty timestamp array")
+		}
+
+		if et, bits, err = unmarshalTimestamp(bits); err != nil {
+			return et, bits, err
+		}
+
+		for i := uint32(1); i < sz && err == nil; i++ {
+			bits, err = msgp.Skip(bits)
+		}
+	default:
+		err = fmt.Errorf("unexpected timestamp type %s", msgp.NextType(bits))
+	}
+
+	return et, bits, err
+}
@@ -0,0 +1,197 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package protocol_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+)
+
+var _ = Describe("DecodedMessage", func() {
+	var (
+		entries protocol.EntryList
+		dm      protocol.DecodedMessage
+	)
+
+	BeforeEach(func() {
+		ts := time.Unix(1700000000, 123456789)
+		entries = protocol.EntryList{
+			{
+				Timestamp: protocol.EventTime{Time: ts},
+				Record:    map[string]interface{}{"first": "Sir"},
+			},
+			{
+				Timestamp: protocol.EventTime{Time: ts.Add(time.Second)},
+				Record:    map[string]interface{}{"last": "Gawain"},
+			},
+		}
+		dm = protocol.DecodedMessage{}
+	})
+
+	unmarshal := func(m msgp.Marshaler) {
+		bits, err := m.MarshalMsg(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		left, err := dm.UnmarshalMsg(bits)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(left).To(BeEmpty())
+	}
+
+	It("decodes a Message", func() {
+		msg := protocol.NewMessage("foo", entries[0].Record)
+		_, _ = msg.Chunk()
+		unmarshal(msg)
+
+		Expect(dm.Mode).To(Equal(protocol.ModeMessage))
+		Expect(dm.Tag).To(Equal("foo"))
+		Expect(dm.Options.Chunk).To(Equal(msg.Options.Chunk))
+		Expect(dm.Entries).To(HaveLen(1))
+		Expect(dm.Entries[0].Timestamp.Unix()).To(Equal(msg.Timestamp))
+		Expect(dm.Entries[0].Record).To(Equal(entries[0].Record))
+	})
+
+	It("decodes a MessageExt", func() {
+		msg := &protocol.MessageExt{
+			Tag:       "foo",
+			Timestamp: entries[0].Timestamp,
+			Record:    entries[0].Record,
+		}
+		unmarshal(msg)
+
+		Expect(dm.Mode).To(Equal(protocol.ModeMessage))
+		Expect(dm.Options).To(BeNil())
+		Expect(dm.Entries[0].Timestamp.Equal(entries[0].Timestamp.Time)).To(BeTrue())
+	})
+
+	It("decodes a ForwardMessage", func() {
+		unmarshal(protocol.NewForwardMessage("foo", entries))
+
+		Expect(dm.Mode).To(Equal(protocol.ModeForward))
+		Expect(*dm.Options.Size).To(Equal(2))
+		Expect(dm.Entries.Equal(entries)).To(BeTrue())
+	})
+
+	It("decodes a PackedForwardMessage", func() {
+		msg, err := protocol.NewPackedForwardMessage("foo", entries)
+		Expect(err).ToNot(HaveOccurred())
+		unmarshal(msg)
+
+		Expect(dm.Mode).To(Equal(protocol.ModePackedForward))
+		Expect(dm.Entries.Equal(entries)).To(BeTrue())
+	})
+
+	It("decodes a compressed PackedForwardMessage", func() {
+		msg, err := protocol.NewCompressedPackedForwardMessage("foo", entries)
+		Expect(err).ToNot(HaveOccurred())
+		unmarshal(msg)
+
+		Expect(dm.Mode).To(Equal(protocol.ModeCompressedPackedForward))
+		Expect(dm.Options.Compressed).To(Equal(protocol.OptValGZIP))
+		Expect(dm.Entries.Equal(entries)).To(BeTrue())
+	})
+
+	It("limits the size of a decompressed event stream", func() {
+		max := protocol.MaxDecompressedSize
+		protocol.MaxDecompressedSize = 16
+		DeferCleanup(func() {
+			protocol.MaxDecompressedSize = max
+		})
+
+		msg, err := protocol.NewCompressedPackedForwardMessage("foo", entries)
+		Expect(err).ToNot(HaveOccurred())
+
+		bits, err := msg.MarshalMsg(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = dm.UnmarshalMsg(bits)
+		Expect(errors.Is(err, protocol.ErrDecompressedTooLarge)).To(BeTrue())
+	})
+
+	It("decodes integer timestamps and Fluent Bit metadata in event streams", func() {
+		var stream []byte
+		stream = msgp.AppendArrayHeader(stream, 2)
+		stream = msgp.AppendInt64(stream, 1700000000)
+		stream = msgp.AppendMapStrStr(stream, map[string]string{"a": "b"})
+		stream = msgp.AppendArrayHeader(stream, 2)
+		stream = msgp.AppendArrayHeader(stream, 2)
+		stream, _ = msgp.AppendExtension(stream, &entries[1].Timestamp)
+		stream = msgp.AppendMapHeader(stream, 0)
+		stream = msgp.AppendMapStrStr(stream, map[string]string{"c": "d"})
+
+		el, err := protocol.UnmarshalEventStream(stream)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(el).To(HaveLen(2))
+		Expect(el[0].Timestamp.Unix()).To(Equal(int64(1700000000)))
+		Expect(el[0].Record).To(Equal(map[string]interface{}{"a": "b"}))
+		Expect(el[1].Timestamp.Equal(entries[1].Timestamp.Time)).To(BeTrue())
+		Expect(el[1].Record).To(Equal(map[string]interface{}{"c": "d"}))
+	})
+
+	It("returns an error for messages that are not arrays", func() {
+		_, err := dm.UnmarshalMsg(msgp.AppendString(nil, "foo"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("PeekMode", func() {
+	It("returns the mode of every message type", func() {
+		entries := protocol.EntryList{
+			{Timestamp: protocol.EventTimeNow(), Record: map[string]interface{}{"first": "Sir"}},
+		}
+
+		packed, err := protocol.NewPackedForwardMessage("foo", entries)
+		Expect(err).ToNot(HaveOccurred())
+		compressed, err := protocol.NewCompressedPackedForwardMessage("foo", entries)
+		Expect(err).ToNot(HaveOccurred())
+
+		packedNoOpts := &protocol.PackedForwardMessage{Tag: "foo", EventStream: packed.EventStream}
+
+		cases := []struct {
+			msg  msgp.Marshaler
+			mode protocol.MessageMode
+		}{
+			{protocol.NewMessage("foo", entries[0].Record), protocol.ModeMessage},
+			{protocol.NewMessageExt("foo", entries[0].Record), protocol.ModeMessage},
+			{protocol.NewForwardMessage("foo", entries), protocol.ModeForward},
+			{packed, protocol.ModePackedForward},
+			{packedNoOpts, protocol.ModePackedForward},
+			{compressed, protocol.ModeCompressedPackedForward},
+		}
+
+		for _, c := range cases {
+			bits, err := c.msg.MarshalMsg(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protocol.PeekMode(bits)).To(Equal(c.mode))
+		}
+
+		Expect(protocol.PeekMode(msgp.AppendString(nil, "foo"))).To(Equal(protocol.ModeUnknown))
+	})
+})
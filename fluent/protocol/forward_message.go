@@ -207,17 +207,14 @@ func (fm *ForwardMessage) Msgsize() (s int) {
 	return
 }
 
+// Chunk returns the chunk ID in the options, generating it with
+// DefaultChunkIDGenerator if there is none.
 func (fm *ForwardMessage) Chunk() (string, error) {
-	if fm.Options == nil {
-		fm.Options = &MessageOptions{}
-	}
-
-	if fm.Options.Chunk != "" {
-		return fm.Options.Chunk, nil
-	}
-
-	chunk, err := makeChunkID()
-	fm.Options.Chunk = chunk
+	return fm.ChunkWith(nil)
+}
 
-	return chunk, err
+// ChunkWith is like Chunk, but generates a missing chunk ID with gen, or
+// DefaultChunkIDGenerator if gen is nil.
+func (fm *ForwardMessage) ChunkWith(gen ChunkIDGenerator) (string, error) {
+	return chunkWith(&fm.Options, fm, gen)
 }
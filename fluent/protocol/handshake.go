@@ -92,6 +92,19 @@ func NewPingWithAuth(hostname string, sharedKey, salt, nonce []byte, username, p
 	return makePing(hostname, sharedKey, salt, nonce, username, password)
 }
 
+// ComputePasswordDigest returns the hex-encoded SHA512 digest of the
+// auth salt, username, and password. Servers that require user
+// authentication send the auth salt in the Helo and expect this digest
+// as the password in the Ping.
+func ComputePasswordDigest(authSalt []byte, username, password string) string {
+	h := sha512.New()
+	h.Write(authSalt)
+	_, _ = io.WriteString(h, username)
+	_, _ = io.WriteString(h, password)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func makePing(hostname string, sharedKey, salt, nonce []byte, creds ...string) (*Ping, error) {
 	hexDigest, err := computeHexDigest(salt, hostname, nonce, sharedKey)
 
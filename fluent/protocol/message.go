@@ -151,19 +151,16 @@ func (msg *Message) Msgsize() (s int) {
 	return s
 }
 
+// Chunk returns the chunk ID in the options, generating it with
+// DefaultChunkIDGenerator if there is none.
 func (msg *Message) Chunk() (string, error) {
-	if msg.Options == nil {
-		msg.Options = &MessageOptions{}
-	}
-
-	if msg.Options.Chunk != "" {
-		return msg.Options.Chunk, nil
-	}
-
-	chunk, err := makeChunkID()
-	msg.Options.Chunk = chunk
+	return msg.ChunkWith(nil)
+}
 
-	return chunk, err
+// ChunkWith is like Chunk, but generates a missing chunk ID with gen, or
+// DefaultChunkIDGenerator if gen is nil.
+func (msg *Message) ChunkWith(gen ChunkIDGenerator) (string, error) {
+	return chunkWith(&msg.Options, msg, gen)
 }
 
 // MessageExt
@@ -283,17 +280,14 @@ func (msg *MessageExt) Msgsize() (s int) {
 	return
 }
 
+// Chunk returns the chunk ID in the options, generating it with
+// DefaultChunkIDGenerator if there is none.
 func (msg *MessageExt) Chunk() (string, error) {
-	if msg.Options == nil {
-		msg.Options = &MessageOptions{}
-	}
-
-	if msg.Options.Chunk != "" {
-		return msg.Options.Chunk, nil
-	}
-
-	chunk, err := makeChunkID()
-	msg.Options.Chunk = chunk
+	return msg.ChunkWith(nil)
+}
 
-	return chunk, err
+// ChunkWith is like Chunk, but generates a missing chunk ID with gen, or
+// DefaultChunkIDGenerator if gen is nil.
+func (msg *MessageExt) ChunkWith(gen ChunkIDGenerator) (string, error) {
+	return chunkWith(&msg.Options, msg, gen)
 }
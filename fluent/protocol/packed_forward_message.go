@@ -160,19 +160,16 @@ func (msg *PackedForwardMessage) Msgsize() (s int) {
 	return
 }
 
+// Chunk returns the chunk ID in the options, generating it with
+// DefaultChunkIDGenerator if there is none.
 func (msg *PackedForwardMessage) Chunk() (string, error) {
-	if msg.Options == nil {
-		msg.Options = &MessageOptions{}
-	}
-
-	if msg.Options.Chunk != "" {
-		return msg.Options.Chunk, nil
-	}
-
-	chunk, err := makeChunkID()
-	msg.Options.Chunk = chunk
+	return msg.ChunkWith(nil)
+}
 
-	return chunk, err
+// ChunkWith is like Chunk, but generates a missing chunk ID with gen, or
+// DefaultChunkIDGenerator if gen is nil.
+func (msg *PackedForwardMessage) ChunkWith(gen ChunkIDGenerator) (string, error) {
+	return chunkWith(&msg.Options, msg, gen)
 }
 
 //msgp:ignore GzipCompressor
@@ -249,7 +246,11 @@ func NewCompressedPackedForwardMessageFromBytes(
 		return nil, err
 	}
 
-	pfm := NewPackedForwardMessageFromBytes(tag, mc.Bytes())
+	// mc goes back to the pool, so its buffer cannot be kept
+	bits := make([]byte, mc.Buffer.Len())
+	copy(bits, mc.Bytes())
+
+	pfm := NewPackedForwardMessageFromBytes(tag, bits)
 	pfm.Options = &MessageOptions{Compressed: "gzip"}
 
 	return pfm, nil
@@ -25,7 +25,6 @@ SOFTWARE.
 package protocol
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"reflect"
@@ -55,7 +54,6 @@ const (
 var (
 	compressorPool  sync.Pool
 	chunkReaderPool sync.Pool
-	bufferPool      sync.Pool
 )
 
 func init() {
@@ -72,10 +70,6 @@ func init() {
 	chunkReaderPool.New = func() interface{} {
 		return new(ChunkReader)
 	}
-
-	bufferPool.New = func() interface{} {
-		return new(bytes.Buffer)
-	}
 }
 
 // EventTime is the fluent-forward representation of a timestamp
@@ -160,21 +154,75 @@ func (el *EntryList) UnmarshalPacked(bits []byte) ([]byte, error) {
 	return bits, err
 }
 
+// MarshalPacked returns the entries as a packed event stream, for use in a
+// PackedForwardMessage.
 func (el EntryList) MarshalPacked() ([]byte, error) {
-	buf := bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
+	return el.AppendPacked(nil)
+}
 
-	defer func() {
-		bufferPool.Put(buf)
-	}()
+// AppendPacked appends the entries to b as a packed event stream. It does
+// not allocate when b has enough capacity and the records are
+// map[string]interface{}, msgp.Encodable, or basic types; other records
+// are encoded through reflection, as EncodeMsg does.
+func (el EntryList) AppendPacked(b []byte) ([]byte, error) {
+	var err error
+
+	for i := range el {
+		// array header, size 2
+		b = append(b, 0x92)
+
+		if b, err = msgp.AppendExtension(b, &el[i].Timestamp); err != nil {
+			return b, msgp.WrapError(err, i, "Timestamp")
+		}
 
-	for _, e := range el {
-		if err := msgp.Encode(buf, e); err != nil {
-			return nil, err
+		if b, err = appendRecord(b, el[i].Record); err != nil {
+			return b, msgp.WrapError(err, i, "Record")
 		}
 	}
 
-	return buf.Bytes(), nil
+	return b, nil
+}
+
+// appendRecord appends record to b. msgp.AppendIntf only supports the
+// types it can encode without reflection, so the record is encoded with a
+// msgp.Writer, which supports the same types as EncodeMsg, when it fails
+// with an unsupported type.
+func appendRecord(b []byte, record interface{}) ([]byte, error) {
+	o, err := msgp.AppendIntf(b, record)
+	if err == nil {
+		return o, nil
+	}
+
+	// declared here, since errors.As moves it to the heap
+	var unsupported *msgp.ErrUnsupportedType
+	if !errors.As(err, &unsupported) {
+		return o, err
+	}
+
+	// drop what was appended before the failure
+	w := appendWriter{b: b}
+	mw := msgp.NewWriter(&w)
+
+	if err = mw.WriteIntf(record); err == nil {
+		err = mw.Flush()
+	}
+
+	if err != nil {
+		return b, err
+	}
+
+	return w.b, nil
+}
+
+// appendWriter is an io.Writer that appends to a byte slice.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+
+	return len(p), nil
 }
 
 // Equal compares two EntryList objects and returns true if they have
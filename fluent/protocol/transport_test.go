@@ -25,6 +25,7 @@ SOFTWARE.
 package protocol_test
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 	"time"
@@ -33,6 +34,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
 )
 
 var _ = Describe("Transport", func() {
@@ -132,6 +134,64 @@ var _ = Describe("Transport", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(el.Equal(e2)).To(BeTrue())
 			})
+
+			It("Returns a stream that later calls do not overwrite", func() {
+				b1, err := e1[:1].MarshalPacked()
+				Expect(err).ToNot(HaveOccurred())
+
+				expected := append([]byte(nil), b1...)
+
+				_, err = e2[1:].MarshalPacked()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b1).To(Equal(expected))
+			})
+
+			It("Encodes records that msgp encodes through reflection", func() {
+				el := protocol.EntryList{
+					{
+						Timestamp: protocol.EventTime{et},
+						Record:    map[string]int{"count": 3},
+					},
+					{
+						Timestamp: protocol.EventTime{et},
+						Record: map[string]interface{}{
+							"elapsed": 1500 * time.Millisecond,
+						},
+					},
+				}
+
+				var buf bytes.Buffer
+				for _, entry := range el {
+					Expect(msgp.Encode(&buf, entry)).To(Succeed())
+				}
+
+				b, err := el.MarshalPacked()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(Equal(buf.Bytes()))
+
+				cmp, err := protocol.NewCompressedPackedForwardMessage("foo", el)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cmp.EventStream).ToNot(BeEmpty())
+
+				decoded := protocol.EntryList{}
+				_, err = decoded.UnmarshalPacked(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(decoded[0].Record).To(Equal(map[string]interface{}{"count": int64(3)}))
+				Expect(decoded[1].Record).To(Equal(map[string]interface{}{
+					"elapsed": int64(1500 * time.Millisecond),
+				}))
+			})
+
+			It("Appends the packed entries with AppendPacked", func() {
+				appended, err := e2.AppendPacked([]byte{0x01, 0x02})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(appended[:2]).To(Equal([]byte{0x01, 0x02}))
+
+				el := protocol.EntryList{}
+				_, err = el.UnmarshalPacked(appended[2:])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(el.Equal(e2)).To(BeTrue())
+			})
 		})
 
 		Describe("Equal", func() {
@@ -261,5 +321,16 @@ var _ = Describe("Transport", func() {
 			Expect(*msg.Options.Size).To(Equal(len(entries)))
 			Expect(msg.Options.Compressed).To(Equal("gzip"))
 		})
+
+		It("Returns a stream that later calls do not overwrite", func() {
+			msg, err := protocol.NewCompressedPackedForwardMessage(tag, entries[:1])
+			Expect(err).ToNot(HaveOccurred())
+
+			expected := append([]byte(nil), msg.EventStream...)
+
+			_, err = protocol.NewCompressedPackedForwardMessage(tag, entries[1:])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.EventStream).To(Equal(expected))
+		})
 	})
 })
@@ -0,0 +1,210 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDedupWindow    = 5 * time.Minute
+	DefaultDedupMaxChunks = 100000
+)
+
+// DedupOptions configures a Deduplicator.
+type DedupOptions struct {
+	// Window is how long a chunk ID is remembered after its message was
+	// handled. Defaults to DefaultDedupWindow.
+	Window time.Duration
+	// MaxChunks is the most chunk IDs remembered; the oldest are forgotten
+	// first. Defaults to DefaultDedupMaxChunks.
+	MaxChunks int
+}
+
+type seenChunk struct {
+	chunk string
+	at    time.Time
+}
+
+// Deduplicator remembers the chunk IDs of the messages handled recently, so
+// that a message resent after its ack was lost is acked again without
+// being handled twice.
+type Deduplicator struct {
+	window    time.Duration
+	maxChunks int
+
+	lock sync.Mutex
+	seen map[string]time.Time
+	// inFlight holds the chunk IDs of the messages being handled. Their
+	// channels are closed once next returns.
+	inFlight map[string]chan struct{}
+	// order holds the chunk IDs in the order they were handled.
+	order      []seenChunk
+	duplicates uint64
+}
+
+func NewDeduplicator(opts DedupOptions) *Deduplicator {
+	d := &Deduplicator{
+		window:    opts.Window,
+		maxChunks: opts.MaxChunks,
+		seen:      map[string]time.Time{},
+		inFlight:  map[string]chan struct{}{},
+	}
+
+	if d.window <= 0 {
+		d.window = DefaultDedupWindow
+	}
+
+	if d.maxChunks <= 0 {
+		d.maxChunks = DefaultDedupMaxChunks
+	}
+
+	return d
+}
+
+// Handler returns a Handler that calls next for the messages whose chunk
+// ID was not seen within the window, and returns nil for the others, so
+// that they are acked. A chunk ID is only remembered once next succeeds,
+// so a resend of a message that failed is handled again. A resend that
+// arrives while the first copy is still being handled, for example after
+// the client timed out waiting for its ack, waits for the result of the
+// first copy. Messages without a chunk ID are always handled.
+func (d *Deduplicator) Handler(next Handler) Handler {
+	return func(msg *Message) (err error) {
+		if msg.Options == nil || msg.Options.Chunk == "" {
+			return next(msg)
+		}
+
+		chunk := msg.Options.Chunk
+
+		if !d.reserve(chunk) {
+			return nil
+		}
+
+		defer func() {
+			d.release(chunk, err == nil)
+		}()
+
+		return next(msg)
+	}
+}
+
+// reserve marks chunk as in flight and returns true, or returns false if
+// a message with chunk ID chunk was already handled. It waits while
+// another copy of the message is in flight.
+func (d *Deduplicator) reserve(chunk string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for {
+		d.expire(time.Now())
+
+		if _, ok := d.seen[chunk]; ok {
+			d.duplicates++
+
+			return false
+		}
+
+		done, ok := d.inFlight[chunk]
+		if !ok {
+			d.inFlight[chunk] = make(chan struct{})
+
+			return true
+		}
+
+		d.lock.Unlock()
+		<-done
+		d.lock.Lock()
+	}
+}
+
+// release ends the flight of chunk and, if its message was handled,
+// remembers it.
+func (d *Deduplicator) release(chunk string, handled bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	close(d.inFlight[chunk])
+	delete(d.inFlight, chunk)
+
+	if handled {
+		d.add(chunk)
+	}
+}
+
+// Seen reports whether a message with chunk ID chunk was handled within
+// the window.
+func (d *Deduplicator) Seen(chunk string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire(time.Now())
+
+	_, ok := d.seen[chunk]
+
+	return ok
+}
+
+// Duplicates returns the number of messages that were not handled because
+// their chunk ID was seen.
+func (d *Deduplicator) Duplicates() uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.duplicates
+}
+
+// add must be called with the lock held.
+func (d *Deduplicator) add(chunk string) {
+	now := time.Now()
+
+	d.seen[chunk] = now
+	d.order = append(d.order, seenChunk{chunk: chunk, at: now})
+
+	for len(d.seen) > d.maxChunks {
+		d.forget()
+	}
+}
+
+// expire must be called with the lock held.
+func (d *Deduplicator) expire(now time.Time) {
+	for len(d.order) > 0 && now.Sub(d.order[0].at) >= d.window {
+		d.forget()
+	}
+}
+
+// forget removes the oldest chunk ID. It must be called with the lock
+// held.
+func (d *Deduplicator) forget() {
+	delete(d.seen, d.order[0].chunk)
+	d.order[0] = seenChunk{}
+	d.order = d.order[1:]
+
+	// let the backing array be collected once it is mostly unused
+	if cap(d.order) > 1024 && len(d.order) < cap(d.order)/4 {
+		d.order = append([]seenChunk(nil), d.order...)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package server_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deduplicator", func() {
+	var (
+		opts    server.DedupOptions
+		d       *server.Deduplicator
+		handled []string
+		errNext error
+		handler server.Handler
+	)
+
+	message := func(chunk string) *server.Message {
+		msg := &server.Message{}
+		msg.Tag = "foo"
+
+		if len(chunk) > 0 {
+			msg.Options = &protocol.MessageOptions{Chunk: chunk}
+		}
+
+		return msg
+	}
+
+	BeforeEach(func() {
+		opts = server.DedupOptions{}
+		handled = nil
+		errNext = nil
+	})
+
+	JustBeforeEach(func() {
+		d = server.NewDeduplicator(opts)
+		handler = d.Handler(func(msg *server.Message) error {
+			if errNext != nil {
+				return errNext
+			}
+
+			handled = append(handled, msg.Tag)
+
+			return nil
+		})
+	})
+
+	It("handles a chunk once", func() {
+		Expect(handler(message("a"))).To(Succeed())
+		Expect(handler(message("a"))).To(Succeed())
+		Expect(handler(message("b"))).To(Succeed())
+
+		Expect(handled).To(HaveLen(2))
+		Expect(d.Seen("a")).To(BeTrue())
+		Expect(d.Duplicates()).To(BeEquivalentTo(1))
+	})
+
+	It("always handles messages without a chunk", func() {
+		Expect(handler(message(""))).To(Succeed())
+		Expect(handler(message(""))).To(Succeed())
+
+		Expect(handled).To(HaveLen(2))
+	})
+
+	It("handles the resend of a message that failed", func() {
+		errNext = errors.New("nope")
+		Expect(handler(message("a"))).To(MatchError("nope"))
+		Expect(d.Seen("a")).To(BeFalse())
+
+		errNext = nil
+		Expect(handler(message("a"))).To(Succeed())
+		Expect(handled).To(HaveLen(1))
+	})
+
+	When("a resend arrives while the first copy is being handled", func() {
+		var (
+			release chan error
+			calls   int32
+		)
+
+		JustBeforeEach(func() {
+			release = make(chan error)
+			calls = 0
+
+			handler = d.Handler(func(*server.Message) error {
+				atomic.AddInt32(&calls, 1)
+
+				return <-release
+			})
+		})
+
+		It("does not handle the resend if the first copy succeeds", func() {
+			first := make(chan error, 1)
+			go func() { first <- handler(message("a")) }()
+			Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(BeEquivalentTo(1))
+
+			resend := make(chan error, 1)
+			go func() { resend <- handler(message("a")) }()
+			Consistently(resend).ShouldNot(Receive())
+
+			release <- nil
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(resend).Should(Receive(BeNil()))
+
+			Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+			Expect(d.Duplicates()).To(BeEquivalentTo(1))
+		})
+
+		It("handles the resend if the first copy fails", func() {
+			first := make(chan error, 1)
+			go func() { first <- handler(message("a")) }()
+			Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(BeEquivalentTo(1))
+
+			resend := make(chan error, 1)
+			go func() { resend <- handler(message("a")) }()
+
+			release <- errors.New("nope")
+			Eventually(first).Should(Receive(MatchError("nope")))
+
+			release <- nil
+			Eventually(resend).Should(Receive(BeNil()))
+
+			Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+			Expect(d.Seen("a")).To(BeTrue())
+		})
+	})
+
+	When("the window expires", func() {
+		BeforeEach(func() {
+			opts.Window = 20 * time.Millisecond
+		})
+
+		It("forgets the chunk", func() {
+			Expect(handler(message("a"))).To(Succeed())
+			Eventually(func() bool { return d.Seen("a") }).Should(BeFalse())
+
+			Expect(handler(message("a"))).To(Succeed())
+			Expect(handled).To(HaveLen(2))
+		})
+	})
+
+	When("there are more than MaxChunks", func() {
+		BeforeEach(func() {
+			opts.MaxChunks = 3
+		})
+
+		It("forgets the oldest", func() {
+			for i := 0; i < 4; i++ {
+				Expect(handler(message(fmt.Sprint(i)))).To(Succeed())
+			}
+
+			Expect(d.Seen("0")).To(BeFalse())
+			Expect(d.Seen("1")).To(BeTrue())
+			Expect(d.Seen("3")).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,119 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// handshake performs the server side of the handshake:
+// HELO is sent, PING is received and validated, and PONG is sent.
+// An error is returned if the client fails to authenticate.
+func (s *Server) handshake(r *msgp.Reader, w *msgp.Writer) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	opts := &protocol.HeloOpts{
+		Nonce:     nonce,
+		Keepalive: true,
+	}
+
+	if len(s.opts.Users) > 0 {
+		opts.Auth = make([]byte, 16)
+		if _, err := rand.Read(opts.Auth); err != nil {
+			return err
+		}
+	}
+
+	helo := protocol.NewHelo(opts)
+	if err := helo.EncodeMsg(w); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var ping protocol.Ping
+	if err := ping.DecodeMsg(r); err != nil {
+		return err
+	}
+
+	if ping.MessageType != protocol.MsgTypePing {
+		return errors.New("expected PING, got " + ping.MessageType)
+	}
+
+	authResult, reason := s.authenticate(&ping, opts)
+
+	pong, err := protocol.NewPong(authResult, reason, s.opts.Hostname, s.opts.SharedKey, helo, &ping)
+	if err != nil {
+		return err
+	}
+
+	if err = pong.EncodeMsg(w); err != nil {
+		return err
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if !authResult {
+		return errors.New("handshake failed: " + reason)
+	}
+
+	return nil
+}
+
+func (s *Server) authenticate(ping *protocol.Ping, opts *protocol.HeloOpts) (bool, string) {
+	if err := protocol.ValidatePingDigest(ping, s.opts.SharedKey, opts.Nonce); err != nil {
+		return false, "shared key mismatch"
+	}
+
+	if len(s.opts.Users) == 0 {
+		return true, ""
+	}
+
+	password, ok := s.opts.Users[ping.Username]
+	if !ok {
+		return false, "username/password mismatch"
+	}
+
+	// compare in constant time, so that the time taken does not reveal
+	// how much of the digest matches
+	digest := protocol.ComputePasswordDigest(opts.Auth, ping.Username, password)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(ping.Password)) != 1 {
+		return false, "username/password mismatch"
+	}
+
+	return true, ""
+}
@@ -0,0 +1,366 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/gorilla/websocket"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// ErrServerClosed is returned by Serve after Close is called.
+var ErrServerClosed = errors.New("server closed")
+
+type noopLogger struct{}
+
+func (l *noopLogger) Println(_ ...interface{}) {}
+
+func (l *noopLogger) Printf(_ string, _ ...interface{}) {}
+
+// Message is a single message received by the Server.
+type Message struct {
+	protocol.DecodedMessage
+	// Raw is the message exactly as it was received.
+	Raw        []byte
+	RemoteAddr net.Addr
+	ReceivedAt time.Time
+}
+
+// Handler processes received messages. It is called sequentially for the
+// messages of a single connection, but concurrently across connections.
+// If Handler returns an error, the message is not acked.
+type Handler func(msg *Message) error
+
+type Options struct {
+	// Handler is invoked for every message received.
+	Handler Handler
+	// SharedKey enables the handshake for TCP connections. Clients must
+	// present the same key.
+	SharedKey []byte
+	// Users maps usernames to passwords. When not empty, clients must also
+	// authenticate with one of them during the handshake.
+	Users map[string]string
+	// Hostname is sent to clients in the PONG.
+	Hostname string
+	// DisableAck prevents the server from acking messages that carry a
+	// chunk option.
+	DisableAck bool
+	// ConnectionOptions configures the server side of websocket
+	// connections. The ReadHandler is replaced by the server.
+	ConnectionOptions ws.ConnectionOptions
+	// Upgrader upgrades websocket requests. The zero value is used if nil.
+	Upgrader *websocket.Upgrader
+	// Logger is an optional debug log writer.
+	Logger ws.Logger
+}
+
+// Server receives Fluent forward messages over net.Conn streams, such as
+// TCP, TLS and unix sockets, and over websocket connections, where every
+// binary frame holds a single message. Server is an http.Handler for the
+// latter.
+type Server struct {
+	opts      Options
+	logger    ws.Logger
+	upgrader  *websocket.Upgrader
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[io.Closer]struct{}
+	closed    bool
+}
+
+func New(opts Options) *Server {
+	s := &Server{
+		opts:      opts,
+		logger:    opts.Logger,
+		upgrader:  opts.Upgrader,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[io.Closer]struct{}{},
+	}
+
+	if s.logger == nil {
+		s.logger = &noopLogger{}
+	}
+
+	if s.upgrader == nil {
+		s.upgrader = &websocket.Upgrader{}
+	}
+
+	if s.opts.Handler == nil {
+		s.opts.Handler = func(*Message) error { return nil }
+	}
+
+	return s
+}
+
+func (s *Server) track(c io.Closer) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[c] = struct{}{}
+
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.conns, c)
+}
+
+// Serve accepts connections on l and serves each one in a new goroutine.
+// It blocks until l fails or Close is called, in which case it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.lock.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		go func() {
+			if err := s.ServeConn(conn); err != nil {
+				s.logger.Println("connection error:", err)
+			}
+		}()
+	}
+}
+
+// ServeConn performs the handshake, if required, and reads messages from
+// conn until the peer disconnects or an error occurs. The connection is
+// closed before ServeConn returns.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	if !s.track(conn) {
+		return ErrServerClosed
+	}
+
+	defer s.untrack(conn)
+
+	r := msgp.NewReader(conn)
+	w := msgp.NewWriter(conn)
+
+	if s.opts.SharedKey != nil {
+		if err := s.handshake(r, w); err != nil {
+			return err
+		}
+	}
+
+	writeAck := func(ack *protocol.AckMessage) error {
+		if err := ack.EncodeMsg(w); err != nil {
+			return err
+		}
+
+		return w.Flush()
+	}
+
+	for {
+		var buf bytes.Buffer
+
+		if _, err := r.CopyNext(&buf); err != nil {
+			if errors.Is(err, io.EOF) || s.isClosed() {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := s.handle(buf.Bytes(), conn.RemoteAddr(), writeAck); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.closed
+}
+
+// handle decodes and dispatches a single message. It returns an error only
+// when the connection can no longer be used.
+func (s *Server) handle(raw []byte, addr net.Addr, writeAck func(*protocol.AckMessage) error) error {
+	msg := &Message{
+		Raw:        raw,
+		RemoteAddr: addr,
+		ReceivedAt: time.Now(),
+	}
+
+	if _, err := msg.UnmarshalMsg(raw); err != nil {
+		return err
+	}
+
+	if err := s.opts.Handler(msg); err != nil {
+		s.logger.Println("handler error:", err)
+		return nil
+	}
+
+	if s.opts.DisableAck || msg.Options == nil || msg.Options.Chunk == "" {
+		return nil
+	}
+
+	return writeAck(&protocol.AckMessage{Ack: msg.Options.Chunk})
+}
+
+// ServeHTTP upgrades the request to a websocket connection and reads
+// messages until the peer closes it. Websocket clients do not perform
+// the handshake.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wc, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Println("upgrade error:", err)
+		return
+	}
+
+	opts := s.opts.ConnectionOptions
+	opts.ReadHandler = s.readHandler
+
+	if opts.Logger == nil {
+		opts.Logger = s.logger
+	}
+
+	connection, err := ws.NewConnection(wc, opts)
+	if err != nil {
+		s.logger.Println("connection error:", err)
+		_ = wc.Close()
+
+		return
+	}
+
+	if !s.track(connection) {
+		_ = connection.Close()
+		return
+	}
+
+	defer s.untrack(connection)
+
+	if err := connection.Listen(); err != nil &&
+		!websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		s.logger.Println("listen error:", err)
+	}
+}
+
+func (s *Server) readHandler(conn ws.Connection, _ int, p []byte, err error) error {
+	if err != nil {
+		if !conn.Closed() {
+			_ = conn.Close()
+		}
+
+		return err
+	}
+
+	err = s.handle(p, conn.RemoteAddr(), func(ack *protocol.AckMessage) error {
+		bits, err := ack.MarshalMsg(nil)
+		if err == nil {
+			_, err = conn.Write(bits)
+		}
+
+		return err
+	})
+
+	if err != nil && !conn.Closed() {
+		// CloseWithMsg waits for the peer's response, which is delivered
+		// by the read loop that is blocked on this handler
+		go func() {
+			_ = conn.CloseWithMsg(websocket.CloseUnsupportedData, err.Error())
+		}()
+	}
+
+	return err
+}
+
+// Close stops all listeners passed to Serve and closes all active
+// connections.
+func (s *Server) Close() error {
+	s.lock.Lock()
+
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+
+	s.closed = true
+
+	listeners := make([]io.Closer, 0, len(s.listeners))
+	for l := range s.listeners {
+		listeners = append(listeners, l)
+	}
+
+	conns := make([]io.Closer, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+
+	s.lock.Unlock()
+
+	var err error
+
+	for _, l := range listeners {
+		if lerr := l.Close(); lerr != nil {
+			err = lerr
+		}
+	}
+
+	// websocket connections wait for the peer to confirm the close
+	// and are therefore closed outside of the lock
+	for _, c := range conns {
+		_ = c.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,255 @@
+/*
+MIT License
+
+Copyright contributors to the fluent-forward-go project
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package server_test
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aanujj/fluent-forward-go/fluent/client"
+	"github.com/aanujj/fluent-forward-go/fluent/client/ws"
+	"github.com/aanujj/fluent-forward-go/fluent/protocol"
+	"github.com/aanujj/fluent-forward-go/fluent/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		opts     server.Options
+		svr      *server.Server
+		listener net.Listener
+		messages chan *server.Message
+		c        *client.Client
+		entries  protocol.EntryList
+	)
+
+	BeforeEach(func() {
+		messages = make(chan *server.Message, 8)
+		opts = server.Options{
+			Handler: func(msg *server.Message) error {
+				messages <- msg
+				return nil
+			},
+		}
+
+		entries = protocol.EntryList{
+			{
+				Timestamp: protocol.EventTimeNow(),
+				Record:    map[string]interface{}{"first": "Sir"},
+			},
+			{
+				Timestamp: protocol.EventTimeNow(),
+				Record:    map[string]interface{}{"last": "Gawain"},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		svr = server.New(opts)
+
+		go func() {
+			defer GinkgoRecover()
+			Expect(svr.Serve(listener)).To(MatchError(server.ErrServerClosed))
+		}()
+
+		c = client.New(client.ConnectionOptions{
+			Factory: &client.ConnFactory{
+				Address: listener.Addr().String(),
+			},
+			ConnectionTimeout: time.Second,
+		})
+	})
+
+	AfterEach(func() {
+		_ = c.Disconnect()
+		Expect(svr.Close()).To(Succeed())
+	})
+
+	Describe("Serve", func() {
+		JustBeforeEach(func() {
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		It("receives every message mode", func() {
+			Expect(c.SendMessage("msg", entries[0].Record)).To(Succeed())
+			Expect(c.SendForward("fwd", entries)).To(Succeed())
+			Expect(c.SendPacked("pkd", entries)).To(Succeed())
+			Expect(c.SendCompressed("cmp", entries)).To(Succeed())
+
+			expected := []struct {
+				tag  string
+				mode protocol.MessageMode
+				size int
+			}{
+				{"msg", protocol.ModeMessage, 1},
+				{"fwd", protocol.ModeForward, 2},
+				{"pkd", protocol.ModePackedForward, 2},
+				{"cmp", protocol.ModeCompressedPackedForward, 2},
+			}
+
+			for _, e := range expected {
+				var msg *server.Message
+				Eventually(messages).Should(Receive(&msg))
+				Expect(msg.Tag).To(Equal(e.tag))
+				Expect(msg.Mode).To(Equal(e.mode))
+				Expect(msg.Entries).To(HaveLen(e.size))
+				Expect(msg.Raw).ToNot(BeEmpty())
+				Expect(msg.RemoteAddr).ToNot(BeNil())
+			}
+		})
+
+		It("acks messages with a chunk", func() {
+			c.RequireAck = true
+			Expect(c.SendMessageExt("ack", entries[0].Record)).To(Succeed())
+			Eventually(messages).Should(Receive())
+		})
+
+		When("the handler returns an error", func() {
+			BeforeEach(func() {
+				opts.Handler = func(*server.Message) error {
+					return errors.New("nope")
+				}
+			})
+
+			It("does not ack", func() {
+				c.RequireAck = true
+				c.Timeout = 100 * time.Millisecond
+				Expect(c.SendMessageExt("ack", entries[0].Record)).ToNot(Succeed())
+			})
+		})
+
+		When("acks are disabled", func() {
+			BeforeEach(func() {
+				opts.DisableAck = true
+			})
+
+			It("does not ack", func() {
+				c.RequireAck = true
+				c.Timeout = 100 * time.Millisecond
+				Expect(c.SendMessageExt("ack", entries[0].Record)).ToNot(Succeed())
+				Eventually(messages).Should(Receive())
+			})
+		})
+	})
+
+	Describe("handshake", func() {
+		BeforeEach(func() {
+			opts.SharedKey = []byte("thisisasharedkey")
+			opts.Users = map[string]string{"gawain": "greenknight"}
+		})
+
+		JustBeforeEach(func() {
+			c.AuthInfo = client.AuthInfo{
+				SharedKey: []byte("thisisasharedkey"),
+				Username:  "gawain",
+				Password:  "greenknight",
+			}
+			Expect(c.Connect()).To(Succeed())
+		})
+
+		It("authenticates the client", func() {
+			Expect(c.Handshake()).To(Succeed())
+			Expect(c.SendMessage("foo", entries[0].Record)).To(Succeed())
+			Eventually(messages).Should(Receive())
+		})
+
+		It("rejects a bad shared key", func() {
+			c.AuthInfo.SharedKey = []byte("thisisthewrongkey")
+			Expect(c.Handshake()).To(MatchError(ContainSubstring("shared key mismatch")))
+		})
+
+		It("rejects a bad password", func() {
+			c.AuthInfo.Password = "wimpy music"
+			Expect(c.Handshake()).To(MatchError(ContainSubstring("username/password mismatch")))
+		})
+	})
+
+	Describe("ServeHTTP", func() {
+		var (
+			httpSvr  *httptest.Server
+			wsClient *client.WSClient
+			acks     chan protocol.AckMessage
+		)
+
+		JustBeforeEach(func() {
+			httpSvr = httptest.NewServer(svr)
+			acks = make(chan protocol.AckMessage, 1)
+
+			wsClient = client.NewWS(client.WSConnectionOptions{
+				Factory: &client.DefaultWSConnectionFactory{
+					URL: "ws" + strings.TrimPrefix(httpSvr.URL, "http"),
+				},
+				ConnectionOptions: ws.ConnectionOptions{
+					ReadHandler: func(conn ws.Connection, _ int, p []byte, err error) error {
+						if err != nil {
+							_ = conn.Close()
+							return err
+						}
+
+						var ack protocol.AckMessage
+						if _, err = ack.UnmarshalMsg(p); err == nil {
+							acks <- ack
+						}
+
+						return err
+					},
+				},
+			})
+
+			Expect(wsClient.Connect()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			_ = wsClient.Disconnect()
+			httpSvr.Close()
+		})
+
+		It("receives messages and acks chunks", func() {
+			msg, err := protocol.NewPackedForwardMessage("ws", entries)
+			Expect(err).ToNot(HaveOccurred())
+			chunk, err := msg.Chunk()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(wsClient.Send(msg)).To(Succeed())
+
+			var rcvd *server.Message
+			Eventually(messages).Should(Receive(&rcvd))
+			Expect(rcvd.Tag).To(Equal("ws"))
+			Expect(rcvd.Entries).To(HaveLen(2))
+
+			var ack protocol.AckMessage
+			Eventually(acks).Should(Receive(&ack))
+			Expect(ack.Ack).To(Equal(chunk))
+		})
+	})
+})